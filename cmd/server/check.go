@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"text/tabwriter"
+	"time"
+
+	"kerio-mirror-go/config"
+	"kerio-mirror-go/db"
+	"kerio-mirror-go/logging"
+	"kerio-mirror-go/mirror"
+)
+
+// runCheck implements the "check" subcommand: it compares local and upstream versions
+// without downloading anything and returns the process exit code
+// (0 - checked, 1 - some checks failed, 2 - bad arguments, config or a blackout window).
+func runCheck(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	cfgPath := fs.String("config", "config.yaml", "Path to config file")
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	force := fs.Bool("force", false, "Check even inside a blackout window")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := config.Load(*cfgPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
+		return 2
+	}
+	logger := logging.NewLogger(cfg.LogPath, cfg.LogLevel)
+	if err := mirror.ConfigureHTTPClient(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid HTTP client settings: %v\n", err)
+		return 2
+	}
+	if err := db.Init(cfg.DatabasePath); err != nil {
+		fmt.Fprintf(os.Stderr, "DB init error: %v\n", err)
+		return 2
+	}
+
+	if end := mirror.BlackoutUntil(cfg, time.Now()); !end.IsZero() && !*force {
+		fmt.Fprintf(os.Stderr, "Upstream requests are paused by a blackout window until %s, use -force to check anyway\n", end.Format("2006-01-02 15:04"))
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	report := mirror.Check(ctx, cfg, logger)
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			fmt.Fprintf(os.Stderr, "JSON encode error: %v\n", err)
+			return 2
+		}
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "DATABASE\tLOCAL\tREMOTE\tSTATUS")
+		for _, it := range report.Items {
+			status := "up to date"
+			switch {
+			case it.Error != "":
+				status = "error: " + it.Error
+			case it.UpdateAvailable:
+				status = "update available"
+			}
+			if it.Message != "" && it.Error == "" {
+				status += " (" + it.Message + ")"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", it.Name, it.Local, it.Remote, status)
+		}
+		w.Flush()
+		fmt.Printf("\n%d update(s) available, %d check(s) failed\n", report.Pending(), report.Failed())
+	}
+
+	if report.Failed() > 0 {
+		return 1
+	}
+	return 0
+}
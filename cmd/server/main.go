@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
 	"embed"
+	"errors"
 	"flag"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
 	"strings"
 	"sync"
+	"syscall"
 
 	"kerio-mirror-go/config"
 	"kerio-mirror-go/db"
@@ -13,6 +19,7 @@ import (
 	"kerio-mirror-go/logging"
 	"kerio-mirror-go/middleware"
 	"kerio-mirror-go/mirror"
+	"kerio-mirror-go/utils"
 
 	"github.com/labstack/echo/v4"
 )
@@ -21,6 +28,15 @@ import (
 var embeddedFiles embed.FS
 
 func main() {
+	// Запросы к upstream на адреса, где слушает mirror, отклоняются (см. ErrSelfLoop).
+	// Задаётся и для check: он может запускаться рядом с работающим сервером.
+	utils.SetListenAddrs(":80", ":443")
+
+	// "kerio-mirror-go check" only reports available updates and exits
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		os.Exit(runCheck(os.Args[2:]))
+	}
+
 	// Parse config path
 	cfgPath := flag.String("config", "config.yaml", "Path to config file")
 	flag.Parse()
@@ -35,13 +51,31 @@ func main() {
 	logger := logging.NewLogger(cfg.LogPath, cfg.LogLevel)
 	logger.Info("Starting kerio-mirror-go")
 
+	// Общий пул соединений к upstream для всех компонентов
+	if err := mirror.ConfigureHTTPClient(cfg); err != nil {
+		logger.Fatalf("Invalid HTTP client settings: %v", err)
+	}
+
 	// Init DB
 	if err := db.Init(cfg.DatabasePath); err != nil {
 		logger.Fatalf("DB init error: %v", err)
 	}
 
+	// Остатки загрузок, прерванных аварийной остановкой
+	if _, err := mirror.CleanupTemp("mirror", logger); err != nil {
+		logger.Warnf("Temp cleanup: %v", err)
+	}
+
+	// SIGINT/SIGTERM запускают штатную остановку, повторный сигнал завершает процесс сразу
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Все запуски обновления (по расписанию и вручную) идут через runner
+	runner := mirror.NewRunner(cfg, logger)
+
 	// Start scheduled mirror
-	go mirror.StartScheduler(cfg, logger)
+	go mirror.StartScheduler(cfg, logger, runner)
+	go mirror.CatchUp(cfg, logger, runner)
 
 	// Setup HTTP server
 	e := echo.New()
@@ -56,7 +90,7 @@ func main() {
 	})
 	// Add IP filter middleware
 	e.Use(middleware.IPFilterMiddleware(cfg, logger))
-	handlers.RegisterRoutes(e, cfg, logger, embeddedFiles)
+	handlers.RegisterRoutes(e, cfg, logger, runner, embeddedFiles)
 
 	// Start servers in goroutines
 	var wg sync.WaitGroup
@@ -68,7 +102,7 @@ func main() {
 	// Start HTTP server (port 80)
 	go func() {
 		defer wg.Done()
-		if err := e.Start(":80"); err != nil {
+		if err := e.Start(":80"); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			errMsg := err.Error()
 			if strings.Contains(errMsg, "address already in use") || strings.Contains(errMsg, "Only one usage of each socket address") {
 				logger.Error("========================================")
@@ -96,7 +130,7 @@ func main() {
 	// Start HTTPS server (port 443)
 	go func() {
 		defer wg.Done()
-		if err := e.StartTLS(":443", "cert.pem", "key.pem"); err != nil {
+		if err := e.StartTLS(":443", "cert.pem", "key.pem"); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			errMsg := err.Error()
 			if strings.Contains(errMsg, "Only one usage of each socket address") {
 				logger.Error("========================================")
@@ -173,6 +207,24 @@ func main() {
 		}
 	}()
 
-	// Wait for both servers
-	wg.Wait()
+	// Wait for a shutdown signal or for both servers to stop
+	serversDone := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(serversDone)
+	}()
+	code := exitOK
+	select {
+	case <-ctx.Done():
+		logger.Info("Shutdown signal received")
+	case <-serversDone:
+		logger.Error("HTTP and HTTPS servers have stopped")
+		code = exitError
+	}
+	stop()
+
+	if c := shutdown(e, runner, cfg, logger); c != exitOK {
+		code = c
+	}
+	os.Exit(code)
 }
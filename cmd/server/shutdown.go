@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"kerio-mirror-go/config"
+	"kerio-mirror-go/mirror"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// Exit codes of the server
+const (
+	exitOK          = 0 // остановлен сигналом, всё завершилось штатно
+	exitError       = 1 // ошибка запуска или остановки
+	exitInterrupted = 2 // текущее обновление пришлось прервать
+)
+
+// shutdown stops accepting connections, waits for open requests and the running update
+// for cfg.ShutdownTimeoutSeconds, aborts the update if it is still running and removes
+// temp files of interrupted downloads. It returns the process exit code.
+func shutdown(e *echo.Echo, runner *mirror.Runner, cfg *config.Config, logger *logrus.Logger) int {
+	timeout := time.Duration(cfg.ShutdownTimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	logger.Infof("Shutting down (timeout %s)", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// HTTP-серверы и обновление останавливаются параллельно, общий таймаут
+	httpDone := make(chan error, 1)
+	go func() { httpDone <- e.Shutdown(ctx) }()
+	runErr := runner.Shutdown(ctx)
+	httpErr := <-httpDone
+
+	code := exitOK
+	if httpErr != nil {
+		logger.Errorf("HTTP server shutdown: %v", httpErr)
+		code = exitError
+	}
+	switch {
+	case errors.Is(runErr, mirror.ErrUpdateInterrupted):
+		logger.Warn("Running update was aborted, previously published data is kept")
+		if code == exitOK {
+			code = exitInterrupted
+		}
+	case runErr != nil:
+		// Обновление ещё пишет файлы, временные каталоги не трогаем
+		logger.Errorf("Update shutdown: %v", runErr)
+		return exitError
+	}
+
+	if _, err := mirror.CleanupTemp("mirror", logger); err != nil {
+		logger.Errorf("Temp cleanup: %v", err)
+		code = exitError
+	}
+	logger.Infof("Shutdown complete (exit code %d)", code)
+	return code
+}
@@ -9,7 +9,7 @@ import (
 )
 
 type Config struct {
-	ScheduleTime            string // время запуска в формате HH:MM
+	ScheduleTime            string // расписание: HH:MM, список времён, @every 6h или cron-выражение
 	IDSURL                  string
 	WebFilterAPI            string
 	BitdefenderURLs         []string
@@ -19,6 +19,9 @@ type Config struct {
 	RetryCount              int
 	RetryDelaySeconds       int
 	ProxyURL                string // URL прокси-сервера, если требуется
+	ProxyPolicy             string   // Политика прокси по умолчанию: proxy, direct или fallback
+	ProxyComponents         []string // Прокси по компонентам: "bitdefender: socks5://host:1080", "ids: direct"
+	NoProxy                 string   // Хосты, домены и подсети без прокси через запятую
 	GeoIP4URL               string
 	GeoIP6URL               string
 	GeoLocURL               string
@@ -39,6 +42,7 @@ type Config struct {
 	ShieldMatrixVersion      string   // Версия Kerio Control для Shield Matrix (например, "9.5.0")
 	ShieldMatrixPreloadFiles bool     // Предзагружать все файлы Shield Matrix по расписанию
 	BitdefenderKeepVersions  int      // Количество сохраняемых версий Bitdefender (по умолчанию 1)
+	BitdefenderWorkers       int      // Сколько файлов Bitdefender скачивается параллельно (по умолчанию 4)
 	AllowedIPs               []string // Разрешенные IP адреса (whitelist)
 	BlockedIPs               []string // Заблокированные IP адреса (blacklist)
 	TelegramBotToken         string   // Telegram Bot API token
@@ -46,6 +50,38 @@ type Config struct {
 	TelegramNotifyOnError    bool     // Send notification on component errors
 	TelegramNotifyOnSuccess  bool     // Send notification on successful update
 	TelegramNotifyOnStart    bool     // Send notification when update starts
+	IDSSchedule              string   // Расписание IDS 1/2/3/5 (пусто - ScheduleTime)
+	GeoIPSchedule            string   // Расписание GeoIP (IDS4)
+	WebFilterSchedule        string   // Расписание получения ключа Web Filter
+	BitdefenderSchedule      string   // Расписание Bitdefender
+	ShieldMatrixSchedule     string   // Расписание Shield Matrix
+	CustomSchedule           string   // Расписание пользовательских файлов
+	HistoryRetentionDays     int      // Сколько дней хранить историю запусков (0 - хранить всегда)
+	FailedRetryBackoff       string   // Задержки повторов упавших компонентов, через запятую (пусто - не повторять)
+	CatchUpMaxAgeHours       int      // Данные старше этого обновляются сразу после запуска (0 - не проверять)
+	CatchUpMaxDelaySeconds   int      // Максимальная случайная задержка перед догоняющим обновлением
+	ShutdownTimeoutSeconds   int      // Сколько ждать HTTP-запросы и текущее обновление при остановке, потом обновление прерывается
+	BlackoutWindows          string   // Окна без трафика к upstream, например "Mon-Fri 08:00-18:00; Sat 10:00-12:00"
+	UpstreamLimitKBps        int      // Общий лимит загрузки из upstream, КБ/с (0 - без лимита)
+	UpstreamComponentLimits  string   // Лимиты по компонентам, КБ/с: "bitdefender=2048,geoip=512"
+	ServeLimitKBps           int      // Общий лимит раздачи файлов клиентам, КБ/с (0 - без лимита)
+	ClientLimitKBps          int      // Лимит раздачи на один IP клиента, КБ/с (0 - без лимита)
+	TrustedProxies           []string // Прокси (IP или CIDR), чьим X-Real-IP / X-Forwarded-For верим при лимите на клиента
+	DiskReserveMB            int      // Сколько места оставлять свободным на томе mirror/ после загрузки, МБ (0 - без запаса)
+	StorageQuotas            string   // Квоты каталогов компонентов, МБ: "bitdefender=20480,geoip=200"
+	StorageWarnPercent       int      // Предупреждать, когда каталог занял столько процентов квоты
+	HTTPTimeoutSeconds       int      // Таймаут запроса к upstream вместе с телом, секунды
+	HTTPMaxConnsPerHost      int      // Одновременных запросов к одному хосту (0 - без ограничения)
+	HTTPUserAgent            string   // User-Agent исходящих запросов (пусто - по умолчанию Go)
+	HTTPHeaders              []string // Заголовки всех исходящих запросов, "Name: value"
+	HTTPHostSettings         []string // Настройки хостов: "host; timeout=10m; conns=4; user-agent=...; header=Name: value"
+	UpstreamFailover         []string // Списки upstream по компонентам: "bitdefender: https://a, https://b"
+	TLSCAFiles               []string // PEM-файлы с корневыми сертификатами в дополнение к системным
+	TLSClientCert            string   // Клиентский сертификат PEM для upstream
+	TLSClientKey             string   // Ключ клиентского сертификата, пусто - ключ в том же файле
+	TLSMinVersion            string   // Минимальная версия TLS: 1.2 или 1.3, пусто - по умолчанию Go
+	UpstreamHosts            []string // Адреса хостов upstream: "ids-update.kerio.com = 1.2.3.4"
+	UpstreamDNS              string   // DNS-сервер или DoH для имён upstream, пусто - системный
 }
 
 func Load(path string) (*Config, error) {
@@ -65,6 +101,10 @@ func Load(path string) (*Config, error) {
 	viper.SetDefault("RETRY_COUNT", 3)
 	viper.SetDefault("RETRY_DELAY_SECONDS", 10)
 	viper.SetDefault("PROXY_URL", "")
+	viper.SetDefault("PROXY_POLICY", "proxy")
+	// Ключ Web Filter раньше всегда запрашивался сначала напрямую
+	viper.SetDefault("PROXY_COMPONENTS", []string{"webfilter: fallback"})
+	viper.SetDefault("NO_PROXY", "")
 	viper.SetDefault("GEOIP4_URL", "https://raw.githubusercontent.com/wyot1/GeoLite2-Unwalled/downloads/COUNTRY/CSV/GeoLite2-Country-Blocks-IPv4.csv")
 	viper.SetDefault("GEOIP6_URL", "https://raw.githubusercontent.com/wyot1/GeoLite2-Unwalled/downloads/COUNTRY/CSV/GeoLite2-Country-Blocks-IPv6.csv")
 	viper.SetDefault("GEOLOC_URL", "https://raw.githubusercontent.com/wyot1/GeoLite2-Unwalled/downloads/COUNTRY/CSV/GeoLite2-Country-Locations-en.csv")
@@ -89,6 +129,7 @@ func Load(path string) (*Config, error) {
 	viper.SetDefault("SHIELD_MATRIX_VERSION", "9.5.0")
 	viper.SetDefault("SHIELD_MATRIX_PRELOAD_FILES", false)
 	viper.SetDefault("BITDEFENDER_KEEP_VERSIONS", 1)
+	viper.SetDefault("BITDEFENDER_WORKERS", 4)
 	viper.SetDefault("ALLOWED_IPS", []string{})
 	viper.SetDefault("BLOCKED_IPS", []string{})
 	viper.SetDefault("TELEGRAM_BOT_TOKEN", "")
@@ -96,6 +137,38 @@ func Load(path string) (*Config, error) {
 	viper.SetDefault("TELEGRAM_NOTIFY_ON_ERROR", true)
 	viper.SetDefault("TELEGRAM_NOTIFY_ON_SUCCESS", false)
 	viper.SetDefault("TELEGRAM_NOTIFY_ON_START", false)
+	viper.SetDefault("IDS_SCHEDULE", "")
+	viper.SetDefault("GEOIP_SCHEDULE", "")
+	viper.SetDefault("WEBFILTER_SCHEDULE", "")
+	viper.SetDefault("BITDEFENDER_SCHEDULE", "")
+	viper.SetDefault("SHIELD_MATRIX_SCHEDULE", "")
+	viper.SetDefault("CUSTOM_SCHEDULE", "")
+	viper.SetDefault("HISTORY_RETENTION_DAYS", 90)
+	viper.SetDefault("FAILED_RETRY_BACKOFF", "5m,15m,1h")
+	viper.SetDefault("CATCHUP_MAX_AGE_HOURS", 26)
+	viper.SetDefault("CATCHUP_MAX_DELAY_SECONDS", 120)
+	viper.SetDefault("SHUTDOWN_TIMEOUT_SECONDS", 30)
+	viper.SetDefault("BLACKOUT_WINDOWS", "")
+	viper.SetDefault("UPSTREAM_LIMIT_KBPS", 0)
+	viper.SetDefault("UPSTREAM_COMPONENT_LIMITS", "")
+	viper.SetDefault("SERVE_LIMIT_KBPS", 0)
+	viper.SetDefault("CLIENT_LIMIT_KBPS", 0)
+	viper.SetDefault("TRUSTED_PROXIES", []string{})
+	viper.SetDefault("DISK_RESERVE_MB", 1024)
+	viper.SetDefault("STORAGE_QUOTAS", "")
+	viper.SetDefault("STORAGE_WARN_PERCENT", 90)
+	viper.SetDefault("HTTP_TIMEOUT_SECONDS", 60)
+	viper.SetDefault("HTTP_MAX_CONNS_PER_HOST", 0)
+	viper.SetDefault("HTTP_USER_AGENT", "")
+	viper.SetDefault("HTTP_HEADERS", []string{})
+	viper.SetDefault("HTTP_HOST_SETTINGS", []string{})
+	viper.SetDefault("UPSTREAM_FAILOVER", []string{})
+	viper.SetDefault("TLS_CA_FILES", []string{})
+	viper.SetDefault("TLS_CLIENT_CERT", "")
+	viper.SetDefault("TLS_CLIENT_KEY", "")
+	viper.SetDefault("TLS_MIN_VERSION", "")
+	viper.SetDefault("UPSTREAM_HOSTS", []string{})
+	viper.SetDefault("UPSTREAM_DNS", "")
 
 	viper.AutomaticEnv()
 	if err := viper.ReadInConfig(); err != nil {
@@ -112,6 +185,9 @@ func Load(path string) (*Config, error) {
 		RetryCount:              viper.GetInt("RETRY_COUNT"),
 		RetryDelaySeconds:       viper.GetInt("RETRY_DELAY_SECONDS"),
 		ProxyURL:                viper.GetString("PROXY_URL"),
+		ProxyPolicy:             viper.GetString("PROXY_POLICY"),
+		ProxyComponents:         viper.GetStringSlice("PROXY_COMPONENTS"),
+		NoProxy:                 viper.GetString("NO_PROXY"),
 		GeoIP4URL:               viper.GetString("GEOIP4_URL"),
 		GeoIP6URL:               viper.GetString("GEOIP6_URL"),
 		GeoLocURL:               viper.GetString("GEOLOC_URL"),
@@ -132,6 +208,7 @@ func Load(path string) (*Config, error) {
 		ShieldMatrixVersion:      viper.GetString("SHIELD_MATRIX_VERSION"),
 		ShieldMatrixPreloadFiles: viper.GetBool("SHIELD_MATRIX_PRELOAD_FILES"),
 		BitdefenderKeepVersions:  viper.GetInt("BITDEFENDER_KEEP_VERSIONS"),
+		BitdefenderWorkers:       viper.GetInt("BITDEFENDER_WORKERS"),
 		AllowedIPs:               viper.GetStringSlice("ALLOWED_IPS"),
 		BlockedIPs:               viper.GetStringSlice("BLOCKED_IPS"),
 		TelegramBotToken:         viper.GetString("TELEGRAM_BOT_TOKEN"),
@@ -139,6 +216,38 @@ func Load(path string) (*Config, error) {
 		TelegramNotifyOnError:    viper.GetBool("TELEGRAM_NOTIFY_ON_ERROR"),
 		TelegramNotifyOnSuccess:  viper.GetBool("TELEGRAM_NOTIFY_ON_SUCCESS"),
 		TelegramNotifyOnStart:    viper.GetBool("TELEGRAM_NOTIFY_ON_START"),
+		IDSSchedule:              viper.GetString("IDS_SCHEDULE"),
+		GeoIPSchedule:            viper.GetString("GEOIP_SCHEDULE"),
+		WebFilterSchedule:        viper.GetString("WEBFILTER_SCHEDULE"),
+		BitdefenderSchedule:      viper.GetString("BITDEFENDER_SCHEDULE"),
+		ShieldMatrixSchedule:     viper.GetString("SHIELD_MATRIX_SCHEDULE"),
+		CustomSchedule:           viper.GetString("CUSTOM_SCHEDULE"),
+		HistoryRetentionDays:     viper.GetInt("HISTORY_RETENTION_DAYS"),
+		FailedRetryBackoff:       viper.GetString("FAILED_RETRY_BACKOFF"),
+		CatchUpMaxAgeHours:       viper.GetInt("CATCHUP_MAX_AGE_HOURS"),
+		CatchUpMaxDelaySeconds:   viper.GetInt("CATCHUP_MAX_DELAY_SECONDS"),
+		ShutdownTimeoutSeconds:   viper.GetInt("SHUTDOWN_TIMEOUT_SECONDS"),
+		BlackoutWindows:          viper.GetString("BLACKOUT_WINDOWS"),
+		UpstreamLimitKBps:        viper.GetInt("UPSTREAM_LIMIT_KBPS"),
+		UpstreamComponentLimits:  viper.GetString("UPSTREAM_COMPONENT_LIMITS"),
+		ServeLimitKBps:           viper.GetInt("SERVE_LIMIT_KBPS"),
+		ClientLimitKBps:          viper.GetInt("CLIENT_LIMIT_KBPS"),
+		TrustedProxies:           viper.GetStringSlice("TRUSTED_PROXIES"),
+		DiskReserveMB:            viper.GetInt("DISK_RESERVE_MB"),
+		StorageQuotas:            viper.GetString("STORAGE_QUOTAS"),
+		StorageWarnPercent:       viper.GetInt("STORAGE_WARN_PERCENT"),
+		HTTPTimeoutSeconds:       viper.GetInt("HTTP_TIMEOUT_SECONDS"),
+		HTTPMaxConnsPerHost:      viper.GetInt("HTTP_MAX_CONNS_PER_HOST"),
+		HTTPUserAgent:            viper.GetString("HTTP_USER_AGENT"),
+		HTTPHeaders:              viper.GetStringSlice("HTTP_HEADERS"),
+		HTTPHostSettings:         viper.GetStringSlice("HTTP_HOST_SETTINGS"),
+		UpstreamFailover:         viper.GetStringSlice("UPSTREAM_FAILOVER"),
+		TLSCAFiles:               viper.GetStringSlice("TLS_CA_FILES"),
+		TLSClientCert:            viper.GetString("TLS_CLIENT_CERT"),
+		TLSClientKey:             viper.GetString("TLS_CLIENT_KEY"),
+		TLSMinVersion:            viper.GetString("TLS_MIN_VERSION"),
+		UpstreamHosts:            viper.GetStringSlice("UPSTREAM_HOSTS"),
+		UpstreamDNS:              viper.GetString("UPSTREAM_DNS"),
 	}, nil
 }
 
@@ -152,6 +261,9 @@ func Save(cfg *Config, path string) error {
 	viper.Set("RETRY_COUNT", cfg.RetryCount)
 	viper.Set("RETRY_DELAY_SECONDS", cfg.RetryDelaySeconds)
 	viper.Set("PROXY_URL", cfg.ProxyURL)
+	viper.Set("PROXY_POLICY", cfg.ProxyPolicy)
+	viper.Set("PROXY_COMPONENTS", cfg.ProxyComponents)
+	viper.Set("NO_PROXY", cfg.NoProxy)
 	viper.Set("GEOIP4_URL", cfg.GeoIP4URL)
 	viper.Set("GEOIP6_URL", cfg.GeoIP6URL)
 	viper.Set("GEOLOC_URL", cfg.GeoLocURL)
@@ -173,6 +285,7 @@ func Save(cfg *Config, path string) error {
 	viper.Set("SHIELD_MATRIX_VERSION", cfg.ShieldMatrixVersion)
 	viper.Set("SHIELD_MATRIX_PRELOAD_FILES", cfg.ShieldMatrixPreloadFiles)
 	viper.Set("BITDEFENDER_KEEP_VERSIONS", cfg.BitdefenderKeepVersions)
+	viper.Set("BITDEFENDER_WORKERS", cfg.BitdefenderWorkers)
 	viper.Set("ALLOWED_IPS", cfg.AllowedIPs)
 	viper.Set("BLOCKED_IPS", cfg.BlockedIPs)
 	viper.Set("TELEGRAM_BOT_TOKEN", cfg.TelegramBotToken)
@@ -180,6 +293,38 @@ func Save(cfg *Config, path string) error {
 	viper.Set("TELEGRAM_NOTIFY_ON_ERROR", cfg.TelegramNotifyOnError)
 	viper.Set("TELEGRAM_NOTIFY_ON_SUCCESS", cfg.TelegramNotifyOnSuccess)
 	viper.Set("TELEGRAM_NOTIFY_ON_START", cfg.TelegramNotifyOnStart)
+	viper.Set("IDS_SCHEDULE", cfg.IDSSchedule)
+	viper.Set("GEOIP_SCHEDULE", cfg.GeoIPSchedule)
+	viper.Set("WEBFILTER_SCHEDULE", cfg.WebFilterSchedule)
+	viper.Set("BITDEFENDER_SCHEDULE", cfg.BitdefenderSchedule)
+	viper.Set("SHIELD_MATRIX_SCHEDULE", cfg.ShieldMatrixSchedule)
+	viper.Set("CUSTOM_SCHEDULE", cfg.CustomSchedule)
+	viper.Set("HISTORY_RETENTION_DAYS", cfg.HistoryRetentionDays)
+	viper.Set("FAILED_RETRY_BACKOFF", cfg.FailedRetryBackoff)
+	viper.Set("CATCHUP_MAX_AGE_HOURS", cfg.CatchUpMaxAgeHours)
+	viper.Set("CATCHUP_MAX_DELAY_SECONDS", cfg.CatchUpMaxDelaySeconds)
+	viper.Set("SHUTDOWN_TIMEOUT_SECONDS", cfg.ShutdownTimeoutSeconds)
+	viper.Set("BLACKOUT_WINDOWS", cfg.BlackoutWindows)
+	viper.Set("UPSTREAM_LIMIT_KBPS", cfg.UpstreamLimitKBps)
+	viper.Set("UPSTREAM_COMPONENT_LIMITS", cfg.UpstreamComponentLimits)
+	viper.Set("SERVE_LIMIT_KBPS", cfg.ServeLimitKBps)
+	viper.Set("CLIENT_LIMIT_KBPS", cfg.ClientLimitKBps)
+	viper.Set("TRUSTED_PROXIES", cfg.TrustedProxies)
+	viper.Set("DISK_RESERVE_MB", cfg.DiskReserveMB)
+	viper.Set("STORAGE_QUOTAS", cfg.StorageQuotas)
+	viper.Set("STORAGE_WARN_PERCENT", cfg.StorageWarnPercent)
+	viper.Set("HTTP_TIMEOUT_SECONDS", cfg.HTTPTimeoutSeconds)
+	viper.Set("HTTP_MAX_CONNS_PER_HOST", cfg.HTTPMaxConnsPerHost)
+	viper.Set("HTTP_USER_AGENT", cfg.HTTPUserAgent)
+	viper.Set("HTTP_HEADERS", cfg.HTTPHeaders)
+	viper.Set("HTTP_HOST_SETTINGS", cfg.HTTPHostSettings)
+	viper.Set("UPSTREAM_FAILOVER", cfg.UpstreamFailover)
+	viper.Set("TLS_CA_FILES", cfg.TLSCAFiles)
+	viper.Set("TLS_CLIENT_CERT", cfg.TLSClientCert)
+	viper.Set("TLS_CLIENT_KEY", cfg.TLSClientKey)
+	viper.Set("TLS_MIN_VERSION", cfg.TLSMinVersion)
+	viper.Set("UPSTREAM_HOSTS", cfg.UpstreamHosts)
+	viper.Set("UPSTREAM_DNS", cfg.UpstreamDNS)
 
 	// Set config type explicitly if file extension is missing or not supported for writing
 	ext := filepath.Ext(path)
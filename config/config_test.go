@@ -80,6 +80,24 @@ func TestLoadDefaults(t *testing.T) {
 	if cfg.BitdefenderMode != "disabled" {
 		t.Errorf("Expected default BitdefenderMode 'disabled', got '%s'", cfg.BitdefenderMode)
 	}
+	if cfg.HistoryRetentionDays != 90 {
+		t.Errorf("Expected default HistoryRetentionDays 90, got %d", cfg.HistoryRetentionDays)
+	}
+	if cfg.FailedRetryBackoff != "5m,15m,1h" {
+		t.Errorf("Expected default FailedRetryBackoff '5m,15m,1h', got '%s'", cfg.FailedRetryBackoff)
+	}
+	if cfg.CatchUpMaxAgeHours != 26 || cfg.CatchUpMaxDelaySeconds != 120 {
+		t.Errorf("Expected default catch-up settings 26h/120s, got %dh/%ds", cfg.CatchUpMaxAgeHours, cfg.CatchUpMaxDelaySeconds)
+	}
+	if cfg.ShutdownTimeoutSeconds != 30 {
+		t.Errorf("Expected default ShutdownTimeoutSeconds 30, got %d", cfg.ShutdownTimeoutSeconds)
+	}
+	if cfg.UpstreamLimitKBps != 0 || cfg.ServeLimitKBps != 0 || cfg.ClientLimitKBps != 0 {
+		t.Errorf("Expected bandwidth limits to be off by default, got %d/%d/%d", cfg.UpstreamLimitKBps, cfg.ServeLimitKBps, cfg.ClientLimitKBps)
+	}
+	if cfg.DiskReserveMB != 1024 || cfg.StorageQuotas != "" || cfg.StorageWarnPercent != 90 {
+		t.Errorf("Expected 1024 MB disk reserve, no quotas and 90%% warning by default, got %d/%q/%d", cfg.DiskReserveMB, cfg.StorageQuotas, cfg.StorageWarnPercent)
+	}
 }
 
 func TestSaveAndLoad(t *testing.T) {
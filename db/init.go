@@ -56,6 +56,41 @@ CREATE TABLE IF NOT EXISTS shield_matrix (
   cloudfront_url TEXT,
   last_update_success BOOLEAN DEFAULT 0,
   last_success_update_at DATETIME
+);
+CREATE TABLE IF NOT EXISTS component_schedule (
+  component TEXT PRIMARY KEY,
+  last_run_at DATETIME,
+  next_run_at DATETIME
+);
+CREATE TABLE IF NOT EXISTS runs (
+  id TEXT PRIMARY KEY,
+  trigger TEXT,
+  status TEXT,
+  components TEXT,
+  started_at DATETIME,
+  finished_at DATETIME,
+  error TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_runs_started_at ON runs(started_at);
+CREATE TABLE IF NOT EXISTS run_components (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  run_id TEXT,
+  component TEXT,
+  status TEXT,
+  old_version TEXT,
+  new_version TEXT,
+  bytes INTEGER DEFAULT 0,
+  files INTEGER DEFAULT 0,
+  duration_ms INTEGER DEFAULT 0,
+  error TEXT,
+  started_at DATETIME
+);
+CREATE INDEX IF NOT EXISTS idx_run_components_run_id ON run_components(run_id);
+CREATE TABLE IF NOT EXISTS http_validators (
+  url TEXT PRIMARY KEY,
+  etag TEXT,
+  last_modified TEXT,
+  updated_at DATETIME
 );
     `
 	_, err = db.Exec(schema)
@@ -70,5 +105,8 @@ CREATE TABLE IF NOT EXISTS shield_matrix (
 	_, _ = db.Exec(`ALTER TABLE bitdefender ADD COLUMN last_success_update_at DATETIME`)
 	_, _ = db.Exec(`ALTER TABLE shield_matrix ADD COLUMN cloudfront_url TEXT`)
 
+	// Запуски, оставшиеся в статусе running после остановки процесса, помечаем прерванными
+	_, _ = db.Exec(`UPDATE runs SET status = 'interrupted' WHERE status = 'running'`)
+
 	return nil
 }
@@ -217,3 +217,47 @@ func GetShieldMatrixUpdateStatus(db *sql.DB) (bool, string, error) {
 	}
 	return success, lastSuccessAt.String, nil
 }
+
+// SetComponentLastRun сохраняет время последнего запуска компонента по расписанию
+func SetComponentLastRun(db *sql.DB, component string, t time.Time) error {
+	_, err := db.Exec(`INSERT INTO component_schedule (component, last_run_at) VALUES (?, ?)
+ON CONFLICT(component) DO UPDATE SET last_run_at = excluded.last_run_at`, component, t)
+	return err
+}
+
+// SetComponentNextRun сохраняет время следующего запуска компонента по расписанию
+func SetComponentNextRun(db *sql.DB, component string, t time.Time) error {
+	_, err := db.Exec(`INSERT INTO component_schedule (component, next_run_at) VALUES (?, ?)
+ON CONFLICT(component) DO UPDATE SET next_run_at = excluded.next_run_at`, component, t)
+	return err
+}
+
+// GetComponentSchedule возвращает время последнего и следующего запуска компонента
+func GetComponentSchedule(db *sql.DB, component string) (string, string, error) {
+	var lastRun, nextRun sql.NullString
+	err := db.QueryRow(`SELECT last_run_at, next_run_at FROM component_schedule WHERE component = ?`, component).Scan(&lastRun, &nextRun)
+	if err != nil {
+		return "", "", err
+	}
+	return lastRun.String, nextRun.String, nil
+}
+
+// GetHTTPValidators возвращает ETag и Last-Modified последней опубликованной загрузки url (пусто, если нет)
+func GetHTTPValidators(db *sql.DB, url string) (string, string, error) {
+	var etag, lastModified sql.NullString
+	err := db.QueryRow(`SELECT etag, last_modified FROM http_validators WHERE url = ?`, url).Scan(&etag, &lastModified)
+	if err == sql.ErrNoRows {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return etag.String, lastModified.String, nil
+}
+
+// SetHTTPValidators сохраняет ETag и Last-Modified опубликованной загрузки url
+func SetHTTPValidators(db *sql.DB, url, etag, lastModified string, t time.Time) error {
+	_, err := db.Exec(`INSERT INTO http_validators (url, etag, last_modified, updated_at) VALUES (?, ?, ?, ?)
+ON CONFLICT(url) DO UPDATE SET etag = excluded.etag, last_modified = excluded.last_modified, updated_at = excluded.updated_at`, url, etag, lastModified, t)
+	return err
+}
@@ -0,0 +1,152 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Run — запись об одном запуске обновления
+type Run struct {
+	ID         string `json:"id"`
+	Trigger    string `json:"trigger"`
+	Status     string `json:"status"` // running, success, failed, aborted, interrupted
+	Components string `json:"components"`
+	StartedAt  string `json:"started_at"`
+	FinishedAt string `json:"finished_at"`
+	Error      string `json:"error,omitempty"`
+}
+
+// RunComponent — результат одного компонента в рамках запуска
+type RunComponent struct {
+	RunID      string `json:"run_id"`
+	Component  string `json:"component"`
+	Status     string `json:"status"` // success, failed, skipped, aborted
+	OldVersion string `json:"old_version"`
+	NewVersion string `json:"new_version"`
+	Bytes      int64  `json:"bytes"`
+	Files      int64  `json:"files"`
+	DurationMs int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+	StartedAt  string `json:"started_at"`
+}
+
+// InsertRun создаёт запись о начатом запуске со статусом running
+func InsertRun(db *sql.DB, id, trigger, components string, startedAt time.Time) error {
+	_, err := db.Exec(`INSERT INTO runs (id, trigger, status, components, started_at) VALUES (?, ?, 'running', ?, ?)`,
+		id, trigger, components, startedAt)
+	return err
+}
+
+// FinishRun сохраняет итог запуска
+func FinishRun(db *sql.DB, id, status, errMsg string, finishedAt time.Time) error {
+	_, err := db.Exec(`UPDATE runs SET status = ?, error = ?, finished_at = ? WHERE id = ?`, status, errMsg, finishedAt, id)
+	return err
+}
+
+// InsertRunComponent сохраняет результат компонента
+func InsertRunComponent(db *sql.DB, rc RunComponent) error {
+	_, err := db.Exec(`INSERT INTO run_components
+(run_id, component, status, old_version, new_version, bytes, files, duration_ms, error, started_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		rc.RunID, rc.Component, rc.Status, rc.OldVersion, rc.NewVersion, rc.Bytes, rc.Files, rc.DurationMs, rc.Error, rc.StartedAt)
+	return err
+}
+
+// ListRuns возвращает запуски, начиная с последнего
+func ListRuns(db *sql.DB, limit, offset int) ([]Run, error) {
+	rows, err := db.Query(`SELECT id, trigger, status, components, started_at, finished_at, error
+FROM runs ORDER BY started_at DESC LIMIT ? OFFSET ?`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var runs []Run
+	for rows.Next() {
+		var r Run
+		var trigger, components, startedAt, finishedAt, errMsg sql.NullString
+		if err := rows.Scan(&r.ID, &trigger, &r.Status, &components, &startedAt, &finishedAt, &errMsg); err != nil {
+			return nil, err
+		}
+		r.Trigger, r.Components, r.StartedAt, r.FinishedAt, r.Error = trigger.String, components.String, startedAt.String, finishedAt.String, errMsg.String
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
+
+// GetRun возвращает запуск по ID (sql.ErrNoRows, если не найден)
+func GetRun(db *sql.DB, id string) (Run, error) {
+	var r Run
+	var trigger, components, startedAt, finishedAt, errMsg sql.NullString
+	err := db.QueryRow(`SELECT id, trigger, status, components, started_at, finished_at, error FROM runs WHERE id = ?`, id).
+		Scan(&r.ID, &trigger, &r.Status, &components, &startedAt, &finishedAt, &errMsg)
+	if err != nil {
+		return Run{}, err
+	}
+	r.Trigger, r.Components, r.StartedAt, r.FinishedAt, r.Error = trigger.String, components.String, startedAt.String, finishedAt.String, errMsg.String
+	return r, nil
+}
+
+// CountRuns возвращает количество сохранённых запусков
+func CountRuns(db *sql.DB) (int, error) {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM runs`).Scan(&n)
+	return n, err
+}
+
+// GetRunComponents возвращает результаты компонентов запуска в порядке выполнения
+func GetRunComponents(db *sql.DB, runID string) ([]RunComponent, error) {
+	rows, err := db.Query(`SELECT run_id, component, status, old_version, new_version, bytes, files, duration_ms, error, started_at
+FROM run_components WHERE run_id = ? ORDER BY id`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []RunComponent
+	for rows.Next() {
+		var rc RunComponent
+		var oldVersion, newVersion, errMsg, startedAt sql.NullString
+		if err := rows.Scan(&rc.RunID, &rc.Component, &rc.Status, &oldVersion, &newVersion, &rc.Bytes, &rc.Files, &rc.DurationMs, &errMsg, &startedAt); err != nil {
+			return nil, err
+		}
+		rc.OldVersion, rc.NewVersion, rc.Error, rc.StartedAt = oldVersion.String, newVersion.String, errMsg.String, startedAt.String
+		items = append(items, rc)
+	}
+	return items, rows.Err()
+}
+
+// DeleteRunsBefore удаляет запуски, начатые раньше t, вместе с результатами компонентов
+func DeleteRunsBefore(db *sql.DB, t time.Time) (int64, error) {
+	if _, err := db.Exec(`DELETE FROM run_components WHERE run_id IN (SELECT id FROM runs WHERE started_at < ?)`, t); err != nil {
+		return 0, err
+	}
+	res, err := db.Exec(`DELETE FROM runs WHERE started_at < ?`, t)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// GetComponentLastSuccess возвращает время начала последнего успешного запуска компонента
+// (status success или unchanged), пустую строку если такого не было
+func GetComponentLastSuccess(db *sql.DB, component string) (string, error) {
+	var startedAt sql.NullString
+	err := db.QueryRow(`SELECT MAX(started_at) FROM run_components WHERE component = ? AND status IN ('success', 'unchanged')`, component).Scan(&startedAt)
+	if err != nil {
+		return "", err
+	}
+	return startedAt.String, nil
+}
+
+// ParseTime разбирает значение DATETIME в том виде, в котором его возвращает драйвер sqlite.
+// Значения без часового пояса считаются локальным временем.
+func ParseTime(s string) (time.Time, bool) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999-07:00"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05", s, time.Local); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
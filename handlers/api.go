@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"kerio-mirror-go/config"
+	"kerio-mirror-go/mirror"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// scheduleResponse is returned by /api/schedule
+type scheduleResponse struct {
+	Schedule   string                         `json:"schedule"`
+	NextRuns   []string                       `json:"next_runs"`
+	Error      string                         `json:"error,omitempty"`
+	Components []mirror.ComponentScheduleInfo `json:"components"`
+}
+
+// apiScheduleHandler returns the configured schedules and the computed next run times
+func apiScheduleHandler(cfg *config.Config) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		resp := scheduleResponse{Schedule: cfg.ScheduleTime, NextRuns: []string{}}
+		runs, err := mirror.NextScheduledRuns(cfg, 5)
+		if err != nil {
+			resp.Error = err.Error()
+		}
+		for _, r := range runs {
+			resp.NextRuns = append(resp.NextRuns, r.Format(time.RFC3339))
+		}
+
+		conn, err := sql.Open("sqlite", cfg.DatabasePath)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		defer conn.Close()
+		resp.Components = mirror.ComponentSchedules(conn, cfg)
+		return c.JSON(http.StatusOK, resp)
+	}
+}
+
+// updateResponse is returned by POST /api/update
+type updateResponse struct {
+	Status        string          `json:"status"` // started, queued, already_running, already_queued, blackout, aborting, not_running
+	Run           *mirror.RunInfo `json:"run,omitempty"`
+	Error         string          `json:"error,omitempty"`
+	BlackoutUntil string          `json:"blackout_until,omitempty"` // конец окна blackout, если запуск отклонён
+}
+
+// blackoutEnd returns the end of the blackout window in effect now, or the zero time
+// if upstream traffic is allowed or the request is forced with ?force=1
+func blackoutEnd(cfg *config.Config, c echo.Context) time.Time {
+	if c.QueryParam("force") == "1" {
+		return time.Time{}
+	}
+	return mirror.BlackoutUntil(cfg, time.Now())
+}
+
+// apiUpdateStatusHandler returns the current, queued and last update run
+func apiUpdateStatusHandler(runner *mirror.Runner) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return c.JSON(http.StatusOK, runner.Status())
+	}
+}
+
+// apiUpdateHandler starts an update. While another update is running it answers 409,
+// unless ?queue=1 is given: then the update is queued and started after the current one.
+// Inside a blackout window it answers 409 unless ?force=1 is given.
+func apiUpdateHandler(cfg *config.Config, runner *mirror.Runner) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if end := blackoutEnd(cfg, c); !end.IsZero() {
+			return c.JSON(http.StatusConflict, updateResponse{
+				Status:        "blackout",
+				Error:         "upstream downloads are paused by a blackout window, use ?force=1 to run anyway",
+				BlackoutUntil: end.Format(time.RFC3339),
+			})
+		}
+		if c.QueryParam("queue") == "1" {
+			info, err := runner.Queue(mirror.TriggerAPI, nil)
+			if errors.Is(err, mirror.ErrAlreadyQueued) {
+				return c.JSON(http.StatusConflict, updateResponse{Status: "already_queued", Run: &info, Error: err.Error()})
+			}
+			if info.StartedAt == nil {
+				return c.JSON(http.StatusAccepted, updateResponse{Status: "queued", Run: &info})
+			}
+			return c.JSON(http.StatusAccepted, updateResponse{Status: "started", Run: &info})
+		}
+
+		info, err := runner.Start(mirror.TriggerAPI, nil)
+		if err != nil {
+			var busy *mirror.AlreadyRunningError
+			if errors.As(err, &busy) {
+				return c.JSON(http.StatusConflict, updateResponse{Status: "already_running", Run: &busy.Current, Error: err.Error()})
+			}
+			if errors.Is(err, mirror.ErrBusy) {
+				return c.JSON(http.StatusConflict, updateResponse{Status: "already_running", Error: err.Error()})
+			}
+			return c.JSON(http.StatusInternalServerError, updateResponse{Error: err.Error()})
+		}
+		return c.JSON(http.StatusAccepted, updateResponse{Status: "started", Run: &info})
+	}
+}
+
+// apiAbortUpdateHandler cancels the running update. The previously published data is kept.
+func apiAbortUpdateHandler(runner *mirror.Runner) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		info, err := runner.Abort()
+		if err != nil {
+			return c.JSON(http.StatusConflict, updateResponse{Status: "not_running", Error: err.Error()})
+		}
+		return c.JSON(http.StatusAccepted, updateResponse{Status: "aborting", Run: &info})
+	}
+}
+
+// apiCheckHandler compares local and upstream versions without downloading anything.
+// The version requests are upstream traffic too, so a blackout window needs ?force=1.
+func apiCheckHandler(cfg *config.Config, logger *logrus.Logger) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if end := blackoutEnd(cfg, c); !end.IsZero() {
+			return c.JSON(http.StatusConflict, map[string]string{
+				"error":          "upstream requests are paused by a blackout window, use ?force=1 to check anyway",
+				"blackout_until": end.Format(time.RFC3339),
+			})
+		}
+		return c.JSON(http.StatusOK, mirror.CachedCheck(c.Request().Context(), cfg, logger))
+	}
+}
+
+// apiComponentsHandler returns the published state of every registered component
+func apiComponentsHandler(cfg *config.Config) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		conn, err := sql.Open("sqlite", cfg.DatabasePath)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		defer conn.Close()
+		return c.JSON(http.StatusOK, mirror.ComponentStatuses(conn, cfg))
+	}
+}
+
+// apiStorageHandler returns the disk usage of the component directories, their quotas and the free space
+func apiStorageHandler(cfg *config.Config) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return c.JSON(http.StatusOK, mirror.Storage(cfg))
+	}
+}
+
+// progressInterval is how often the progress stream sends a changed snapshot,
+// progressKeepAlive how often it sends a comment while nothing changes,
+// progressIdle how long a stream waits for an update to start before it ends
+const (
+	progressInterval  = time.Second
+	progressKeepAlive = 15 * time.Second
+	progressIdle      = 10 * time.Second
+)
+
+// apiProgressHandler returns the progress of the running update, or of the last one
+func apiProgressHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return c.JSON(http.StatusOK, mirror.CurrentProgress())
+	}
+}
+
+// apiProgressStreamHandler streams the progress as Server-Sent Events: a "progress" event with
+// the same JSON as /api/progress right away, on every state change and every second while it changes.
+// The stream ends after the update finishes, with an "idle" event if no update runs for progressIdle,
+// and when done is closed on server shutdown, so an open dashboard does not hold the shutdown.
+func apiProgressStreamHandler(done <-chan struct{}) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		w := c.Response()
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.Header().Set("X-Accel-Buffering", "no") // nginx перед mirror не должен копить события
+		w.WriteHeader(http.StatusOK)
+
+		changes, unsubscribe := mirror.SubscribeProgress()
+		defer unsubscribe()
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+
+		var last []byte
+		connected, lastSent := time.Now(), time.Now()
+		seenRunning := false
+		send := func(p mirror.Progress) error {
+			data, err := json.Marshal(p)
+			if err != nil {
+				return err
+			}
+			switch {
+			case !bytes.Equal(data, last):
+				_, err = fmt.Fprintf(w, "event: progress\ndata: %s\n\n", data)
+				last = data
+			case time.Since(lastSent) >= progressKeepAlive:
+				_, err = io.WriteString(w, ": keep-alive\n\n")
+			default:
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			lastSent = time.Now()
+			w.Flush()
+			return nil
+		}
+
+		for {
+			p := mirror.CurrentProgress()
+			if err := send(p); err != nil {
+				return nil // клиент ушёл
+			}
+			switch {
+			case p.Running:
+				seenRunning = true
+			case seenRunning:
+				return nil // итог запуска отправлен
+			case time.Since(connected) >= progressIdle:
+				// Запуск закончился до подключения или так и не начался: клиент перезагрузит страницу, а не переподключится
+				io.WriteString(w, "event: idle\ndata: {}\n\n")
+				w.Flush()
+				return nil
+			}
+			select {
+			case <-c.Request().Context().Done():
+				return nil
+			case <-done:
+				return nil
+			case <-changes:
+			case <-ticker.C:
+			}
+		}
+	}
+}
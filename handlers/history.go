@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"database/sql"
+	"embed"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"kerio-mirror-go/config"
+	"kerio-mirror-go/db"
+	"kerio-mirror-go/mirror"
+	"kerio-mirror-go/utils"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+const historyPageSize = 50
+
+// historyComponent is a component result prepared for the history page
+type historyComponent struct {
+	db.RunComponent
+	Title    string
+	Size     string
+	Duration string
+}
+
+// historyRun is a run prepared for the history page
+type historyRun struct {
+	db.Run
+	Started    string
+	Duration   string
+	Components []historyComponent
+}
+
+// historyPage holds data for templates/history.html
+type historyPage struct {
+	Runs          []historyRun
+	Page          int
+	HasPrev       bool
+	HasNext       bool
+	Total         int
+	RetentionDays int
+	Error         string
+}
+
+// runWithComponents is returned by /api/history/:id
+type runWithComponents struct {
+	db.Run
+	Results []db.RunComponent `json:"results"`
+}
+
+func formatDBTime(s string) string {
+	if t, ok := db.ParseTime(s); ok {
+		return t.Local().Format("2006-01-02 15:04:05")
+	}
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// reportFromHistory rebuilds the report of a stored run.
+// Changed files are not stored in the history, so FilesChanged stays empty.
+func reportFromHistory(run db.Run, items []db.RunComponent) mirror.RunReport {
+	report := mirror.RunReport{
+		RunID:      run.ID,
+		Trigger:    run.Trigger,
+		Status:     run.Status,
+		Error:      run.Error,
+		Components: []mirror.ComponentResult{},
+	}
+	report.StartedAt, _ = db.ParseTime(run.StartedAt)
+	report.FinishedAt, _ = db.ParseTime(run.FinishedAt)
+	for _, rc := range items {
+		cr := mirror.ComponentResult{
+			Component:  rc.Component,
+			Title:      mirror.ComponentTitle(rc.Component),
+			Status:     rc.Status,
+			OldVersion: rc.OldVersion,
+			NewVersion: rc.NewVersion,
+			Bytes:      rc.Bytes,
+			Files:      rc.Files,
+			DurationMs: rc.DurationMs,
+		}
+		cr.StartedAt, _ = db.ParseTime(rc.StartedAt)
+		if rc.Error != "" {
+			cr.Errors = strings.Split(rc.Error, "; ")
+		}
+		report.Components = append(report.Components, cr)
+	}
+	return report
+}
+
+// lastReport returns the report of the last finished run: from the runner if it ran
+// since startup, otherwise from the history. It returns nil if there is none.
+func lastReport(cfg *config.Config, runner *mirror.Runner) (*mirror.RunReport, error) {
+	if st := runner.Status(); st.Last != nil && st.Last.Report != nil {
+		return st.Last.Report, nil
+	}
+	conn, err := sql.Open("sqlite", cfg.DatabasePath)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	runs, err := db.ListRuns(conn, 20, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, run := range runs {
+		if run.Status == mirror.StatusRunning || run.FinishedAt == "" {
+			continue
+		}
+		items, err := db.GetRunComponents(conn, run.ID)
+		if err != nil {
+			return nil, err
+		}
+		report := reportFromHistory(run, items)
+		return &report, nil
+	}
+	return nil, nil
+}
+
+func loadHistoryRuns(conn *sql.DB, limit, offset int) ([]historyRun, error) {
+	runs, err := db.ListRuns(conn, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	var out []historyRun
+	for _, r := range runs {
+		hr := historyRun{Run: r, Started: formatDBTime(r.StartedAt), Duration: "-"}
+		if start, ok := db.ParseTime(r.StartedAt); ok {
+			if end, ok := db.ParseTime(r.FinishedAt); ok {
+				hr.Duration = end.Sub(start).Round(time.Second).String()
+			}
+		}
+		items, err := db.GetRunComponents(conn, r.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, rc := range items {
+			hr.Components = append(hr.Components, historyComponent{
+				RunComponent: rc,
+				Title:        mirror.ComponentTitle(rc.Component),
+				Size:         utils.FormatBytes(rc.Bytes),
+				Duration:     (time.Duration(rc.DurationMs) * time.Millisecond).Round(100 * time.Millisecond).String(),
+			})
+		}
+		out = append(out, hr)
+	}
+	return out, nil
+}
+
+// historyPageHandler renders the update run history
+func historyPageHandler(cfg *config.Config, embeddedFiles embed.FS) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if logger, ok := c.Get("logger").(*logrus.Logger); ok {
+			logger.Infof("Web access: %s %s from %s", c.Request().Method, c.Request().URL.Path, c.RealIP())
+		}
+		page, _ := strconv.Atoi(c.QueryParam("page"))
+		if page < 1 {
+			page = 1
+		}
+		data := historyPage{Page: page, RetentionDays: cfg.HistoryRetentionDays}
+
+		conn, err := sql.Open("sqlite", cfg.DatabasePath)
+		if err != nil {
+			data.Error = err.Error()
+		} else {
+			defer conn.Close()
+			data.Total, _ = db.CountRuns(conn)
+			data.Runs, err = loadHistoryRuns(conn, historyPageSize, (page-1)*historyPageSize)
+			if err != nil {
+				data.Error = err.Error()
+			}
+			data.HasPrev = page > 1
+			data.HasNext = page*historyPageSize < data.Total
+		}
+
+		t, err := template.New("history.html").Funcs(template.FuncMap{
+			"add": func(a, b int) int { return a + b },
+		}).ParseFS(embeddedFiles, "templates/history.html")
+		if err != nil {
+			return c.String(http.StatusInternalServerError, "Template file error: "+err.Error())
+		}
+		c.Response().Header().Set("Content-Type", "text/html; charset=utf-8")
+		return t.Execute(c.Response(), data)
+	}
+}
+
+// apiHistoryHandler returns the latest runs: /api/history?limit=20&offset=0
+func apiHistoryHandler(cfg *config.Config) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		limit, _ := strconv.Atoi(c.QueryParam("limit"))
+		if limit <= 0 || limit > 500 {
+			limit = 20
+		}
+		offset, _ := strconv.Atoi(c.QueryParam("offset"))
+		if offset < 0 {
+			offset = 0
+		}
+		conn, err := sql.Open("sqlite", cfg.DatabasePath)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		defer conn.Close()
+		runs, err := db.ListRuns(conn, limit, offset)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		if runs == nil {
+			runs = []db.Run{}
+		}
+		return c.JSON(http.StatusOK, runs)
+	}
+}
+
+// apiRunHandler returns a single run with its component results: /api/history/:id
+func apiRunHandler(cfg *config.Config) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		conn, err := sql.Open("sqlite", cfg.DatabasePath)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		defer conn.Close()
+		run, err := db.GetRun(conn, c.Param("id"))
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "run not found"})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		items, err := db.GetRunComponents(conn, run.ID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		if items == nil {
+			items = []db.RunComponent{}
+		}
+		return c.JSON(http.StatusOK, runWithComponents{Run: run, Results: items})
+	}
+}
+
+// apiReportHandler returns the report of the last finished run: /api/report
+func apiReportHandler(cfg *config.Config, runner *mirror.Runner) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		report, err := lastReport(cfg, runner)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		if report == nil {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "no finished runs yet"})
+		}
+		return c.JSON(http.StatusOK, report)
+	}
+}
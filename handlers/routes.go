@@ -2,6 +2,8 @@ package handlers
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -14,6 +16,7 @@ import (
 	"kerio-mirror-go/config"
 	"kerio-mirror-go/db"
 	"kerio-mirror-go/logging"
+	"kerio-mirror-go/middleware"
 	"kerio-mirror-go/mirror"
 	"kerio-mirror-go/utils"
 
@@ -32,14 +35,23 @@ type DashboardStatus struct {
 	ServiceName           string
 	CurrentTime           string
 	Config                *config.Config
-	IDSVersions           map[string]int
-	IDSSuccess            map[string]bool // успешность по каждой IDS
+	IDSDatabases          []mirror.IDSDatabase // версии IDS зарегистрированных компонентов
 	BitdefenderVer        int
 	BitdefenderSuccess    bool   // успешность Bitdefender
 	SnortTemplateSuccess  bool   // успешность Snort Template
 	ShieldMatrixVersion   string // версия Shield Matrix
 	ShieldMatrixSuccess   bool   // успешность Shield Matrix
 	LastUpdate            string
+	NextRuns              []string // ближайшие запуски по расписанию
+	ScheduleError         string   // ошибка разбора расписания
+	ComponentSchedules    []mirror.ComponentScheduleInfo
+	Components            []mirror.ComponentStatus // состояние всех зарегистрированных компонентов
+	Run                   mirror.RunnerStatus // текущий/ожидающий запуск обновления
+	LastReport            *mirror.RunReport   // результаты последнего завершённого запуска
+	Check                 *mirror.CheckReport // результат проверки версий без загрузки (?check=1)
+	UpdateNotice          string              // сообщение после нажатия Manual Update
+	BlackoutUntil         string              // конец текущего окна blackout, пусто вне окна
+	Storage               mirror.StorageReport // занятое место, квоты и свободное место тома
 	ActiveComponents      int // количество активных компонентов
 	SuccessfulComponents  int // количество успешно обновленных компонентов
 	HealthPercentage      int // процент здоровья системы (0-100)
@@ -52,13 +64,6 @@ func getDashboardStatus(cfg *config.Config) (*DashboardStatus, error) {
 	}
 	defer conn.Close()
 
-	idsVersions := make(map[string]int)
-	idsSuccess := make(map[string]bool)
-	for _, v := range []string{"1", "2", "3", "4", "5"} {
-		idsVersions[v] = db.GetIDSVersion(conn, v)
-		success, _, _ := db.GetIDSUpdateStatus(conn, v)
-		idsSuccess[v] = success
-	}
 	bitdefenderVer := db.GetBitdefenderVersion(conn)
 	bitdefenderSuccess, _, _ := db.GetBitdefenderUpdateStatus(conn)
 
@@ -72,50 +77,30 @@ func getDashboardStatus(cfg *config.Config) (*DashboardStatus, error) {
 	// Получаем время последнего обновления из last_update
 	lastUpdateStr, _ := db.GetLastUpdate(conn)
 
-	// Подсчитываем активные и успешные компоненты
-	activeComponents := 0
-	successfulComponents := 0
-
-	if cfg.EnableIDS1 {
-		activeComponents++
-		if idsSuccess["1"] {
-			successfulComponents++
-		}
-	}
-	if cfg.EnableIDS2 {
-		activeComponents++
-		if idsSuccess["2"] {
-			successfulComponents++
-		}
-	}
-	if cfg.EnableIDS3 {
-		activeComponents++
-		if idsSuccess["3"] {
-			successfulComponents++
-		}
-	}
-	if cfg.EnableIDS4 {
-		activeComponents++
-		if idsSuccess["4"] {
-			successfulComponents++
-		}
-	}
-	if cfg.EnableIDS5 {
-		activeComponents++
-		if idsSuccess["5"] {
-			successfulComponents++
-		}
+	// Ближайшие запуски по расписанию
+	var nextRuns []string
+	scheduleError := ""
+	runs, err := mirror.NextScheduledRuns(cfg, 3)
+	if err != nil {
+		scheduleError = err.Error()
 	}
-	if cfg.BitdefenderMode == "mirror" {
-		activeComponents++
-		if bitdefenderSuccess {
-			successfulComponents++
-		}
+	for _, r := range runs {
+		nextRuns = append(nextRuns, r.Format("2006-01-02 15:04"))
 	}
-	if cfg.EnableShieldMatrix {
-		activeComponents++
-		if shieldMatrixSuccess {
-			successfulComponents++
+
+	// Подсчитываем активные и успешные базы по всем зарегистрированным компонентам
+	components := mirror.ComponentStatuses(conn, cfg)
+	activeComponents := 0
+	successfulComponents := 0
+	for _, c := range components {
+		if !c.Enabled {
+			continue
+		}
+		for _, d := range c.Databases {
+			activeComponents++
+			if d.Success {
+				successfulComponents++
+			}
 		}
 	}
 
@@ -129,23 +114,26 @@ func getDashboardStatus(cfg *config.Config) (*DashboardStatus, error) {
 		ServiceName:          "Kerio Mirror Go",
 		CurrentTime:          time.Now().Format("2006-01-02 15:04:05 MST"),
 		Config:               cfg,
-		IDSVersions:          idsVersions,
-		IDSSuccess:           idsSuccess,
+		IDSDatabases:         mirror.IDSDatabases(conn, cfg),
 		BitdefenderVer:       bitdefenderVer,
 		BitdefenderSuccess:   bitdefenderSuccess,
 		SnortTemplateSuccess: snortTemplateSuccess,
 		ShieldMatrixVersion:  shieldMatrixVersion,
 		ShieldMatrixSuccess:  shieldMatrixSuccess,
 		LastUpdate:           lastUpdateStr,
+		NextRuns:             nextRuns,
+		ScheduleError:        scheduleError,
+		ComponentSchedules:   mirror.ComponentSchedules(conn, cfg),
+		Components:           components,
 		ActiveComponents:     activeComponents,
 		SuccessfulComponents: successfulComponents,
 		HealthPercentage:     healthPercentage,
 	}, nil
 }
 
-func RegisterRoutes(e *echo.Echo, cfg *config.Config, logger *logrus.Logger, embeddedFiles embed.FS) {
+func RegisterRoutes(e *echo.Echo, cfg *config.Config, logger *logrus.Logger, runner *mirror.Runner, embeddedFiles embed.FS) {
 	// Dashboard
-	e.GET("/", dashboardHandler(embeddedFiles))
+	e.GET("/", dashboardHandler(runner, embeddedFiles))
 	// Settings
 	e.GET("/settings", settingsPageHandler(cfg, embeddedFiles))
 	e.POST("/settings", settingsPageHandler(cfg, embeddedFiles))
@@ -155,8 +143,28 @@ func RegisterRoutes(e *echo.Echo, cfg *config.Config, logger *logrus.Logger, emb
 	e.GET("/logs", serveFileHandler(cfg.LogPath, embeddedFiles))
 	e.GET("/logs/raw", serveRawLogHandler(cfg.LogPath))
 	e.GET("/logs/full_raw", serveFullRawLogHandler(cfg.LogPath))
+	// Update run history
+	e.GET("/history", historyPageHandler(cfg, embeddedFiles))
 	// Start manual update mirror files
-	e.GET("/update", updateHandler(cfg, logger))
+	e.GET("/update", updateHandler(cfg, runner, logger))
+	e.POST("/update/abort", abortUpdateHandler(runner, logger))
+	// JSON API
+	e.GET("/api/schedule", apiScheduleHandler(cfg))
+	e.GET("/api/update", apiUpdateStatusHandler(runner))
+	e.POST("/api/update", apiUpdateHandler(cfg, runner))
+	e.POST("/api/update/abort", apiAbortUpdateHandler(runner))
+	e.GET("/api/history", apiHistoryHandler(cfg))
+	e.GET("/api/history/:id", apiRunHandler(cfg))
+	e.GET("/api/report", apiReportHandler(cfg, runner))
+	e.POST("/api/check", apiCheckHandler(cfg, logger))
+	e.GET("/api/components", apiComponentsHandler(cfg))
+	e.GET("/api/storage", apiStorageHandler(cfg))
+	e.GET("/api/progress", apiProgressHandler())
+	// Потоки прогресса закрываются при остановке сервера, иначе Shutdown ждёт открытый дашборд до таймаута
+	streamsCtx, stopStreams := context.WithCancel(context.Background())
+	e.Server.RegisterOnShutdown(stopStreams)
+	e.TLSServer.RegisterOnShutdown(stopStreams)
+	e.GET("/api/progress/stream", apiProgressStreamHandler(streamsCtx.Done()))
 	// Раздать файлы обновлений
 	e.GET("/update.php", updateKerioHandler(cfg, logger))
 	// Shield Matrix update check
@@ -169,17 +177,19 @@ func RegisterRoutes(e *echo.Echo, cfg *config.Config, logger *logrus.Logger, emb
 		}
 		return c.Blob(http.StatusOK, "image/x-icon", data)
 	})
+	// Раздача файлов клиентам ограничивается SERVE_LIMIT_KBPS / CLIENT_LIMIT_KBPS
+	bandwidth := middleware.BandwidthLimitMiddleware(cfg)
 	// New handler for serving files from the update_files directory
-	e.GET("/control-update/*", controlUpdateHandler(logger))
+	e.GET("/control-update/*", controlUpdateHandler(logger), bandwidth)
 	// Shield Matrix files
-	e.GET("/matrix/*", matrixHandler(logger))
+	e.GET("/matrix/*", matrixHandler(logger), bandwidth)
 	// Static files from embedded filesystem
 	e.GET("/static/*", echo.WrapHandler(http.FileServer(http.FS(embeddedFiles)))) // Serve embedded static files
-	// other routes
-	e.GET("/*", customFilesHandlerOrFallback(cfg, logger))
+	// other routes: custom files, IDS, Bitdefender mirror and proxy
+	e.GET("/*", customFilesHandlerOrFallback(cfg, logger), bandwidth)
 }
 
-func dashboardHandler(embeddedFiles embed.FS) echo.HandlerFunc {
+func dashboardHandler(runner *mirror.Runner, embeddedFiles embed.FS) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		logger, ok := c.Get("logger").(*logrus.Logger)
 		if !ok {
@@ -194,7 +204,37 @@ func dashboardHandler(embeddedFiles embed.FS) echo.HandlerFunc {
 		if err != nil {
 			return c.String(http.StatusInternalServerError, "Failed to load status")
 		}
-		t, err := template.ParseFS(embeddedFiles, "templates/dashboard.html")
+		status.Run = runner.Status()
+		status.Storage = mirror.Storage(cfg)
+		if status.LastReport, err = lastReport(cfg, runner); err != nil {
+			logger.Warnf("Failed to load last update report: %v", err)
+		}
+		if end := mirror.BlackoutUntil(cfg, time.Now()); !end.IsZero() {
+			status.BlackoutUntil = end.Format("2006-01-02 15:04")
+		}
+		if c.QueryParam("check") == "1" {
+			if end := blackoutEnd(cfg, c); !end.IsZero() {
+				status.UpdateNotice = "Upstream check was not run: upstream requests are paused by a blackout window."
+			} else {
+				check := mirror.CachedCheck(c.Request().Context(), cfg, logger)
+				status.Check = &check
+			}
+		}
+		switch {
+		case c.QueryParam("blackout") != "":
+			status.UpdateNotice = "Manual update was not started: upstream downloads are paused by a blackout window."
+		case c.QueryParam("busy") == "-":
+			status.UpdateNotice = "Another update is starting or finishing, the manual update was not started."
+		case c.QueryParam("busy") != "":
+			status.UpdateNotice = fmt.Sprintf("Update %s is already running, the manual update was not started.", c.QueryParam("busy"))
+		case c.QueryParam("queued") != "":
+			status.UpdateNotice = fmt.Sprintf("Update %s is queued and will start when the current run finishes.", c.QueryParam("queued"))
+		case c.QueryParam("aborted") != "":
+			status.UpdateNotice = fmt.Sprintf("Update %s is being aborted, previously published data is kept.", c.QueryParam("aborted"))
+		}
+		t, err := template.New("dashboard.html").Funcs(template.FuncMap{
+			"formatBytes": utils.FormatBytes,
+		}).ParseFS(embeddedFiles, "templates/dashboard.html")
 		if err != nil {
 			return c.String(http.StatusInternalServerError, "Template file error: "+err.Error())
 		}
@@ -211,10 +251,108 @@ func settingsPageHandler(cfg *config.Config, embeddedFiles embed.FS) echo.Handle
 		}
 		logger.Infof("Web access: %s %s from %s", c.Request().Method, c.Request().URL.Path, c.RealIP())
 		if c.Request().Method == http.MethodPost {
-			cfg.ScheduleTime = c.FormValue("ScheduleTime")
+			// Проверяем расписания до применения настроек
+			schedules := map[string]string{}
+			for _, field := range []string{"ScheduleTime", "IDSSchedule", "GeoIPSchedule", "WebFilterSchedule", "BitdefenderSchedule", "ShieldMatrixSchedule", "CustomSchedule"} {
+				spec := strings.TrimSpace(c.FormValue(field))
+				schedules[field] = spec
+				if spec == "" && field != "ScheduleTime" {
+					continue
+				}
+				if _, err := mirror.ParseSchedule(spec); err != nil {
+					return renderSettingsError(c, embeddedFiles, cfg, fmt.Sprintf("Invalid schedule %s: %v", field, err))
+				}
+			}
+			backoff := strings.TrimSpace(c.FormValue("FailedRetryBackoff"))
+			if _, err := mirror.ParseBackoff(backoff); err != nil {
+				return renderSettingsError(c, embeddedFiles, cfg, fmt.Sprintf("Invalid FailedRetryBackoff: %v", err))
+			}
+			blackout := strings.TrimSpace(c.FormValue("BlackoutWindows"))
+			if _, err := mirror.ParseBlackout(blackout); err != nil {
+				return renderSettingsError(c, embeddedFiles, cfg, fmt.Sprintf("Invalid BlackoutWindows: %v", err))
+			}
+			componentLimits := strings.TrimSpace(c.FormValue("UpstreamComponentLimits"))
+			if _, err := mirror.ParseComponentLimits(componentLimits); err != nil {
+				return renderSettingsError(c, embeddedFiles, cfg, fmt.Sprintf("Invalid UpstreamComponentLimits: %v", err))
+			}
+			storageQuotas := strings.TrimSpace(c.FormValue("StorageQuotas"))
+			if _, err := mirror.ParseStorageQuotas(storageQuotas); err != nil {
+				return renderSettingsError(c, embeddedFiles, cfg, fmt.Sprintf("Invalid StorageQuotas: %v", err))
+			}
+			httpHeaders := splitLines(c.FormValue("HTTPHeaders"))
+			if _, err := mirror.ParseHeaders(httpHeaders); err != nil {
+				return renderSettingsError(c, embeddedFiles, cfg, fmt.Sprintf("Invalid HTTPHeaders: %v", err))
+			}
+			httpHosts := splitLines(c.FormValue("HTTPHostSettings"))
+			if _, err := mirror.ParseHostSettings(httpHosts); err != nil {
+				return renderSettingsError(c, embeddedFiles, cfg, fmt.Sprintf("Invalid HTTPHostSettings: %v", err))
+			}
+			failover := splitLines(c.FormValue("UpstreamFailover"))
+			if _, err := mirror.ParseFailover(failover); err != nil {
+				return renderSettingsError(c, embeddedFiles, cfg, fmt.Sprintf("Invalid UpstreamFailover: %v", err))
+			}
+			proxyComponents := splitLines(c.FormValue("ProxyComponents"))
+			if _, err := mirror.ParseProxyComponents(proxyComponents); err != nil {
+				return renderSettingsError(c, embeddedFiles, cfg, fmt.Sprintf("Invalid ProxyComponents: %v", err))
+			}
+			tlsCAFiles := splitLines(c.FormValue("TLSCAFiles"))
+			tlsCert, tlsKey, tlsMin := c.FormValue("TLSClientCert"), c.FormValue("TLSClientKey"), c.FormValue("TLSMinVersion")
+			if _, err := mirror.ParseTLS(tlsCAFiles, tlsCert, tlsKey, tlsMin); err != nil {
+				return renderSettingsError(c, embeddedFiles, cfg, fmt.Sprintf("Invalid TLS settings: %v", err))
+			}
+			upstreamHosts := splitLines(c.FormValue("UpstreamHosts"))
+			if _, err := mirror.ParseUpstreamHosts(upstreamHosts); err != nil {
+				return renderSettingsError(c, embeddedFiles, cfg, fmt.Sprintf("Invalid UpstreamHosts: %v", err))
+			}
+			upstreamDNS := strings.TrimSpace(c.FormValue("UpstreamDNS"))
+			if _, err := mirror.ParseUpstreamDNS(upstreamDNS); err != nil {
+				return renderSettingsError(c, embeddedFiles, cfg, fmt.Sprintf("Invalid UpstreamDNS: %v", err))
+			}
+			noProxy := c.FormValue("NoProxy")
+			if _, err := mirror.ParseNoProxy(noProxy); err != nil {
+				return renderSettingsError(c, embeddedFiles, cfg, fmt.Sprintf("Invalid NoProxy: %v", err))
+			}
+			// У этих полей 0 значит "выключено" или "без ограничения", опечатку нельзя сохранять как 0
+			numbers := map[string]int{}
+			for _, field := range []string{"HistoryRetentionDays", "CatchUpMaxAgeHours", "CatchUpMaxDelaySeconds",
+				"UpstreamLimitKBps", "ServeLimitKBps", "ClientLimitKBps"} {
+				v, err := strconv.Atoi(strings.TrimSpace(c.FormValue(field)))
+				if err == nil && v < 0 {
+					err = errors.New("must not be negative")
+				}
+				if err != nil {
+					return renderSettingsError(c, embeddedFiles, cfg, fmt.Sprintf("Invalid %s: %v", field, err))
+				}
+				numbers[field] = v
+			}
+			cfg.HTTPHeaders = httpHeaders
+			cfg.HTTPHostSettings = httpHosts
+			cfg.UpstreamFailover = failover
+			cfg.ProxyComponents = proxyComponents
+			cfg.NoProxy = noProxy
+			cfg.TLSCAFiles = tlsCAFiles
+			cfg.TLSClientCert = tlsCert
+			cfg.TLSClientKey = tlsKey
+			cfg.TLSMinVersion = tlsMin
+			cfg.UpstreamHosts = upstreamHosts
+			cfg.UpstreamDNS = upstreamDNS
+			cfg.FailedRetryBackoff = backoff
+			cfg.BlackoutWindows = blackout
+			cfg.UpstreamComponentLimits = componentLimits
+			cfg.StorageQuotas = storageQuotas
+			cfg.ScheduleTime = schedules["ScheduleTime"]
+			cfg.IDSSchedule = schedules["IDSSchedule"]
+			cfg.GeoIPSchedule = schedules["GeoIPSchedule"]
+			cfg.WebFilterSchedule = schedules["WebFilterSchedule"]
+			cfg.BitdefenderSchedule = schedules["BitdefenderSchedule"]
+			cfg.ShieldMatrixSchedule = schedules["ShieldMatrixSchedule"]
+			cfg.CustomSchedule = schedules["CustomSchedule"]
 			cfg.DatabasePath = c.FormValue("DatabasePath")
 			cfg.LogPath = c.FormValue("LogPath")
 			cfg.ProxyURL = c.FormValue("ProxyURL")
+			if p := c.FormValue("ProxyPolicy"); utils.ValidProxyPolicy(p) {
+				cfg.ProxyPolicy = p
+			}
 			cfg.LicenseNumber = c.FormValue("LicenseNumber")
 			cfg.WebFilterAPI = c.FormValue("WebFilterApi")
 			cfg.GeoIP4URL = c.FormValue("GeoIP4Url")
@@ -222,6 +360,26 @@ func settingsPageHandler(cfg *config.Config, embeddedFiles embed.FS) echo.Handle
 			cfg.GeoLocURL = c.FormValue("GeoLocUrl")
 			cfg.RetryCount, _ = strconv.Atoi(c.FormValue("RetryCount"))
 			cfg.RetryDelaySeconds, _ = strconv.Atoi(c.FormValue("RetryDelaySeconds"))
+			cfg.HistoryRetentionDays = numbers["HistoryRetentionDays"]
+			cfg.CatchUpMaxAgeHours = numbers["CatchUpMaxAgeHours"]
+			cfg.CatchUpMaxDelaySeconds = numbers["CatchUpMaxDelaySeconds"]
+			if v, err := strconv.Atoi(c.FormValue("ShutdownTimeoutSeconds")); err == nil && v > 0 {
+				cfg.ShutdownTimeoutSeconds = v
+			}
+			cfg.UpstreamLimitKBps = numbers["UpstreamLimitKBps"]
+			cfg.ServeLimitKBps = numbers["ServeLimitKBps"]
+			cfg.ClientLimitKBps = numbers["ClientLimitKBps"]
+			if v, err := strconv.Atoi(c.FormValue("DiskReserveMB")); err == nil && v >= 0 {
+				cfg.DiskReserveMB = v
+			}
+			if v, err := strconv.Atoi(c.FormValue("StorageWarnPercent")); err == nil && v > 0 && v <= 100 {
+				cfg.StorageWarnPercent = v
+			}
+			if v, err := strconv.Atoi(c.FormValue("HTTPTimeoutSeconds")); err == nil && v > 0 {
+				cfg.HTTPTimeoutSeconds = v
+			}
+			cfg.HTTPMaxConnsPerHost, _ = strconv.Atoi(c.FormValue("HTTPMaxConnsPerHost"))
+			cfg.HTTPUserAgent = strings.TrimSpace(c.FormValue("HTTPUserAgent"))
 			cfg.LogLevel = c.FormValue("LogLevel")
 			cfg.IDSURL = c.FormValue("IDSUrl")
 			bitdefUrlsRaw := c.FormValue("BitdefenderUrls")
@@ -243,6 +401,9 @@ func settingsPageHandler(cfg *config.Config, embeddedFiles embed.FS) echo.Handle
 				cfg.BitdefenderMode = "disabled"
 			}
 			cfg.BitdefenderProxyBaseURL = c.FormValue("BitdefenderProxyBaseURL")
+			if v, err := strconv.Atoi(c.FormValue("BitdefenderWorkers")); err == nil && v > 0 {
+				cfg.BitdefenderWorkers = v
+			}
 
 			customUrlsRaw := c.FormValue("CustomDownloadUrls")
 			cfg.CustomDownloadURLs = nil
@@ -309,28 +470,50 @@ func settingsPageHandler(cfg *config.Config, embeddedFiles embed.FS) echo.Handle
 
 			// Update logger level if it was changed
 			logging.UpdateLogLevel(logger, cfg.LogLevel)
-
-			t, err := template.ParseFS(embeddedFiles, "templates/settings.html")
-			if err != nil {
-				return c.String(http.StatusInternalServerError, "Template file error: "+err.Error())
+			// Новые запросы к upstream пойдут через клиент с новыми настройками
+			if err := mirror.ConfigureHTTPClient(cfg); err != nil {
+				logger.Errorf("Failed to apply HTTP client settings: %v", err)
 			}
-			c.Response().Header().Set("Content-Type", "text/html; charset=utf-8")
-			return t.Execute(c.Response(), map[string]interface{}{
-				"Config":  cfg,
-				"Message": msg,
-			})
+
+			return renderSettings(c, embeddedFiles, cfg, msg, "")
 		}
 		// GET: показать форму
-		t, err := template.ParseFS(embeddedFiles, "templates/settings.html")
-		if err != nil {
-			return c.String(http.StatusInternalServerError, "Template file error: "+err.Error())
+		return renderSettings(c, embeddedFiles, cfg, "", "")
+	}
+}
+
+// renderSettingsError shows the settings form with a validation error, nothing is saved
+func renderSettingsError(c echo.Context, embeddedFiles embed.FS, cfg *config.Config, msg string) error {
+	return renderSettings(c, embeddedFiles, cfg, "", msg)
+}
+
+// renderSettings shows the settings form with an optional message and error
+func renderSettings(c echo.Context, embeddedFiles embed.FS, cfg *config.Config, msg, errMsg string) error {
+	t, err := template.ParseFS(embeddedFiles, "templates/settings.html")
+	if err != nil {
+		return c.String(http.StatusInternalServerError, "Template file error: "+err.Error())
+	}
+	c.Response().Header().Set("Content-Type", "text/html; charset=utf-8")
+	data := map[string]interface{}{
+		"Config":  cfg,
+		"Message": msg,
+	}
+	if errMsg != "" {
+		data["Error"] = errMsg
+	}
+	return t.Execute(c.Response(), data)
+}
+
+// splitLines returns the non-empty trimmed lines of a textarea value
+func splitLines(raw string) []string {
+	var lines []string
+	for _, line := range strings.Split(raw, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			lines = append(lines, line)
 		}
-		c.Response().Header().Set("Content-Type", "text/html; charset=utf-8")
-		return t.Execute(c.Response(), map[string]interface{}{
-			"Config":  cfg,
-			"Message": "",
-		})
 	}
+	return lines
 }
 
 func serveFileHandler(path string, embeddedFiles embed.FS) echo.HandlerFunc {
@@ -399,13 +582,52 @@ func serveFullRawLogHandler(path string) echo.HandlerFunc {
 	}
 }
 
-func updateHandler(cfg *config.Config, logger *logrus.Logger) echo.HandlerFunc {
+// updateHandler starts a manual update. If an update is already running the request
+// is rejected, or queued when called with ?queue=1. Inside a blackout window it needs ?force=1.
+func updateHandler(cfg *config.Config, runner *mirror.Runner, logger *logrus.Logger) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		go mirror.Update(cfg, logger)
+		if end := blackoutEnd(cfg, c); !end.IsZero() {
+			logger.Infof("Manual update rejected: blackout window until %s", end.Format("2006-01-02 15:04:05"))
+			return c.Redirect(http.StatusSeeOther, "/?blackout=1")
+		}
+		if c.QueryParam("queue") == "1" {
+			info, err := runner.Queue(mirror.TriggerManual, nil)
+			if err != nil {
+				logger.Infof("Manual update not queued: %v", err)
+			}
+			if info.StartedAt == nil {
+				return c.Redirect(http.StatusSeeOther, "/?queued="+info.ID)
+			}
+			return c.Redirect(http.StatusSeeOther, "/logs")
+		}
+		if _, err := runner.Start(mirror.TriggerManual, nil); err != nil {
+			logger.Infof("Manual update rejected: %v", err)
+			var busy *mirror.AlreadyRunningError
+			if errors.As(err, &busy) {
+				return c.Redirect(http.StatusSeeOther, "/?busy="+busy.Current.ID)
+			}
+			if errors.Is(err, mirror.ErrBusy) {
+				return c.Redirect(http.StatusSeeOther, "/?busy=-")
+			}
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
 		return c.Redirect(http.StatusSeeOther, "/logs")
 	}
 }
 
+// abortUpdateHandler cancels the running update from the dashboard
+func abortUpdateHandler(runner *mirror.Runner, logger *logrus.Logger) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		info, err := runner.Abort()
+		if err != nil {
+			logger.Infof("Abort update: %v", err)
+			return c.Redirect(http.StatusSeeOther, "/")
+		}
+		logger.Infof("Update %s aborted from web UI by %s", info.ID, c.RealIP())
+		return c.Redirect(http.StatusSeeOther, "/?aborted="+info.ID)
+	}
+}
+
 func updateKerioHandler(cfg *config.Config, logger *logrus.Logger) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		// Debug logging: full request details
@@ -640,10 +862,26 @@ func shieldMatrixCloudFrontHandler(cfg *config.Config, logger *logrus.Logger) ec
 			// Proxy to upstream CloudFront
 			logger.Debugf("Shield Matrix CloudFront: version file request, proxying to upstream")
 
+			// В окне blackout отдаём версию из БД вместо запроса к upstream
+			if end := mirror.BlackoutUntil(cfg, time.Now()); !end.IsZero() {
+				conn, err := sql.Open("sqlite", cfg.DatabasePath)
+				if err != nil {
+					logger.Errorf("Shield Matrix CloudFront: failed to open database: %v", err)
+					return c.String(http.StatusInternalServerError, "500 Internal Server Error")
+				}
+				defer conn.Close()
+				if version := db.GetShieldMatrixVersion(conn); version != "" {
+					logger.Infof("Shield Matrix CloudFront: blackout window, serving version %s from DB", version)
+					return c.String(http.StatusOK, version)
+				}
+				return mirror.BlackoutResponse(c, end)
+			}
+
 			upstreamURL := fmt.Sprintf("%s/%s", cfg.ShieldMatrixBaseURL, subpath)
 			logger.Debugf("Shield Matrix CloudFront: upstream URL: %s", upstreamURL)
 
-			resp, err := utils.HTTPGetWithRetry(upstreamURL, cfg.RetryCount, time.Duration(cfg.RetryDelaySeconds)*time.Second, cfg.ProxyURL)
+			ctx := mirror.UpstreamContext(c.Request().Context(), cfg, mirror.ComponentShieldMatrix)
+			resp, err := utils.HTTPGetWithRetry(ctx, upstreamURL, cfg.RetryCount, time.Duration(cfg.RetryDelaySeconds)*time.Second, cfg.ProxyURL)
 			if err != nil {
 				logger.Errorf("Shield Matrix CloudFront: failed to fetch version: %v", err)
 				return c.String(http.StatusNotFound, "404 Not found")
@@ -694,6 +932,10 @@ func shieldMatrixCloudFrontHandler(cfg *config.Config, logger *logrus.Logger) ec
 		// Check if file exists, if not - download it on-demand
 		fileInfo, err := os.Stat(localPath)
 		if err != nil {
+			if end := mirror.BlackoutUntil(cfg, time.Now()); !end.IsZero() {
+				logger.Infof("Shield Matrix CloudFront: blackout window until %s, not fetching %s", end.Format("2006-01-02 15:04:05"), subpath)
+				return mirror.BlackoutResponse(c, end)
+			}
 			logger.Infof("Shield Matrix CloudFront: file not found locally (%s), initiating on-demand download", subpath)
 			logger.Debugf("Shield Matrix CloudFront: stat error: %v", err)
 
@@ -712,7 +954,7 @@ func shieldMatrixCloudFrontHandler(cfg *config.Config, logger *logrus.Logger) ec
 			}
 
 			// Download the file
-			if err := mirror.DownloadShieldMatrixFile(subpath, cloudFrontURL, cfg, logger); err != nil {
+			if err := mirror.DownloadShieldMatrixFile(c.Request().Context(), subpath, cloudFrontURL, cfg, logger); err != nil {
 				logger.Errorf("Shield Matrix CloudFront: failed to download file %s: %v", subpath, err)
 				return c.String(http.StatusNotFound, "404 Not found")
 			}
@@ -917,6 +1159,10 @@ func matrixHandler(logger *logrus.Logger) echo.HandlerFunc {
 				logger.Error("Shield Matrix handler: config not found in context")
 				return c.String(http.StatusInternalServerError, "500 Internal Server Error")
 			}
+			if end := mirror.BlackoutUntil(cfg, time.Now()); !end.IsZero() {
+				logger.Infof("Shield Matrix: blackout window until %s, not fetching %s", end.Format("2006-01-02 15:04:05"), filePath)
+				return mirror.BlackoutResponse(c, end)
+			}
 
 			// Get CloudFront URL from DB
 			conn, dbErr := sql.Open("sqlite", cfg.DatabasePath)
@@ -933,7 +1179,7 @@ func matrixHandler(logger *logrus.Logger) echo.HandlerFunc {
 			}
 
 			// Download the file
-			if err := mirror.DownloadShieldMatrixFile(filePath, cloudFrontURL, cfg, logger); err != nil {
+			if err := mirror.DownloadShieldMatrixFile(c.Request().Context(), filePath, cloudFrontURL, cfg, logger); err != nil {
 				logger.Errorf("Shield Matrix handler: failed to download file %s: %v", filePath, err)
 				return c.String(http.StatusNotFound, "404 Not found")
 			}
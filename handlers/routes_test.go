@@ -1,13 +1,21 @@
 package handlers
 
 import (
+	"database/sql"
+	"embed"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"kerio-mirror-go/config"
+	"kerio-mirror-go/db"
+	"kerio-mirror-go/mirror"
 
 	"github.com/labstack/echo/v4"
 	"github.com/sirupsen/logrus"
@@ -256,3 +264,161 @@ func TestParseMajorVersion(t *testing.T) {
 		})
 	}
 }
+
+func TestAPIHistoryHandlers(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "mirror.db")
+	if err := db.Init(dbPath); err != nil {
+		t.Fatalf("DB init failed: %v", err)
+	}
+	conn, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("DB open failed: %v", err)
+	}
+	defer conn.Close()
+	if err := db.InsertRun(conn, "run-1", "manual", "ids", time.Now()); err != nil {
+		t.Fatalf("InsertRun failed: %v", err)
+	}
+	if err := db.InsertRunComponent(conn, db.RunComponent{RunID: "run-1", Component: "ids", Status: "failed", Error: "boom"}); err != nil {
+		t.Fatalf("InsertRunComponent failed: %v", err)
+	}
+	if err := db.FinishRun(conn, "run-1", "failed", "failed: IDS", time.Now()); err != nil {
+		t.Fatalf("FinishRun failed: %v", err)
+	}
+	cfg := &config.Config{DatabasePath: dbPath}
+	e := echo.New()
+
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest(http.MethodGet, "/api/history", nil), rec)
+	if err := apiHistoryHandler(cfg)(c); err != nil {
+		t.Fatalf("Handler returned error: %v", err)
+	}
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"id":"run-1"`) {
+		t.Errorf("Expected run-1 in history, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	c = e.NewContext(httptest.NewRequest(http.MethodGet, "/api/history/run-1", nil), rec)
+	c.SetParamNames("id")
+	c.SetParamValues("run-1")
+	if err := apiRunHandler(cfg)(c); err != nil {
+		t.Fatalf("Handler returned error: %v", err)
+	}
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"error":"boom"`) {
+		t.Errorf("Expected component error in run details, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	c = e.NewContext(httptest.NewRequest(http.MethodGet, "/api/history/missing", nil), rec)
+	c.SetParamNames("id")
+	c.SetParamValues("missing")
+	if err := apiRunHandler(cfg)(c); err != nil {
+		t.Fatalf("Handler returned error: %v", err)
+	}
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", rec.Code)
+	}
+
+	// Runner has not run anything yet, so the report is rebuilt from the history
+	rec = httptest.NewRecorder()
+	c = e.NewContext(httptest.NewRequest(http.MethodGet, "/api/report", nil), rec)
+	if err := apiReportHandler(cfg, mirror.NewRunner(cfg, logrus.New()))(c); err != nil {
+		t.Fatalf("Handler returned error: %v", err)
+	}
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"run_id":"run-1"`) || !strings.Contains(rec.Body.String(), `"errors":["boom"]`) {
+		t.Errorf("Expected report of run-1, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestAPIUpdateHandler_Blackout(t *testing.T) {
+	// Окно на все сутки: запуск без force отклоняется
+	cfg := &config.Config{DatabasePath: filepath.Join(t.TempDir(), "mirror.db"), BlackoutWindows: "00:00-00:00"}
+	runner := mirror.NewRunner(cfg, logrus.New())
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/api/update", nil)
+	rec := httptest.NewRecorder()
+	if err := apiUpdateHandler(cfg, runner)(e.NewContext(req, rec)); err != nil {
+		t.Fatalf("Handler returned error: %v", err)
+	}
+	if rec.Code != http.StatusConflict {
+		t.Errorf("Expected status %d, got %d", http.StatusConflict, rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `"status":"blackout"`) || !strings.Contains(rec.Body.String(), "blackout_until") {
+		t.Errorf("Expected blackout response, got %s", rec.Body.String())
+	}
+	if runner.Status().Running {
+		t.Error("Expected no update to be started")
+	}
+}
+
+func TestAPIProgressHandler(t *testing.T) {
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest(http.MethodGet, "/api/progress", nil), rec)
+	if err := apiProgressHandler()(c); err != nil {
+		t.Fatalf("Handler returned error: %v", err)
+	}
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"components":[`) {
+		t.Errorf("Expected progress snapshot, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Поток отдаёт снимок сразу и закрывается при остановке сервера
+	done := make(chan struct{})
+	time.AfterFunc(200*time.Millisecond, func() { close(done) })
+	rec = httptest.NewRecorder()
+	c = e.NewContext(httptest.NewRequest(http.MethodGet, "/api/progress/stream", nil), rec)
+	if err := apiProgressStreamHandler(done)(c); err != nil {
+		t.Fatalf("Stream handler returned error: %v", err)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Expected text/event-stream, got %q", ct)
+	}
+	if !strings.HasPrefix(rec.Body.String(), "event: progress\ndata: {") {
+		t.Errorf("Expected progress event, got %q", rec.Body.String())
+	}
+}
+
+func TestSettingsPageHandler_InvalidNumbers(t *testing.T) {
+	tests := []struct {
+		field string
+		value string
+	}{
+		{"HistoryRetentionDays", "30d"},
+		{"HistoryRetentionDays", "-7"},
+		{"CatchUpMaxAgeHours", "abc"},
+		{"CatchUpMaxAgeHours", "-1"},
+		{"CatchUpMaxDelaySeconds", ""},
+		{"UpstreamLimitKBps", "1.5"},
+		{"ServeLimitKBps", "-100"},
+		{"ClientLimitKBps", "512k"},
+	}
+	for _, tt := range tests {
+		want := config.Config{ScheduleTime: "03:00", HistoryRetentionDays: 90, CatchUpMaxAgeHours: 12, CatchUpMaxDelaySeconds: 300,
+			UpstreamLimitKBps: 2048, ServeLimitKBps: 4096, ClientLimitKBps: 512}
+		cfg := want
+		form := url.Values{
+			"ScheduleTime":           {"03:00"},
+			"HistoryRetentionDays":   {"30"},
+			"CatchUpMaxAgeHours":     {"24"},
+			"CatchUpMaxDelaySeconds": {"60"},
+			"UpstreamLimitKBps":      {"1024"},
+			"ServeLimitKBps":         {"0"},
+			"ClientLimitKBps":        {"256"},
+		}
+		form.Set(tt.field, tt.value)
+
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/settings", strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.Set("logger", logrus.New())
+		if err := settingsPageHandler(&cfg, embed.FS{})(c); err != nil {
+			t.Fatalf("Handler returned error: %v", err)
+		}
+		// Настройки не применяются, даже поля, прошедшие проверку
+		if !reflect.DeepEqual(cfg, want) {
+			t.Errorf("%s=%q: expected settings to stay unchanged, got %+v", tt.field, tt.value, cfg)
+		}
+	}
+}
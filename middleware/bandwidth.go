@@ -0,0 +1,121 @@
+package middleware
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"kerio-mirror-go/config"
+	"kerio-mirror-go/utils"
+
+	"github.com/labstack/echo/v4"
+)
+
+// BandwidthLimitMiddleware limits how fast files are served to clients:
+// SERVE_LIMIT_KBPS for all clients together and CLIENT_LIMIT_KBPS per client IP
+// (parallel downloads of one Kerio Control share its limit). Limits are re-read on every request.
+// The client IP is taken from the connection, see limiterIP.
+func BandwidthLimitMiddleware(cfg *config.Config) echo.MiddlewareFunc {
+	total := utils.NewRateLimiter(0)
+	clients := &clientLimiters{m: map[string]*clientLimiter{}}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if cfg.ServeLimitKBps <= 0 && cfg.ClientLimitKBps <= 0 {
+				return next(c)
+			}
+			total.SetRate(int64(cfg.ServeLimitKBps) * 1024)
+			ip := limiterIP(c, cfg.TrustedProxies)
+			client := clients.acquire(ip, int64(cfg.ClientLimitKBps)*1024)
+			defer clients.release(ip)
+
+			w := c.Response().Writer
+			c.Response().Writer = &limitedResponseWriter{
+				ResponseWriter: w,
+				limited:        utils.LimitWriter(c.Request().Context(), w, client, total),
+			}
+			defer func() { c.Response().Writer = w }()
+			return next(c)
+		}
+	}
+}
+
+// limiterIP returns the client IP the per-client limit is kept for: the address of the connection,
+// or the client named by X-Real-IP / X-Forwarded-For if the connection comes from one of trusted.
+// Headers of other clients are ignored, otherwise every request could pick its own limit.
+func limiterIP(c echo.Context, trusted []string) string {
+	req := c.Request()
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip == nil || !matchesIPList(ip, trusted) {
+		return host
+	}
+	if real := strings.TrimSpace(req.Header.Get("X-Real-IP")); real != "" {
+		return real
+	}
+	// Справа налево до первого адреса, добавленного не доверенным прокси
+	parts := strings.Split(req.Header.Get("X-Forwarded-For"), ",")
+	for i := len(parts) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(parts[i])
+		if addr == "" {
+			continue
+		}
+		if ip := net.ParseIP(addr); ip == nil || !matchesIPList(ip, trusted) {
+			return addr
+		}
+	}
+	return host
+}
+
+// clientLimiters keeps one limiter per client IP while it has requests in progress
+type clientLimiters struct {
+	mu sync.Mutex
+	m  map[string]*clientLimiter
+}
+
+type clientLimiter struct {
+	*utils.RateLimiter
+	refs int
+}
+
+func (cl *clientLimiters) acquire(ip string, rate int64) *utils.RateLimiter {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	l, ok := cl.m[ip]
+	if !ok {
+		l = &clientLimiter{RateLimiter: utils.NewRateLimiter(rate)}
+		cl.m[ip] = l
+	}
+	l.refs++
+	l.SetRate(rate)
+	return l.RateLimiter
+}
+
+func (cl *clientLimiters) release(ip string) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if l, ok := cl.m[ip]; ok {
+		l.refs--
+		if l.refs <= 0 {
+			delete(cl.m, ip)
+		}
+	}
+}
+
+// limitedResponseWriter throttles the response body, headers are passed through as is
+type limitedResponseWriter struct {
+	http.ResponseWriter
+	limited io.Writer
+}
+
+func (w *limitedResponseWriter) Write(p []byte) (int, error) {
+	return w.limited.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach Flush and deadlines of the original writer
+func (w *limitedResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,64 @@
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"kerio-mirror-go/config"
+	"kerio-mirror-go/utils"
+)
+
+// componentLimiters are shared by scheduled runs and on-demand proxy downloads of the same component
+var (
+	componentLimitersMu sync.Mutex
+	componentLimiters   = map[string]*utils.RateLimiter{}
+)
+
+// ParseComponentLimits parses UPSTREAM_COMPONENT_LIMITS: "bitdefender=2048, geoip=512",
+// component names as in ComponentNames, values in KB/s (0 - no own limit)
+func ParseComponentLimits(spec string) (map[string]int, error) {
+	limits := map[string]int{}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid limit %q, expected component=KB/s", item)
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := LookupUpdater(name); !ok {
+			return nil, fmt.Errorf("unknown component %q", name)
+		}
+		kbps, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || kbps < 0 {
+			return nil, fmt.Errorf("invalid limit %q for %s", value, name)
+		}
+		limits[name] = kbps
+	}
+	return limits, nil
+}
+
+// UpstreamContext applies the configured bandwidth limits to upstream downloads made with ctx:
+// the global UPSTREAM_LIMIT_KBPS and the component's own limit from UPSTREAM_COMPONENT_LIMITS.
+// Limits are re-read from cfg on every call, so settings changes apply to the next download.
+// Requests made with ctx also fail over along the component's UPSTREAM_FAILOVER list.
+func UpstreamContext(ctx context.Context, cfg *config.Config, component string) context.Context {
+	utils.SetUpstreamLimit(int64(cfg.UpstreamLimitKBps) * 1024)
+	// Неверная строка отклоняется на странице настроек, здесь считаем её пустой
+	limits, _ := ParseComponentLimits(cfg.UpstreamComponentLimits)
+
+	componentLimitersMu.Lock()
+	l, ok := componentLimiters[component]
+	if !ok {
+		l = utils.NewRateLimiter(0)
+		componentLimiters[component] = l
+	}
+	componentLimitersMu.Unlock()
+	l.SetRate(int64(limits[component]) * 1024)
+	return utils.WithRateLimit(utils.WithUpstreamComponent(ctx, component), l)
+}
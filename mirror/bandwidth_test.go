@@ -0,0 +1,37 @@
+package mirror
+
+import "testing"
+
+func TestParseComponentLimits(t *testing.T) {
+	tests := []struct {
+		spec     string
+		expected map[string]int
+		ok       bool
+	}{
+		{"", map[string]int{}, true},
+		{"bitdefender=2048, geoip=512", map[string]int{"bitdefender": 2048, "geoip": 512}, true},
+		{"Bitdefender = 0", map[string]int{"bitdefender": 0}, true},
+		{"bitdefender", nil, false},
+		{"unknown=100", nil, false},
+		{"geoip=-1", nil, false},
+		{"geoip=fast", nil, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseComponentLimits(tt.spec)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParseComponentLimits(%q): expected ok=%v, got error %v", tt.spec, tt.ok, err)
+			continue
+		}
+		if !tt.ok {
+			continue
+		}
+		if len(got) != len(tt.expected) {
+			t.Errorf("ParseComponentLimits(%q): expected %v, got %v", tt.spec, tt.expected, got)
+		}
+		for k, v := range tt.expected {
+			if got[k] != v {
+				t.Errorf("ParseComponentLimits(%q): expected %s=%d, got %d", tt.spec, k, v, got[k])
+			}
+		}
+	}
+}
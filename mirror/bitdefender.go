@@ -1,10 +1,15 @@
 package mirror
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,19 +21,22 @@ import (
 )
 
 // downloadAndStoreBitdefender handles Bitdefender update with backup/rollback logic
-func downloadAndStoreBitdefender(conn *sql.DB, urls []string, destDir string, cfg *config.Config, logger *logrus.Logger) {
+func downloadAndStoreBitdefender(ctx context.Context, conn *sql.DB, urls []string, destDir string, cfg *config.Config, logger *logrus.Logger) *ComponentResult {
 	startBitdefenderHeartbeat(logger)
+	res := newResult(ComponentBitdefender)
 
 	tmpDir := destDir + "_tmp"
 	os.RemoveAll(tmpDir)
 	defer func() { os.RemoveAll(tmpDir) }()
 
-	newVersion, info, err := fetchAndParseBitdefenderVersion(tmpDir, cfg, logger)
+	currentVersion := db.GetBitdefenderVersion(conn)
+	res.OldVersion = strconv.Itoa(currentVersion)
+	res.NewVersion = res.OldVersion
+	newVersion, info, err := fetchAndParseBitdefenderVersion(ctx, tmpDir, cfg, logger)
 	if err != nil {
-		logger.Errorf("bitdefender: %v", err)
-		return
+		res.failf(logger, "bitdefender: %w", err)
+		return res.finish(ctx)
 	}
-	currentVersion := db.GetBitdefenderVersion(conn)
 
 	// Проверяем статус последнего обновления
 	lastSuccess, _, statusErr := db.GetBitdefenderUpdateStatus(conn)
@@ -36,7 +44,8 @@ func downloadAndStoreBitdefender(conn *sql.DB, urls []string, destDir string, cf
 	// Если версия совпадает И последнее обновление было успешным - пропускаем
 	if currentVersion >= newVersion && (statusErr != nil || lastSuccess) {
 		logger.Infof("bitdefender: no new version, current: %d, remote: %d", currentVersion, newVersion)
-		return
+		res.Message = "no new version"
+		return res.finish(ctx)
 	}
 
 	// Если версия совпадает, но последнее обновление было неудачным - попробуем снова
@@ -46,31 +55,64 @@ func downloadAndStoreBitdefender(conn *sql.DB, urls []string, destDir string, cf
 		logger.Infof("bitdefender: new version detected: %d", newVersion)
 	}
 
-	downloadBitdefenderMetaFiles(tmpDir, newVersion, cfg, logger)
-	handleThinSdkFiles(tmpDir, cfg, logger)
-	downloadV3Archives(tmpDir, info, cfg, logger)
+	// Любой не скачанный файл проваливает версию: tmpDir удалится в defer, опубликованная останется
+	if err := downloadBitdefenderMetaFiles(ctx, tmpDir, newVersion, cfg, logger); err != nil {
+		return bitdefenderFailed(ctx, res, logger, currentVersion, err)
+	}
+	// Новая версия собирается второй копией рядом с опубликованной, место проверяем до загрузки файлов
+	if err := checkBitdefenderSpace(tmpDir, destDir, newVersion, cfg, logger); err != nil {
+		return bitdefenderFailed(ctx, res, logger, currentVersion, err)
+	}
+	if err := handleThinSdkFiles(ctx, tmpDir, cfg, logger); err != nil {
+		return bitdefenderFailed(ctx, res, logger, currentVersion, err)
+	}
+	if err := downloadV3Archives(ctx, tmpDir, info, cfg, logger); err != nil {
+		return bitdefenderFailed(ctx, res, logger, currentVersion, err)
+	}
 	dat, err := extractAndParseDatJSON(tmpDir, info, logger)
 	if err != nil {
-		logger.Errorf("bitdefender: %v", err)
-		return
+		return bitdefenderFailed(ctx, res, logger, currentVersion, err)
+	}
+	if err := downloadDatFiles(ctx, tmpDir, newVersion, dat, cfg, logger); err != nil {
+		return bitdefenderFailed(ctx, res, logger, currentVersion, err)
+	}
+
+	// При отмене не трогаем опубликованную версию, tmpDir удалится в defer
+	if ctx.Err() != nil {
+		logger.Warnf("bitdefender: update aborted, keeping published version %d", currentVersion)
+		return res.abort()
 	}
-	downloadDatFiles(tmpDir, newVersion, dat, cfg, logger)
 
 	if !replaceBitdefenderDirs(destDir, tmpDir, logger) {
-		return
+		return res.failed(errors.New("bitdefender: failed to publish new version"))
 	}
 
 	err = db.UpdateBitdefenderVersion(conn, newVersion, true, time.Now())
 	if err != nil {
-		logger.Errorf("bitdefender: failed to update version: %v", err)
-		return
+		res.failf(logger, "bitdefender: failed to update version: %w", err)
+		return res.finish(ctx)
 	}
 	logger.Infof("bitdefender: update complete, version %d", newVersion)
+	res.NewVersion = strconv.Itoa(newVersion)
+	res.Status = StatusSuccess
 
 	// Очистка старых версий
 	cleanupOldBitdefenderVersions(destDir, newVersion, cfg.BitdefenderKeepVersions, logger)
 
 	logger.Info(urls)
+	return res
+}
+
+// bitdefenderFailed finishes an update that could not download the new version.
+// A cancelled update is reported as aborted, not failed.
+func bitdefenderFailed(ctx context.Context, res *ComponentResult, logger *logrus.Logger, currentVersion int, err error) *ComponentResult {
+	if ctx.Err() != nil {
+		logger.Warnf("bitdefender: update aborted, keeping published version %d", currentVersion)
+		return res.abort()
+	}
+	res.failf(logger, "bitdefender: %w", err)
+	logger.Warnf("bitdefender: keeping published version %d", currentVersion)
+	return res.finish(ctx)
 }
 
 // Heartbeat goroutine
@@ -91,170 +133,208 @@ func startBitdefenderHeartbeat(logger *logrus.Logger) {
 	defer close(done)
 }
 
-func fetchAndParseBitdefenderVersion(tmpDir string, cfg *config.Config, logger *logrus.Logger) (int, Info, error) {
-	const versionURL = "https://upgrade.bitdefender.com/av64bit/versions.id"
-	resp, err := utils.HTTPGetWithRetry(versionURL, cfg.RetryCount, time.Duration(cfg.RetryDelaySeconds)*time.Second, cfg.ProxyURL)
+// bitdefenderVersionURL describes the latest Bitdefender update
+const bitdefenderVersionURL = "https://upgrade.bitdefender.com/av64bit/versions.id"
+
+// fetchBitdefenderVersion downloads versions.id into memory and parses it.
+// It is the version-discovery half of the update and does not touch the disk.
+func fetchBitdefenderVersion(ctx context.Context, cfg *config.Config) (int, Info, []byte, error) {
+	resp, err := utils.HTTPGetWithRetry(ctx, bitdefenderVersionURL, cfg.RetryCount, time.Duration(cfg.RetryDelaySeconds)*time.Second, cfg.ProxyURL)
 	if err != nil {
-		return 0, Info{}, fmt.Errorf("failed to fetch versions.id: %w", err)
+		return 0, Info{}, nil, fmt.Errorf("failed to fetch versions.id: %w", err)
 	}
 	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, Info{}, nil, fmt.Errorf("failed to read versions.id: %w", err)
+	}
+	var info Info
+	if err := utils.DecodeXML(bytes.NewReader(data), &info); err != nil {
+		return 0, Info{}, nil, fmt.Errorf("failed to parse XML: %w", err)
+	}
+	return utils.AtoiSafe(info.All.ID.Value), info, data, nil
+}
+
+func fetchAndParseBitdefenderVersion(ctx context.Context, tmpDir string, cfg *config.Config, logger *logrus.Logger) (int, Info, error) {
+	newVersion, info, data, err := fetchBitdefenderVersion(ctx, cfg)
+	if err != nil {
+		return 0, Info{}, err
+	}
 	urlPath := "av64bit/versions.id"
 	versionsPath := filepath.Join(tmpDir, urlPath)
 	if err := os.MkdirAll(filepath.Dir(versionsPath), 0755); err != nil {
 		return 0, Info{}, fmt.Errorf("failed to create directory: %w", err)
 	}
-	if err := utils.SaveResponseToFile(resp.Body, versionsPath); err != nil {
+	if err := utils.SaveResponseToFile(io.NopCloser(bytes.NewReader(data)), versionsPath); err != nil {
 		return 0, Info{}, fmt.Errorf("failed to save versions.id: %w", err)
 	}
 	logger.Infof("Stored bitdefender -> %s", urlPath)
-	versionsFile, err := os.Open(versionsPath)
-	if err != nil {
-		return 0, Info{}, fmt.Errorf("failed to reopen versions.id: %w", err)
-	}
-	var info Info
-	decodeErr := utils.DecodeXML(versionsFile, &info)
-	closeErr := versionsFile.Close()
-	if decodeErr != nil {
-		return 0, Info{}, fmt.Errorf("failed to parse XML: %w", decodeErr)
-	}
-	if closeErr != nil {
-		logger.Errorf("bitdefender: failed to close versions.id: %v", closeErr)
-	}
-	newVersion := utils.AtoiSafe(info.All.ID.Value)
 	return newVersion, info, nil
 }
 
-func downloadBitdefenderMetaFiles(tmpDir string, newVersion int, cfg *config.Config, logger *logrus.Logger) {
-	downloadAndLog := func(urlPath, url string) {
+func downloadBitdefenderMetaFiles(ctx context.Context, tmpDir string, newVersion int, cfg *config.Config, logger *logrus.Logger) error {
+	downloadAndLog := func(urlPath, url string) error {
 		destPath := filepath.Join(tmpDir, urlPath)
 		if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
-			logger.Errorf("bitdefender: failed to create directory for %s: %v", urlPath, err)
-			return
+			return fmt.Errorf("failed to create directory for %s: %w", urlPath, err)
 		}
-		resp, err := utils.HTTPGetWithRetry(url, cfg.RetryCount, time.Duration(cfg.RetryDelaySeconds)*time.Second, cfg.ProxyURL)
+		resp, err := utils.HTTPGetWithRetry(ctx, url, cfg.RetryCount, time.Duration(cfg.RetryDelaySeconds)*time.Second, cfg.ProxyURL)
 		if err != nil {
-			logger.Errorf("bitdefender: failed to fetch %s: %v", urlPath, err)
-			return
+			return fmt.Errorf("failed to fetch %s: %w", urlPath, err)
 		}
 		defer resp.Body.Close()
-		if err := utils.SaveResponseToFile(resp.Body, destPath); err != nil {
-			logger.Errorf("bitdefender: failed to save %s: %v", urlPath, err)
-		} else {
-			logger.Infof("Stored bitdefender -> %s", urlPath)
+		if err := utils.SaveResponse(resp, destPath); err != nil {
+			return fmt.Errorf("failed to save %s: %w", urlPath, err)
+		}
+		logger.Infof("Stored bitdefender -> %s", urlPath)
+		return nil
+	}
+	for _, name := range []string{"versions.dat", "versions.sig", "versions.dat.gz"} {
+		urlPath := fmt.Sprintf("av64bit_%d/%s", newVersion, name)
+		if err := downloadAndLog(urlPath, "https://upgrade.bitdefender.com/"+urlPath); err != nil {
+			return err
 		}
 	}
-	downloadAndLog(fmt.Sprintf("av64bit_%d/versions.dat", newVersion), fmt.Sprintf("https://upgrade.bitdefender.com/av64bit_%d/versions.dat", newVersion))
-	downloadAndLog(fmt.Sprintf("av64bit_%d/versions.sig", newVersion), fmt.Sprintf("https://upgrade.bitdefender.com/av64bit_%d/versions.sig", newVersion))
-	downloadAndLog(fmt.Sprintf("av64bit_%d/versions.dat.gz", newVersion), fmt.Sprintf("https://upgrade.bitdefender.com/av64bit_%d/versions.dat.gz", newVersion))
+	return nil
 }
 
-func handleThinSdkFiles(tmpDir string, cfg *config.Config, logger *logrus.Logger) {
+// bitdefenderFileSizeGuess is the expected size of one update file when no version is published yet
+const bitdefenderFileSizeGuess = 1 << 20
+
+// checkBitdefenderSpace estimates the size of the new version as the number of files listed in its
+// versions.dat times the average file size of the published copy, and checks it with checkSpace.
+// Until the new version is published it sits next to everything mirror/bitdefender holds, kept old versions included.
+func checkBitdefenderSpace(tmpDir, destDir string, newVersion int, cfg *config.Config, logger *logrus.Logger) error {
+	data, err := os.ReadFile(filepath.Join(tmpDir, fmt.Sprintf("av64bit_%d/versions.dat", newVersion)))
+	if err != nil {
+		return fmt.Errorf("failed to read versions.dat: %w", err)
+	}
+	count := countDatEntries(data)
+	if count == 0 {
+		logger.Warnf("bitdefender: no files listed in versions.dat, skipping disk space check")
+		return nil
+	}
+	avg := int64(bitdefenderFileSizeGuess)
+	used, files, err := utils.DirUsage(destDir, true)
+	if err != nil {
+		used = 0
+	} else if files > 0 {
+		avg = used / files
+	}
+	need := int64(count) * avg
+	logger.Infof("bitdefender: %d files in versions.dat, about %s needed", count, utils.FormatBytes(need))
+	return checkSpace(cfg, ComponentBitdefender, need, used+need)
+}
+
+// countDatEntries counts the files listed in versions.dat: lines with a name in the third field
+func countDatEntries(data []byte) int {
+	count := 0
+	for _, line := range strings.Split(string(data), "\n") {
+		if len(strings.Fields(line)) >= 3 {
+			count++
+		}
+	}
+	return count
+}
+
+func handleThinSdkFiles(ctx context.Context, tmpDir string, cfg *config.Config, logger *logrus.Logger) error {
 	idURL := "https://upgrade.bitdefender.com/as-thin-sdk-win-x86_64/versions.id"
 	idPath := "as-thin-sdk-win-x86_64/versions.id"
 	destPathID := filepath.Join(tmpDir, idPath)
 	if err := os.MkdirAll(filepath.Dir(destPathID), 0755); err != nil {
-		logger.Errorf("bitdefender: failed to create directory for as-thin-sdk-win-x86_64: %v", err)
-		return
+		return fmt.Errorf("failed to create directory for as-thin-sdk-win-x86_64: %w", err)
 	}
-	respID, err := utils.HTTPGetWithRetry(idURL, cfg.RetryCount, time.Duration(cfg.RetryDelaySeconds)*time.Second, cfg.ProxyURL)
+	respID, err := utils.HTTPGetWithRetry(ctx, idURL, cfg.RetryCount, time.Duration(cfg.RetryDelaySeconds)*time.Second, cfg.ProxyURL)
 	if err != nil {
-		logger.Errorf("bitdefender: failed to fetch versions.id for as-thin-sdk-win-x86_64: %v", err)
-		return
+		return fmt.Errorf("failed to fetch versions.id for as-thin-sdk-win-x86_64: %w", err)
 	}
 	defer respID.Body.Close()
-	if err := utils.SaveResponseToFile(respID.Body, destPathID); err != nil {
-		logger.Errorf("bitdefender: failed to save versions.id for as-thin-sdk-win-x86_64: %v", err)
-		return
+	if err := utils.SaveResponse(respID, destPathID); err != nil {
+		return fmt.Errorf("failed to save versions.id for as-thin-sdk-win-x86_64: %w", err)
 	}
 	logger.Infof("Stored bitdefender for as-thin-sdk-win-x86_64 -> %s", idPath)
 	asThinSdkVersionsFile, err := os.Open(destPathID)
 	if err != nil {
-		logger.Errorf("bitdefender: failed to open as-thin-sdk-win-x86_64/versions.id: %v", err)
-		return
+		return fmt.Errorf("failed to open as-thin-sdk-win-x86_64/versions.id: %w", err)
 	}
 	var thinSdkID ThinSdkID
 	decodeErr2 := utils.DecodeXML(asThinSdkVersionsFile, &thinSdkID)
 	closeErr2 := asThinSdkVersionsFile.Close()
 	if decodeErr2 != nil {
-		logger.Errorf("bitdefender: failed to parse as-thin-sdk-win-x86_64/versions.id XML: %v", decodeErr2)
+		return fmt.Errorf("failed to parse as-thin-sdk-win-x86_64/versions.id XML: %w", decodeErr2)
 	}
 	if closeErr2 != nil {
 		logger.Errorf("bitdefender: failed to close as-thin-sdk-win-x86_64/versions.id: %v", closeErr2)
 	}
 	thinID := thinSdkID.All.ID.Value
 	if thinID == "" {
-		logger.Errorf("bitdefender: no id value found in as-thin-sdk-win-x86_64/versions.id")
+		return errors.New("no id value found in as-thin-sdk-win-x86_64/versions.id")
 	}
 	// Скачиваем versions.dat и .gz по id
 	for _, ext := range []string{"versions.dat", "versions.dat.gz"} {
 		url := fmt.Sprintf("https://upgrade.bitdefender.com/as-thin-sdk-win-x86_64_%s/%s", thinID, ext)
 		path := filepath.Join(tmpDir, fmt.Sprintf("as-thin-sdk-win-x86_64_%s/%s", thinID, ext))
 		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
-			logger.Errorf("bitdefender: failed to create directory for as-thin-sdk-win-x86_64_[id]: %v", err)
-			continue
+			return fmt.Errorf("failed to create directory for as-thin-sdk-win-x86_64_[id]: %w", err)
 		}
-		resp, err := utils.HTTPGetWithRetry(url, cfg.RetryCount, time.Duration(cfg.RetryDelaySeconds)*time.Second, cfg.ProxyURL)
+		resp, err := utils.HTTPGetWithRetry(ctx, url, cfg.RetryCount, time.Duration(cfg.RetryDelaySeconds)*time.Second, cfg.ProxyURL)
 		if err != nil {
-			logger.Errorf("bitdefender: failed to fetch as-thin-sdk-win-x86_64_[id]/%s: %v", ext, err)
-			continue
+			return fmt.Errorf("failed to fetch as-thin-sdk-win-x86_64_[id]/%s: %w", ext, err)
 		}
-		defer resp.Body.Close()
-		if err := utils.SaveResponseToFile(resp.Body, path); err != nil {
-			logger.Errorf("bitdefender: failed to save as-thin-sdk-win-x86_64_[id]/%s: %v", ext, err)
-		} else {
-			logger.Infof("Stored bitdefender -> %s", path)
+		err = utils.SaveResponse(resp, path)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to save as-thin-sdk-win-x86_64_[id]/%s: %w", ext, err)
 		}
+		logger.Infof("Stored bitdefender -> %s", path)
 	}
 	// Читаем versions.dat и скачиваем файлы
 	thinDatPath := filepath.Join(tmpDir, fmt.Sprintf("as-thin-sdk-win-x86_64_%s/versions.dat", thinID))
 	thinDatBytes, err := os.ReadFile(thinDatPath)
 	if err != nil {
-		logger.Errorf("bitdefender: failed to read as-thin-sdk-win-x86_64_[id]/versions.dat: %v", err)
+		return fmt.Errorf("failed to read as-thin-sdk-win-x86_64_[id]/versions.dat: %w", err)
 	}
-	lines := strings.Split(string(thinDatBytes), "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
+	var files []fileDownload
+	for _, line := range strings.Split(string(thinDatBytes), "\n") {
 		parts := strings.Fields(line)
 		if len(parts) < 3 {
 			continue
 		}
 		filename := parts[2] + ".gzip"
-		fileURL := fmt.Sprintf("https://upgrade.bitdefender.com/as-thin-sdk-win-x86_64_%s/avx/%s", thinID, filename)
-		fileDest := filepath.Join(tmpDir, fmt.Sprintf("as-thin-sdk-win-x86_64_%s/avx/%s", thinID, filename))
-		if err := os.MkdirAll(filepath.Dir(fileDest), 0755); err != nil {
-			logger.Errorf("bitdefender: failed to create directory for as-thin-sdk-win-x86_64_[id] file: %v", err)
-			continue
-		}
-		if !utils.DownloadFileWithProxy(fileURL, fileDest, cfg.ProxyURL, cfg.RetryCount, time.Duration(cfg.RetryDelaySeconds)*time.Second, logger) {
-			logger.Errorf("bitdefender: failed to download as-thin-sdk-win-x86_64_[id] file %s", fileURL)
-			continue
-		}
-		logger.Debugf("Stored bitdefender as-thin-sdk-win-x86_64_[id] -> %s", fileDest)
+		files = append(files, fileDownload{
+			URL:  fmt.Sprintf("https://upgrade.bitdefender.com/as-thin-sdk-win-x86_64_%s/avx/%s", thinID, filename),
+			Dest: filepath.Join(tmpDir, fmt.Sprintf("as-thin-sdk-win-x86_64_%s/avx/%s", thinID, filename)),
+		})
 	}
+	if err := downloadFiles(ctx, cfg, logger, "bitdefender as-thin-sdk", cfg.BitdefenderWorkers, files); err != nil {
+		return fmt.Errorf("as-thin-sdk-win-x86_64_[id] files: %w", err)
+	}
+	return nil
 }
 
-func downloadV3Archives(tmpDir string, info Info, cfg *config.Config, logger *logrus.Logger) {
+// downloadV3Archives downloads the id, dat and sig archives of the version, a failed one fails the version
+func downloadV3Archives(ctx context.Context, tmpDir string, info Info, cfg *config.Config, logger *logrus.Logger) error {
 	archiveUrls := []struct{ path, name string }{
 		{info.V3.IDPath, "id"},
 		{info.V3.DatPath, "dat"},
 		{info.V3.SigPath, "sig"},
 	}
 	for _, arch := range archiveUrls {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		if arch.path == "" {
 			continue
 		}
 		url := "https://upgrade.bitdefender.com/" + arch.path
 		filename := filepath.Base(arch.path)
 		destPath := filepath.Join(tmpDir, filename)
-		if !utils.DownloadFileWithProxy(url, destPath, cfg.ProxyURL, cfg.RetryCount, time.Duration(cfg.RetryDelaySeconds)*time.Second, logger) {
-			logger.Errorf("bitdefender: failed to download %s archive", arch.name)
-			return
+		if err := utils.DownloadFile(ctx, url, destPath, cfg.ProxyURL, cfg.RetryCount, time.Duration(cfg.RetryDelaySeconds)*time.Second); err != nil {
+			return fmt.Errorf("failed to download %s archive: %w", arch.name, err)
 		}
 		logger.Debugf("bitdefender: downloaded %s archive", arch.name)
 	}
+	return nil
 }
 
 func extractAndParseDatJSON(tmpDir string, info Info, logger *logrus.Logger) (BitdefenderDat, error) {
@@ -278,28 +358,21 @@ type BitdefenderDat struct {
 	Files []BitdefenderFile `json:"files"`
 }
 
-func downloadDatFiles(tmpDir string, newVersion int, dat BitdefenderDat, cfg *config.Config, logger *logrus.Logger) {
-	totalFiles := len(dat.Files)
-	for i, f := range dat.Files {
+func downloadDatFiles(ctx context.Context, tmpDir string, newVersion int, dat BitdefenderDat, cfg *config.Config, logger *logrus.Logger) error {
+	var files []fileDownload
+	for _, f := range dat.Files {
 		if f.URL == "" || f.LocalPath == "" {
 			continue
 		}
-		gzipURL := fmt.Sprintf("https://upgrade.bitdefender.com/av64bit_%d/avx/%s.gzip", newVersion, f.LocalPath)
-		gzipDest := filepath.Join(tmpDir, fmt.Sprintf("av64bit_%d/avx/%s.gzip", newVersion, f.LocalPath))
-		if err := os.MkdirAll(filepath.Dir(gzipDest), 0755); err != nil {
-			logger.Errorf("bitdefender: failed to create directory for gzip file: %v", err)
-			continue
-		}
-		if !utils.DownloadFileWithProxy(gzipURL, gzipDest, cfg.ProxyURL, cfg.RetryCount, time.Duration(cfg.RetryDelaySeconds)*time.Second, logger) {
-			logger.Errorf("bitdefender: failed to download gzip %s", gzipURL)
-		} else {
-			logger.Debugf("Stored bitdefender gzip -> %s", gzipDest)
-		}
-		if (i+1)%10 == 0 || i == totalFiles-1 {
-			percent := float64(i+1) / float64(totalFiles) * 100
-			logger.Infof("bitdefender: update in progress... %.1f%% (%d/%d)", percent, i+1, totalFiles)
-		}
+		files = append(files, fileDownload{
+			URL:  fmt.Sprintf("https://upgrade.bitdefender.com/av64bit_%d/avx/%s.gzip", newVersion, f.LocalPath),
+			Dest: filepath.Join(tmpDir, fmt.Sprintf("av64bit_%d/avx/%s.gzip", newVersion, f.LocalPath)),
+		})
+	}
+	if err := downloadFiles(ctx, cfg, logger, "bitdefender", cfg.BitdefenderWorkers, files); err != nil {
+		return fmt.Errorf("gzip files: %w", err)
 	}
+	return nil
 }
 
 func replaceBitdefenderDirs(destDir, tmpDir string, logger *logrus.Logger) bool {
@@ -430,3 +503,61 @@ type ThinSdkID struct {
 		} `xml:"id"`
 	} `xml:"all"`
 }
+
+// bitdefenderUpdater mirrors the Bitdefender databases, in proxy mode it only cleans up old versions
+type bitdefenderUpdater struct{}
+
+func (bitdefenderUpdater) Name() string  { return ComponentBitdefender }
+func (bitdefenderUpdater) Title() string { return "Bitdefender" }
+
+func (bitdefenderUpdater) Schedule(cfg *config.Config) string { return cfg.BitdefenderSchedule }
+
+func (bitdefenderUpdater) Enabled(cfg *config.Config) bool {
+	return cfg.BitdefenderMode == "mirror" || cfg.BitdefenderMode == "proxy"
+}
+
+func (bitdefenderUpdater) Check(ctx context.Context, conn *sql.DB, cfg *config.Config, logger *logrus.Logger) []VersionCheck {
+	return []VersionCheck{checkBitdefender(ctx, conn, cfg)}
+}
+
+func (bitdefenderUpdater) Apply(ctx context.Context, conn *sql.DB, cfg *config.Config, logger *logrus.Logger) *ComponentResult {
+	switch cfg.BitdefenderMode {
+	case "mirror":
+		return downloadAndStoreBitdefender(ctx, conn, cfg.BitdefenderURLs, "mirror/bitdefender", cfg, logger)
+	case "proxy":
+		// В proxy mode выполняем только очистку старых версий
+		res := newResult(ComponentBitdefender)
+		currentVersion := db.GetBitdefenderVersion(conn)
+		if currentVersion > 0 {
+			cleanupOldBitdefenderVersions("mirror/bitdefender", currentVersion, cfg.BitdefenderKeepVersions, logger)
+			res.OldVersion = strconv.Itoa(currentVersion)
+			res.NewVersion = res.OldVersion
+		} else {
+			logger.Info("Bitdefender proxy mode: no current version in DB, skipping cleanup")
+		}
+		res.Message = "proxy mode, files are fetched on demand"
+		return res.finish(ctx)
+	}
+	logger.Infof("Bitdefender is disabled by config (current mode: %s).", cfg.BitdefenderMode)
+	return newResult(ComponentBitdefender).skip("disabled by config")
+}
+
+// Status counts Bitdefender in the system health only in mirror mode,
+// in proxy mode the files are fetched on demand and can't go stale.
+func (bitdefenderUpdater) Status(conn *sql.DB, cfg *config.Config) ComponentStatus {
+	var st ComponentStatus
+	ver := db.GetBitdefenderVersion(conn)
+	if ver > 0 {
+		st.Version = strconv.Itoa(ver)
+	}
+	success, at, err := db.GetBitdefenderUpdateStatus(conn)
+	if err == nil {
+		st.LastSuccess, _ = db.ParseTime(at)
+	}
+	if cfg.BitdefenderMode == "mirror" {
+		st.Databases = []DatabaseStatus{{Name: "Bitdefender", Version: strconv.Itoa(ver), Success: success}}
+	} else {
+		st.OnDemand = true
+	}
+	return st
+}
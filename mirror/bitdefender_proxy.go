@@ -1,9 +1,9 @@
 package mirror
 
 import (
-	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
@@ -84,22 +84,26 @@ func BitdefenderProxyHandler(cfg *config.Config, logger *logrus.Logger) echo.Han
 		cacheable := shouldCacheWithLog(requestPath, logger)
 		logger.Infof("Bitdefender proxy: file %s cacheable=%v", path.Base(requestPath), cacheable)
 
-		// Если файл некэшируемый, но существует в кэше - удаляем его
-		if !cacheable {
-			if _, err := os.Stat(localPath); err == nil {
-				logger.Infof("Bitdefender proxy: deleting old cached non-cacheable file: %s", localPath)
-				if err := os.Remove(localPath); err != nil {
-					logger.Errorf("Bitdefender proxy: failed to delete cached file: %v", err)
-				}
-			}
-			logger.Infof("Bitdefender proxy: file %s is non-cacheable, always fetching from remote", path.Base(requestPath))
-		} else {
-			// Если файл кэшируемый, проверяем наличие в кэше
-			if _, err := os.Stat(localPath); err == nil {
-				// Файл уже закэширован, отдаём его
-				logger.Infof("Bitdefender proxy: serving cached file: %s", localPath)
+		_, statErr := os.Stat(localPath)
+		cached := statErr == nil
+		if cacheable && cached {
+			// Файл уже закэширован, отдаём его
+			logger.Infof("Bitdefender proxy: serving cached file: %s", localPath)
+			return c.File(localPath)
+		}
+
+		// В окне blackout кэш не пополняем и к upstream не ходим,
+		// некэшируемый файл отдаём из последней сохранённой копии, если она есть
+		if end := BlackoutUntil(cfg, time.Now()); !end.IsZero() {
+			if cached {
+				logger.Infof("Bitdefender proxy: blackout window until %s, serving cached copy of %s", end.Format("2006-01-02 15:04:05"), localPath)
 				return c.File(localPath)
 			}
+			logger.Infof("Bitdefender proxy: blackout window until %s, not fetching %s", end.Format("2006-01-02 15:04:05"), requestPath)
+			return BlackoutResponse(c, end)
+		}
+		if !cacheable {
+			logger.Infof("Bitdefender proxy: file %s is non-cacheable, always fetching from remote", path.Base(requestPath))
 		}
 
 		// Файл не найден в кэше, запрашиваем с удалённого сервера
@@ -113,22 +117,20 @@ func BitdefenderProxyHandler(cfg *config.Config, logger *logrus.Logger) echo.Han
 		baseURL = strings.TrimSuffix(baseURL, "/")
 		cleanPath := strings.TrimPrefix(requestPath, "/")
 		remoteURL := baseURL + "/" + cleanPath
-		logger.Infof("Bitdefender proxy: fetching from remote: %s (using proxy: %s)", remoteURL, cfg.ProxyURL)
+		route := "direct"
+		if u, err := url.Parse(remoteURL); err == nil {
+			route = utils.SharedClient().ProxyRoute(ComponentBitdefender, u.Hostname(), cfg.ProxyURL)
+		}
+		logger.Infof("Bitdefender proxy: fetching from remote: %s (%s)", remoteURL, route)
 
-		// Создаём HTTP клиент с поддержкой прокси и увеличенным timeout для больших файлов
-		client, err := utils.CreateHTTPClient(cfg.ProxyURL, 300*time.Second)
+		// Клиент общего пула с поддержкой прокси и увеличенным timeout для больших файлов
+		client, err := utils.SharedClient().HTTPClient(cfg.ProxyURL, 300*time.Second)
 		if err != nil {
 			logger.Errorf("Bitdefender proxy: failed to create HTTP client: %v", err)
 			return c.String(http.StatusInternalServerError, "500 Internal Server Error")
 		}
 
-		if cfg.ProxyURL != "" {
-			logger.Debugf("Bitdefender proxy: using HTTP proxy: %s", cfg.ProxyURL)
-		}
-
 		// Выполняем запрос к удалённому серверу с повторными попытками
-		var resp *http.Response
-		var lastErr error
 		retries := cfg.RetryCount
 		if retries < 1 {
 			retries = 3 // минимум 1 попытка + 2 повторные
@@ -137,80 +139,70 @@ func BitdefenderProxyHandler(cfg *config.Config, logger *logrus.Logger) echo.Han
 		if retryDelay == 0 {
 			retryDelay = 10 * time.Second
 		}
-
-		for attempt := 0; attempt <= retries; attempt++ {
-			if attempt > 0 {
-				logger.Infof("Bitdefender proxy: retry attempt %d/%d after %v", attempt, retries, retryDelay)
-				time.Sleep(retryDelay)
-			}
-
-			resp, err = client.Get(remoteURL)
-			if err == nil && resp.StatusCode == http.StatusOK {
-				break // Успешный запрос
-			}
-
-			// Сохраняем последнюю ошибку
-			lastErr = err
-			if resp != nil {
-				if resp.StatusCode != http.StatusOK {
-					lastErr = fmt.Errorf("server returned status %d", resp.StatusCode)
-				}
-				resp.Body.Close()
-				resp = nil
+		// Загрузка из upstream ограничивается общим лимитом и лимитом Bitdefender и переключается по списку failover
+		upstreamCtx := UpstreamContext(c.Request().Context(), cfg, ComponentBitdefender)
+		resp, err := utils.GetWithRetry(upstreamCtx, client, remoteURL, retries, retryDelay)
+		if err != nil {
+			logger.Errorf("Bitdefender proxy: all attempts failed, last error: %v", err)
+			// 404 и другие ошибки клиента upstream передаём как есть, Kerio не будет ждать 502
+			if code := utils.StatusCode(err); code >= 400 && code < 500 {
+				return c.String(code, http.StatusText(code))
 			}
-
-			logger.Warnf("Bitdefender proxy: attempt %d failed: %v", attempt+1, lastErr)
-		}
-
-		// Если все попытки провалились
-		if resp == nil || lastErr != nil {
-			logger.Errorf("Bitdefender proxy: all attempts failed, last error: %v", lastErr)
 			return c.String(http.StatusBadGateway, "502 Bad Gateway")
 		}
 		defer resp.Body.Close()
+		resp.Body = utils.LimitBody(upstreamCtx, resp.Body)
 
-		// Если файл не должен кэшироваться, просто проксируем его клиенту
+		// Некэшируемый файл клиенту всегда отдаётся из upstream. Его последнюю копию заменяем
+		// свежим ответом: она нужна только в окне blackout
 		if !cacheable {
+			var w io.Writer = c.Response().Writer
+			copyFile, err := utils.CreateAtomic(localPath)
+			if err != nil {
+				logger.Errorf("Bitdefender proxy: failed to create temp file: %v", err)
+			} else {
+				defer copyFile.Abort()
+				w = io.MultiWriter(copyFile, c.Response().Writer)
+			}
 			c.Response().Header().Set("Content-Type", resp.Header.Get("Content-Type"))
 			c.Response().WriteHeader(resp.StatusCode)
-			_, err := io.Copy(c.Response().Writer, resp.Body)
-			if err != nil {
+			if _, err := io.Copy(w, resp.Body); err != nil {
 				logger.Errorf("Bitdefender proxy: failed to proxy non-cacheable file to client: %v", err)
-			} else {
-				logger.Infof("Bitdefender proxy: proxied non-cacheable file: %s", path.Base(requestPath))
+				return nil
+			}
+			logger.Infof("Bitdefender proxy: proxied non-cacheable file: %s", path.Base(requestPath))
+			if copyFile != nil {
+				if err := copyFile.Commit(utils.ExpectFromResponse(resp)); err != nil {
+					logger.Errorf("Bitdefender proxy: failed to save copy of %s: %v", localPath, err)
+				}
 			}
 			return nil
 		}
 
+		// Квота Bitdefender или свободное место на исходе: файл отдаём клиенту, но не кэшируем
+		need := max(resp.ContentLength, 0)
+		used, _, _ := componentUsage(ComponentBitdefender)
+		if err := checkSpace(cfg, ComponentBitdefender, need, used+need); err != nil {
+			logger.Warnf("Bitdefender proxy: not caching %s: %v", path.Base(requestPath), err)
+			return passThrough(c, resp, logger)
+		}
+
 		// Создаём директорию для кэша, если её нет
 		localDir := filepath.Dir(localPath)
 		if err := os.MkdirAll(localDir, 0755); err != nil {
 			logger.Errorf("Bitdefender proxy: failed to create cache directory %s: %v", localDir, err)
 			// Всё равно отдаём файл клиенту, просто не кэшируем
-			c.Response().Header().Set("Content-Type", resp.Header.Get("Content-Type"))
-			c.Response().WriteHeader(resp.StatusCode)
-			_, copyErr := io.Copy(c.Response().Writer, resp.Body)
-			if copyErr != nil {
-				logger.Errorf("Bitdefender proxy: failed to copy response to client: %v", copyErr)
-			}
-			return nil
+			return passThrough(c, resp, logger)
 		}
 
 		// Создаём временный файл для сохранения
-		tempFile, err := os.CreateTemp(localDir, "bitdefender_*.tmp")
+		tempFile, err := utils.CreateAtomic(localPath)
 		if err != nil {
 			logger.Errorf("Bitdefender proxy: failed to create temp file: %v", err)
 			// Отдаём файл без кэширования
-			c.Response().Header().Set("Content-Type", resp.Header.Get("Content-Type"))
-			c.Response().WriteHeader(resp.StatusCode)
-			_, copyErr := io.Copy(c.Response().Writer, resp.Body)
-			if copyErr != nil {
-				logger.Errorf("Bitdefender proxy: failed to copy response to client: %v", copyErr)
-			}
-			return nil
+			return passThrough(c, resp, logger)
 		}
-		tempFilePath := tempFile.Name()
-		defer os.Remove(tempFilePath) // Удалим временный файл в случае ошибки
+		defer tempFile.Abort() // Удалим временный файл в случае ошибки
 
 		// Одновременно записываем в файл и отправляем клиенту
 		c.Response().Header().Set("Content-Type", resp.Header.Get("Content-Type"))
@@ -218,22 +210,31 @@ func BitdefenderProxyHandler(cfg *config.Config, logger *logrus.Logger) echo.Han
 
 		// Используем MultiWriter для записи одновременно в файл и ответ клиенту
 		multiWriter := io.MultiWriter(tempFile, c.Response().Writer)
-		_, err = io.Copy(multiWriter, resp.Body)
-		tempFile.Close()
-
+		written, err := io.Copy(multiWriter, resp.Body)
 		if err != nil {
 			logger.Errorf("Bitdefender proxy: failed to save and send file: %v", err)
 			return nil // Ответ уже начали отправлять
 		}
 
-		// Переименовываем временный файл в целевой
-		if err := os.Rename(tempFilePath, localPath); err != nil {
-			logger.Errorf("Bitdefender proxy: failed to rename temp file to %s: %v", localPath, err)
+		// Проверяем размер и хеши из заголовков, затем переименовываем временный файл в целевой
+		if err := tempFile.Commit(utils.ExpectFromResponse(resp)); err != nil {
+			logger.Errorf("Bitdefender proxy: failed to cache %s: %v", localPath, err)
 			return nil // Файл уже отправлен клиенту
 		}
 
 		logger.Infof("Bitdefender proxy: cached file: %s", localPath)
+		addUsage(ComponentBitdefender, written)
 
 		return nil
 	}
 }
+
+// passThrough sends the upstream response to the client without caching it
+func passThrough(c echo.Context, resp *http.Response, logger *logrus.Logger) error {
+	c.Response().Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	c.Response().WriteHeader(resp.StatusCode)
+	if _, err := io.Copy(c.Response().Writer, resp.Body); err != nil {
+		logger.Errorf("Bitdefender proxy: failed to copy response to client: %v", err)
+	}
+	return nil
+}
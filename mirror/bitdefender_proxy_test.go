@@ -1,7 +1,17 @@
 package mirror
 
 import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
 	"testing"
+
+	"kerio-mirror-go/config"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
 )
 
 func TestShouldCache(t *testing.T) {
@@ -107,3 +117,59 @@ func BenchmarkShouldCache(b *testing.B) {
 		shouldCache(paths[i%len(paths)])
 	}
 }
+
+func TestBitdefenderProxyHandler_BlackoutServesLastCopy(t *testing.T) {
+	t.Chdir(t.TempDir())
+	var version atomic.Value
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Write([]byte(version.Load().(string)))
+	}))
+	defer srv.Close()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	cfg := &config.Config{BitdefenderProxyBaseURL: srv.URL, RetryCount: 1, RetryDelaySeconds: 1}
+	handler := BitdefenderProxyHandler(cfg, logger)
+	get := func() *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/av64bit/versions.id", nil)
+		rec := httptest.NewRecorder()
+		if err := handler(echo.New().NewContext(req, rec)); err != nil {
+			t.Fatalf("Handler returned error: %v", err)
+		}
+		return rec
+	}
+
+	// Копии ещё нет: в окне blackout 503
+	cfg.BlackoutWindows = "00:00-00:00"
+	if rec := get(); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 without a copy, got %d", rec.Code)
+	}
+
+	version.Store("1")
+	cfg.BlackoutWindows = ""
+	if rec := get(); rec.Code != http.StatusOK || rec.Body.String() != "1" {
+		t.Errorf("Expected version 1 from upstream, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	// В окне blackout отдаётся последняя копия, upstream не запрашивается
+	version.Store("2")
+	cfg.BlackoutWindows = "00:00-00:00"
+	if rec := get(); rec.Code != http.StatusOK || rec.Body.String() != "1" {
+		t.Errorf("Expected cached version 1 during blackout, got %d %q", rec.Code, rec.Body.String())
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("Expected 1 upstream request, got %d", n)
+	}
+
+	// Вне окна файл снова берётся из upstream и копия обновляется
+	cfg.BlackoutWindows = ""
+	if rec := get(); rec.Body.String() != "2" {
+		t.Errorf("Expected version 2 from upstream, got %q", rec.Body.String())
+	}
+	if data, err := os.ReadFile("mirror/bitdefender/av64bit/versions.id"); err != nil || string(data) != "2" {
+		t.Errorf("Expected copy with version 2, got %q (%v)", data, err)
+	}
+}
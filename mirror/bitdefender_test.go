@@ -0,0 +1,49 @@
+package mirror
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"kerio-mirror-go/config"
+	"kerio-mirror-go/utils"
+
+	"github.com/sirupsen/logrus"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestDownloadV3Archives_FailedArchive(t *testing.T) {
+	// Архив sig недоступен, id и dat скачиваются
+	prev := utils.SetSharedClient(utils.NewClient(utils.ClientConfig{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		status := http.StatusOK
+		if strings.HasSuffix(r.URL.Path, "/sig.gz") {
+			status = http.StatusNotFound
+		}
+		return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader("data")), Request: r}, nil
+	})}))
+	defer utils.SetSharedClient(prev)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	tmpDir := t.TempDir()
+	info := Info{V3: V3{IDPath: "v3/id.gz", DatPath: "v3/dat.gz", SigPath: "v3/sig.gz"}}
+	err := downloadV3Archives(context.Background(), tmpDir, info, &config.Config{RetryCount: 1}, logger)
+	if err == nil || !strings.Contains(err.Error(), "sig archive") {
+		t.Errorf("Expected sig archive error, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "dat.gz")); err != nil {
+		t.Errorf("Expected dat archive to be downloaded, got %v", err)
+	}
+
+	info.V3.SigPath = ""
+	if err := downloadV3Archives(context.Background(), tmpDir, info, &config.Config{RetryCount: 1}, logger); err != nil {
+		t.Errorf("Expected success without the sig archive, got %v", err)
+	}
+}
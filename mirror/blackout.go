@@ -0,0 +1,142 @@
+package mirror
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"kerio-mirror-go/config"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Blackout is a set of windows when no upstream traffic is allowed
+// (metered links, maintenance). The zero value has no windows.
+type Blackout struct {
+	windows []blackoutWindow
+}
+
+type blackoutWindow struct {
+	days  uint64 // дни недели начала окна, бит 0 - воскресенье
+	start int    // минуты от полуночи
+	dur   time.Duration
+}
+
+// ParseBlackout parses a list of windows separated by ";". Each window is an optional
+// day list in cron day-of-week syntax and a time range, the range may cross midnight:
+//
+//	"Mon-Fri 08:00-18:00; Sat,Sun 10:00-12:00"
+//	"22:00-06:00"      - every night
+//	"Sat 00:00-00:00"  - the whole Saturday (equal times mean 24 hours)
+//
+// An empty spec means no windows.
+func ParseBlackout(spec string) (Blackout, error) {
+	var b Blackout
+	for _, part := range strings.Split(spec, ";") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		w := blackoutWindow{days: 0x7f}
+		switch len(fields) {
+		case 1:
+		case 2:
+			days, err := parseCronField(strings.ToLower(fields[0]), cronDow)
+			if err != nil {
+				return Blackout{}, fmt.Errorf("blackout window %q: days: %w", strings.TrimSpace(part), err)
+			}
+			// 7 is an alias for Sunday
+			if days&(1<<7) != 0 {
+				days |= 1
+			}
+			w.days = days & 0x7f
+		default:
+			return Blackout{}, fmt.Errorf("blackout window %q: expected [days] HH:MM-HH:MM", strings.TrimSpace(part))
+		}
+		bounds := strings.SplitN(fields[len(fields)-1], "-", 2)
+		if len(bounds) != 2 {
+			return Blackout{}, fmt.Errorf("blackout window %q: expected HH:MM-HH:MM", strings.TrimSpace(part))
+		}
+		from, err := time.Parse("15:04", bounds[0])
+		if err != nil {
+			return Blackout{}, fmt.Errorf("blackout window %q: invalid start %q", strings.TrimSpace(part), bounds[0])
+		}
+		to, err := time.Parse("15:04", bounds[1])
+		if err != nil {
+			return Blackout{}, fmt.Errorf("blackout window %q: invalid end %q", strings.TrimSpace(part), bounds[1])
+		}
+		w.start = from.Hour()*60 + from.Minute()
+		w.dur = time.Duration(to.Hour()*60+to.Minute()-w.start) * time.Minute
+		if w.dur <= 0 {
+			w.dur += 24 * time.Hour
+		}
+		b.windows = append(b.windows, w)
+	}
+	return b, nil
+}
+
+// Empty reports whether b has no windows
+func (b Blackout) Empty() bool {
+	return len(b.windows) == 0
+}
+
+// Until returns when the blackout covering t ends, or the zero time if t is outside all windows.
+// Adjacent and overlapping windows are merged, so the result is the first moment traffic is allowed.
+func (b Blackout) Until(t time.Time) time.Time {
+	var until time.Time
+	// Окна не длиннее суток, поэтому цепочка из 8 звеньев покрывает неделю
+	for i := 0; i < 8; i++ {
+		end := b.windowEnd(t)
+		if end.IsZero() {
+			break
+		}
+		until, t = end, end
+	}
+	return until
+}
+
+// windowEnd returns the latest end of the windows containing t, zero if none
+func (b Blackout) windowEnd(t time.Time) time.Time {
+	var end time.Time
+	for _, w := range b.windows {
+		// Окно могло начаться вчера и перейти через полночь
+		for d := -1; d <= 0; d++ {
+			from := time.Date(t.Year(), t.Month(), t.Day()+d, w.start/60, w.start%60, 0, 0, t.Location())
+			if w.days&(1<<uint(from.Weekday())) == 0 {
+				continue
+			}
+			to := from.Add(w.dur)
+			if !t.Before(from) && t.Before(to) && to.After(end) {
+				end = to
+			}
+		}
+	}
+	return end
+}
+
+// BlackoutUntil returns the end of the configured blackout window covering t,
+// or the zero time if upstream traffic is allowed. An invalid BLACKOUT_WINDOWS
+// (rejected by the settings page) disables blackouts.
+func BlackoutUntil(cfg *config.Config, t time.Time) time.Time {
+	if cfg.BlackoutWindows == "" {
+		return time.Time{}
+	}
+	b, err := ParseBlackout(cfg.BlackoutWindows)
+	if err != nil {
+		return time.Time{}
+	}
+	return b.Until(t)
+}
+
+// BlackoutResponse answers an on-demand request that has no cached data and would need upstream
+// during a blackout window: 503 with Retry-After pointing at the window's end.
+func BlackoutResponse(c echo.Context, until time.Time) error {
+	secs := int(time.Until(until).Seconds()) + 1
+	if secs < 1 {
+		secs = 1
+	}
+	c.Response().Header().Set("Retry-After", strconv.Itoa(secs))
+	return c.String(http.StatusServiceUnavailable, "503 Service Unavailable: upstream downloads are paused by a blackout window until "+until.Format("2006-01-02 15:04"))
+}
@@ -0,0 +1,69 @@
+package mirror
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseBlackout(t *testing.T) {
+	valid := []string{
+		"",
+		"22:00-06:00",
+		"Mon-Fri 08:00-18:00; Sat,Sun 10:00-12:00",
+		"sat 00:00-00:00;",
+		"0,7 01:00-02:00",
+	}
+	for _, spec := range valid {
+		if _, err := ParseBlackout(spec); err != nil {
+			t.Errorf("ParseBlackout(%q) returned error: %v", spec, err)
+		}
+	}
+	invalid := []string{
+		"08:00",
+		"Mon-Fri 08:00",
+		"Funday 08:00-09:00",
+		"Mon 8-18",
+		"Mon 08:00-18:00 extra",
+		"25:00-26:00",
+	}
+	for _, spec := range invalid {
+		if _, err := ParseBlackout(spec); err == nil {
+			t.Errorf("ParseBlackout(%q) expected error, got nil", spec)
+		}
+	}
+}
+
+func TestBlackoutUntil(t *testing.T) {
+	loc := time.Local
+	// 2025-03-14 - пятница
+	at := func(day, hour, min int) time.Time { return time.Date(2025, 3, day, hour, min, 0, 0, loc) }
+
+	tests := []struct {
+		spec     string
+		t        time.Time
+		expected time.Time
+	}{
+		{"Mon-Fri 08:00-18:00", at(14, 12, 0), at(14, 18, 0)},
+		{"Mon-Fri 08:00-18:00", at(14, 18, 0), time.Time{}},
+		{"Mon-Fri 08:00-18:00", at(15, 12, 0), time.Time{}},
+		{"Mon-Fri 08:00-18:00", at(14, 8, 0), at(14, 18, 0)},
+		// Через полночь: окно пятницы продолжается в субботу
+		{"Fri 22:00-06:00", at(15, 3, 0), at(15, 6, 0)},
+		{"Fri 22:00-06:00", at(14, 3, 0), time.Time{}},
+		// Смежные окна склеиваются
+		{"Mon-Fri 08:00-18:00; Fri 18:00-20:00", at(14, 9, 0), at(14, 20, 0)},
+		{"22:00-06:00; 05:00-07:00", at(14, 23, 0), at(15, 7, 0)},
+		// Одинаковое время - сутки целиком
+		{"Sat 00:00-00:00", at(15, 13, 0), at(16, 0, 0)},
+		{"", at(14, 12, 0), time.Time{}},
+	}
+	for _, tt := range tests {
+		b, err := ParseBlackout(tt.spec)
+		if err != nil {
+			t.Fatalf("ParseBlackout(%q) returned error: %v", tt.spec, err)
+		}
+		if got := b.Until(tt.t); !got.Equal(tt.expected) {
+			t.Errorf("Until(%q, %s): expected %s, got %s", tt.spec, tt.t.Format("Mon 15:04"), tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package mirror
+
+import (
+	"database/sql"
+	"math/rand/v2"
+	"time"
+
+	"kerio-mirror-go/config"
+	"kerio-mirror-go/db"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TriggerCatchUp marks the update of stale components right after startup
+const TriggerCatchUp = "catchup"
+
+// componentLastSuccess returns when the component last got current data:
+// the newer of st.LastSuccess and its last successful run in the history. Zero means never.
+func componentLastSuccess(conn *sql.DB, st ComponentStatus) time.Time {
+	last := st.LastSuccess
+	if at, err := db.GetComponentLastSuccess(conn, st.Component); err == nil {
+		if t, ok := db.ParseTime(at); ok && t.After(last) {
+			last = t
+		}
+	}
+	return last
+}
+
+// StaleComponents returns the enabled components whose data is older than maxAge at now.
+// Components serving data on demand (Bitdefender in proxy mode) are never stale.
+func StaleComponents(conn *sql.DB, cfg *config.Config, maxAge time.Duration, now time.Time) []string {
+	var stale []string
+	for _, st := range ComponentStatuses(conn, cfg) {
+		if !st.Enabled || st.OnDemand {
+			continue
+		}
+		last := componentLastSuccess(conn, st)
+		if last.IsZero() || now.Sub(last) > maxAge {
+			stale = append(stale, st.Component)
+		}
+	}
+	return stale
+}
+
+// CatchUp updates stale components once after startup instead of waiting for their next scheduled run.
+// The run starts after a random delay of up to cfg.CatchUpMaxDelaySeconds, so several mirrors
+// restarted together do not hit upstream at the same moment. Staleness is checked again after the delay
+// in case a scheduled or manual run has updated the data in the meantime.
+// If the run would fall into a blackout window, it waits for the window's end (plus the random delay).
+func CatchUp(cfg *config.Config, logger *logrus.Logger, runner *Runner) {
+	if cfg.CatchUpMaxAgeHours <= 0 {
+		return
+	}
+	maxAge := time.Duration(cfg.CatchUpMaxAgeHours) * time.Hour
+	staleNow := func() []string {
+		conn, err := sql.Open("sqlite", cfg.DatabasePath)
+		if err != nil {
+			logger.Errorf("DB open error: %v", err)
+			return nil
+		}
+		defer conn.Close()
+		return StaleComponents(conn, cfg, maxAge, time.Now())
+	}
+
+	stale := staleNow()
+	if len(stale) == 0 {
+		logger.Infof("Catch-up: all components are fresher than %s", maxAge)
+		return
+	}
+	var delay time.Duration
+	if cfg.CatchUpMaxDelaySeconds > 0 {
+		delay = rand.N(time.Duration(cfg.CatchUpMaxDelaySeconds) * time.Second)
+	}
+	if end := BlackoutUntil(cfg, time.Now().Add(delay)); !end.IsZero() {
+		delay = time.Until(end) + delay
+		logger.Infof("Catch-up: blackout window until %s", end.Format("2006-01-02 15:04:05"))
+	}
+	logger.Infof("Catch-up: %v older than %s, updating in %s", stale, maxAge, delay.Round(time.Second))
+	time.Sleep(delay)
+
+	if stale = staleNow(); len(stale) == 0 {
+		logger.Info("Catch-up: data was updated in the meantime, nothing to do")
+		return
+	}
+	runner.Run(TriggerCatchUp, stale)
+}
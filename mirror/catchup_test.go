@@ -0,0 +1,63 @@
+package mirror
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"kerio-mirror-go/config"
+	"kerio-mirror-go/db"
+)
+
+func TestStaleComponents(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "mirror.db")
+	if err := db.Init(dbPath); err != nil {
+		t.Fatalf("DB init failed: %v", err)
+	}
+	conn, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("DB open failed: %v", err)
+	}
+	defer conn.Close()
+
+	now := time.Date(2025, 3, 14, 12, 0, 0, 0, time.Local)
+	// IDS1 свежий, IDS2 устарел - компонент IDS устарел целиком
+	if err := db.UpdateIDSVersion(conn, "1", 100, "a", true, now.Add(-2*time.Hour)); err != nil {
+		t.Fatalf("UpdateIDSVersion failed: %v", err)
+	}
+	if err := db.UpdateIDSVersion(conn, "2", 100, "b", true, now.Add(-72*time.Hour)); err != nil {
+		t.Fatalf("UpdateIDSVersion failed: %v", err)
+	}
+	// GeoIP свежий
+	if err := db.UpdateIDSVersion(conn, "4", 20250314, "full-4.gz", true, now.Add(-time.Hour)); err != nil {
+		t.Fatalf("UpdateIDSVersion failed: %v", err)
+	}
+	// Custom files: своего времени нет, свежесть берётся из истории запусков
+	if err := db.InsertRunComponent(conn, db.RunComponent{RunID: "r1", Component: ComponentCustom, Status: StatusUnchanged, StartedAt: now.Add(-3 * time.Hour).Format("2006-01-02 15:04:05")}); err != nil {
+		t.Fatalf("InsertRunComponent failed: %v", err)
+	}
+
+	cfg := &config.Config{
+		EnableIDS1:         true,
+		EnableIDS2:         true,
+		EnableIDS4:         true,
+		EnableShieldMatrix: true,
+		BitdefenderMode:    "proxy",
+		CustomDownloadURLs: []string{"https://example.com/a.txt"},
+	}
+	got := StaleComponents(conn, cfg, 26*time.Hour, now)
+	expected := []string{ComponentIDS, ComponentShieldMatrix}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected stale components %v, got %v", expected, got)
+	}
+
+	cfg.EnableIDS2 = false
+	cfg.BitdefenderMode = "mirror"
+	got = StaleComponents(conn, cfg, 26*time.Hour, now)
+	expected = []string{ComponentBitdefender, ComponentShieldMatrix}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected stale components %v, got %v", expected, got)
+	}
+}
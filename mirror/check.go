@@ -0,0 +1,248 @@
+package mirror
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"kerio-mirror-go/config"
+	"kerio-mirror-go/db"
+	"kerio-mirror-go/utils"
+
+	"github.com/sirupsen/logrus"
+)
+
+// VersionCheck is the local and remote version of a single database found in check-only mode
+type VersionCheck struct {
+	Component       string `json:"component"`
+	Name            string `json:"name"` // IDSv1, GeoIP, Bitdefender...
+	Local           string `json:"local"`
+	Remote          string `json:"remote"`
+	UpdateAvailable bool   `json:"update_available"`
+	Message         string `json:"message,omitempty"`
+	Error           string `json:"error,omitempty"`
+}
+
+// CheckReport is the result of Check
+type CheckReport struct {
+	CheckedAt time.Time      `json:"checked_at"`
+	Items     []VersionCheck `json:"items"`
+}
+
+// Pending returns the number of databases with an update available
+func (r *CheckReport) Pending() int {
+	n := 0
+	for _, it := range r.Items {
+		if it.UpdateAvailable {
+			n++
+		}
+	}
+	return n
+}
+
+// Failed returns the number of databases that could not be checked
+func (r *CheckReport) Failed() int {
+	n := 0
+	for _, it := range r.Items {
+		if it.Error != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// checkCacheTTL is how long CachedCheck reuses a check: it is served to the dashboard and the API,
+// and every check asks upstream with the license key
+const checkCacheTTL = 5 * time.Minute
+
+// checkCall is a check in progress, callers that find no cached check wait for it
+type checkCall struct {
+	done   chan struct{}
+	report CheckReport
+	ok     bool // проверка не прервана, её результат можно отдавать и запоминать
+}
+
+var (
+	checkMu      sync.Mutex // защищает только lastCheck и checkRunning, сама проверка идёт без него
+	lastCheck    *CheckReport
+	checkRunning *checkCall
+)
+
+// CachedCheck returns the result of a Check made within checkCacheTTL or runs a new one.
+// Concurrent callers wait for the same check instead of each asking upstream.
+func CachedCheck(ctx context.Context, cfg *config.Config, logger *logrus.Logger) CheckReport {
+	for {
+		checkMu.Lock()
+		if lastCheck != nil && time.Since(lastCheck.CheckedAt) < checkCacheTTL {
+			report := *lastCheck
+			checkMu.Unlock()
+			return report
+		}
+		call := checkRunning
+		if call == nil {
+			break
+		}
+		checkMu.Unlock()
+		<-call.done
+		if call.ok {
+			return call.report
+		}
+		// Проверку прервал её вызывающий, запускаем свою
+	}
+	call := &checkCall{done: make(chan struct{})}
+	checkRunning = call
+	checkMu.Unlock()
+
+	call.report = Check(ctx, cfg, logger)
+	// Прерванная проверка неполная, её не запоминаем
+	call.ok = ctx.Err() == nil
+
+	checkMu.Lock()
+	// После resetCheck результат мог устареть: ожидающим он отдаётся, но не запоминается
+	if checkRunning == call {
+		checkRunning = nil
+		if call.ok {
+			lastCheck = &call.report
+		}
+	}
+	checkMu.Unlock()
+	close(call.done)
+	return call.report
+}
+
+// resetCheck drops the cached check, an update run changes the local versions
+func resetCheck() {
+	checkMu.Lock()
+	defer checkMu.Unlock()
+	lastCheck = nil
+	checkRunning = nil
+}
+
+// Check runs only the version-discovery half of every updater and compares local and remote versions.
+// Nothing is downloaded (except the small version files), written to the mirror or stored in the DB.
+func Check(ctx context.Context, cfg *config.Config, logger *logrus.Logger) CheckReport {
+	report := CheckReport{CheckedAt: time.Now(), Items: []VersionCheck{}}
+
+	conn, err := sql.Open("sqlite", cfg.DatabasePath)
+	if err != nil {
+		report.Items = append(report.Items, VersionCheck{Name: "Database", Error: err.Error()})
+		return report
+	}
+	defer conn.Close()
+
+	for _, u := range Updaters() {
+		if !u.Enabled(cfg) || ctx.Err() != nil {
+			continue
+		}
+		report.Items = append(report.Items, u.Check(UpstreamContext(ctx, cfg, u.Name()), conn, cfg, logger)...)
+	}
+	logger.Infof("Check-only: %d update(s) available, %d check(s) failed", report.Pending(), report.Failed())
+	return report
+}
+
+// checkIDS asks update.php for every enabled IDS version except IDSv4 (GeoIP)
+func checkIDS(ctx context.Context, conn *sql.DB, cfg *config.Config, logger *logrus.Logger) []VersionCheck {
+	var items []VersionCheck
+	if cfg.IDSURL == "" || cfg.LicenseNumber == "" {
+		return nil
+	}
+	for _, d := range idsVersionsOf(ComponentIDS) {
+		if !d.enabled(cfg) || ctx.Err() != nil {
+			continue
+		}
+		v := d.version
+		local := db.GetIDSVersion(conn, v)
+		item := VersionCheck{Component: ComponentIDS, Name: "IDSv" + v, Local: strconv.Itoa(local)}
+		remote, _, err := fetchIDSUpdateInfo(ctx, cfg, logger, v)
+		switch {
+		case errors.Is(err, errIDSNoUpdate):
+			item.Message = "update.php offers no full update"
+		case err != nil:
+			item.Error = err.Error()
+		default:
+			item.Remote = strconv.Itoa(remote)
+			item.UpdateAvailable = remote > local
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// checkGeoIP compares Last-Modified of the GeoIP CSV files (HEAD requests) with the last successful update
+func checkGeoIP(ctx context.Context, conn *sql.DB, cfg *config.Config) VersionCheck {
+	item := VersionCheck{Component: ComponentGeoIP, Name: "GeoIP", Local: "-"}
+	var lastSuccess time.Time
+	if ver := db.GetIDSVersion(conn, "4"); ver > 0 {
+		item.Local = strconv.Itoa(ver)
+		if _, at, err := db.GetIDSUpdateStatus(conn, "4"); err == nil {
+			lastSuccess, _ = db.ParseTime(at)
+		}
+	}
+
+	var newest time.Time
+	for _, u := range []string{cfg.GeoIP4URL, cfg.GeoIP6URL} {
+		resp, err := utils.HTTPHead(ctx, u, cfg.ProxyURL)
+		if err != nil {
+			item.Error = err.Error()
+			return item
+		}
+		modified, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+		if err != nil {
+			item.Remote = "unknown"
+			item.Message = "upstream sends no Last-Modified, the files are re-downloaded on every run"
+			item.UpdateAvailable = true
+			return item
+		}
+		if modified.After(newest) {
+			newest = modified
+		}
+	}
+	item.Remote = newest.Format("20060102")
+	item.Message = "modified " + newest.Local().Format("2006-01-02 15:04")
+	item.UpdateAvailable = lastSuccess.IsZero() || newest.After(lastSuccess)
+	return item
+}
+
+// checkBitdefender reads versions.id into memory and compares it with the version in the DB
+func checkBitdefender(ctx context.Context, conn *sql.DB, cfg *config.Config) VersionCheck {
+	local := db.GetBitdefenderVersion(conn)
+	item := VersionCheck{Component: ComponentBitdefender, Name: "Bitdefender", Local: strconv.Itoa(local)}
+	remote, _, _, err := fetchBitdefenderVersion(ctx, cfg)
+	if err != nil {
+		item.Error = err.Error()
+		return item
+	}
+	item.Remote = strconv.Itoa(remote)
+	item.UpdateAvailable = remote > local
+	if cfg.BitdefenderMode == "proxy" {
+		item.Message = "proxy mode, files are fetched on demand"
+	}
+	return item
+}
+
+// checkShieldMatrix calls check_update and the CloudFront /version endpoint
+func checkShieldMatrix(ctx context.Context, conn *sql.DB, cfg *config.Config, logger *logrus.Logger) VersionCheck {
+	local := db.GetShieldMatrixVersion(conn)
+	item := VersionCheck{Component: ComponentShieldMatrix, Name: "Shield Matrix", Local: local}
+	cloudFrontURL, remote, err := checkShieldMatrixRemote(ctx, cfg, logger)
+	switch {
+	case err != nil:
+		item.Error = err.Error()
+	case cloudFrontURL == "":
+		item.Remote = local
+		item.Message = "check_update reports no updates"
+	default:
+		item.Remote = remote
+		item.UpdateAvailable = remote != local
+		if !item.UpdateAvailable && cfg.ShieldMatrixPreloadFiles && !checkShieldMatrixFilesExist(logger) {
+			item.UpdateAvailable = true
+			item.Message = fmt.Sprintf("version %s is current but preloaded files are missing", local)
+		}
+	}
+	return item
+}
@@ -0,0 +1,222 @@
+package mirror
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"kerio-mirror-go/config"
+	"kerio-mirror-go/db"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestCheck(t *testing.T) {
+	modified := time.Date(2025, 3, 14, 2, 0, 0, 0, time.UTC)
+	var srvURL string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/update.php":
+			if r.URL.Query().Get("version") == "1" {
+				fmt.Fprintf(w, "0:1.105\nfull:%s/ids1-105.tar\n", srvURL)
+			} else {
+				fmt.Fprint(w, "error:no update\n")
+			}
+		case "/geo4.csv", "/geo6.csv":
+			if r.Method != http.MethodHead {
+				t.Errorf("Expected HEAD request for %s, got %s", r.URL.Path, r.Method)
+			}
+			w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+		case "/check_update":
+			fmt.Fprintf(w, `{"available":true,"url":"%s/cf/"}`, srvURL)
+		case "/cf/version":
+			fmt.Fprint(w, "2025.03.14\n")
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	srvURL = srv.URL
+
+	dbPath := filepath.Join(t.TempDir(), "mirror.db")
+	if err := db.Init(dbPath); err != nil {
+		t.Fatalf("DB init failed: %v", err)
+	}
+	conn, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("DB open failed: %v", err)
+	}
+	defer conn.Close()
+	if err := db.UpdateIDSVersion(conn, "1", 100, "ids1-100.tar", true, time.Now()); err != nil {
+		t.Fatalf("UpdateIDSVersion failed: %v", err)
+	}
+	if err := db.UpdateIDSVersion(conn, "4", 20250301, "full-4-20250301.gz", true, modified.Add(-24*time.Hour)); err != nil {
+		t.Fatalf("UpdateIDSVersion failed: %v", err)
+	}
+	if err := db.UpdateShieldMatrixVersion(conn, "2025.03.14", true, time.Now()); err != nil {
+		t.Fatalf("UpdateShieldMatrixVersion failed: %v", err)
+	}
+
+	cfg := &config.Config{
+		DatabasePath:         dbPath,
+		LicenseNumber:        "LIC",
+		IDSURL:               srv.URL + "/update.php?lic=%s&version=%s",
+		EnableIDS1:           true,
+		EnableIDS2:           true,
+		EnableIDS4:           true,
+		GeoIP4URL:            srv.URL + "/geo4.csv",
+		GeoIP6URL:            srv.URL + "/geo6.csv",
+		EnableShieldMatrix:   true,
+		ShieldMatrixBaseURL:  srv.URL + "/check_update/",
+		ShieldMatrixClientID: "control",
+		ShieldMatrixVersion:  "9.5.0",
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	report := Check(context.Background(), cfg, logger)
+	expected := map[string]VersionCheck{
+		"IDSv1":         {Local: "100", Remote: "105", UpdateAvailable: true},
+		"IDSv2":         {Local: "0", Remote: ""},
+		"GeoIP":         {Local: "20250301", Remote: "20250314", UpdateAvailable: true},
+		"Shield Matrix": {Local: "2025.03.14", Remote: "2025.03.14"},
+	}
+	if len(report.Items) != len(expected) {
+		t.Fatalf("Expected %d items, got %+v", len(expected), report.Items)
+	}
+	for _, it := range report.Items {
+		want, ok := expected[it.Name]
+		if !ok {
+			t.Errorf("Unexpected item %s", it.Name)
+			continue
+		}
+		if it.Local != want.Local || it.Remote != want.Remote || it.UpdateAvailable != want.UpdateAvailable || it.Error != "" {
+			t.Errorf("%s: expected %+v, got %+v", it.Name, want, it)
+		}
+	}
+	if report.Pending() != 2 {
+		t.Errorf("Expected 2 pending updates, got %d", report.Pending())
+	}
+	if ver := db.GetIDSVersion(conn, "1"); ver != 100 {
+		t.Errorf("Expected check not to change IDSv1 version, got %d", ver)
+	}
+}
+
+func TestCachedCheck(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprint(w, "error:no update\n")
+	}))
+	defer srv.Close()
+
+	dbPath := filepath.Join(t.TempDir(), "mirror.db")
+	if err := db.Init(dbPath); err != nil {
+		t.Fatalf("DB init failed: %v", err)
+	}
+	cfg := &config.Config{
+		DatabasePath:  dbPath,
+		LicenseNumber: "LIC",
+		IDSURL:        srv.URL + "/update.php?lic=%s&version=%s",
+		EnableIDS1:    true,
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	resetCheck()
+	t.Cleanup(resetCheck)
+
+	first := CachedCheck(context.Background(), cfg, logger)
+	second := CachedCheck(context.Background(), cfg, logger)
+	if requests != 1 || !second.CheckedAt.Equal(first.CheckedAt) {
+		t.Errorf("Expected the second check to reuse the first, got %d upstream requests", requests)
+	}
+
+	// После запуска обновления проверка выполняется заново
+	resetCheck()
+	CachedCheck(context.Background(), cfg, logger)
+	if requests != 2 {
+		t.Errorf("Expected a new upstream request after reset, got %d", requests)
+	}
+}
+
+func TestCachedCheck_Concurrent(t *testing.T) {
+	var requests atomic.Int32
+	arrived := make(chan struct{}, 1)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		arrived <- struct{}{}
+		<-release
+		fmt.Fprint(w, "error:no update\n")
+	}))
+	defer srv.Close()
+
+	dbPath := filepath.Join(t.TempDir(), "mirror.db")
+	if err := db.Init(dbPath); err != nil {
+		t.Fatalf("DB init failed: %v", err)
+	}
+	cfg := &config.Config{
+		DatabasePath:  dbPath,
+		LicenseNumber: "LIC",
+		IDSURL:        srv.URL + "/update.php?lic=%s&version=%s",
+		EnableIDS1:    true,
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	resetCheck()
+	t.Cleanup(resetCheck)
+	check := func(wg *sync.WaitGroup) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			CachedCheck(context.Background(), cfg, logger)
+		}()
+	}
+
+	// Второй вызов ждёт идущую проверку
+	var wg sync.WaitGroup
+	check(&wg)
+	<-arrived
+	check(&wg)
+	time.Sleep(20 * time.Millisecond)
+	release <- struct{}{}
+	wg.Wait()
+	if n := requests.Load(); n != 1 {
+		t.Errorf("Expected concurrent checks to share 1 upstream request, got %d", n)
+	}
+
+	// Медленный upstream не держит блокировку: сброс после обновления не ждёт проверку
+	resetCheck()
+	check(&wg)
+	<-arrived
+	reset := make(chan struct{})
+	go func() {
+		resetCheck()
+		close(reset)
+	}()
+	select {
+	case <-reset:
+	case <-time.After(time.Second):
+		t.Error("Expected resetCheck not to wait for the running check")
+	}
+	release <- struct{}{}
+	wg.Wait()
+
+	// Проверка, начатая до сброса, не запоминается
+	check(&wg)
+	<-arrived
+	release <- struct{}{}
+	wg.Wait()
+	if n := requests.Load(); n != 3 {
+		t.Errorf("Expected a new upstream request after reset, got %d in total", n)
+	}
+}
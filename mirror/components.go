@@ -0,0 +1,155 @@
+package mirror
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"kerio-mirror-go/config"
+	"kerio-mirror-go/db"
+	"kerio-mirror-go/utils"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Component names used by the scheduler and stored in the DB
+const (
+	ComponentIDS          = "ids"
+	ComponentGeoIP        = "geoip"
+	ComponentWebFilter    = "webfilter"
+	ComponentBitdefender  = "bitdefender"
+	ComponentShieldMatrix = "shieldmatrix"
+	ComponentCustom       = "custom"
+)
+
+// ComponentNames lists all registered components in the order Update runs them
+func ComponentNames() []string {
+	var names []string
+	for _, u := range Updaters() {
+		names = append(names, u.Name())
+	}
+	return names
+}
+
+// ComponentTitle returns a human readable name of the component
+func ComponentTitle(name string) string {
+	if u, ok := LookupUpdater(name); ok {
+		return u.Title()
+	}
+	return name
+}
+
+// ComponentSchedule returns the schedule spec of the component, falling back to cfg.ScheduleTime
+func ComponentSchedule(cfg *config.Config, name string) string {
+	var spec string
+	if u, ok := LookupUpdater(name); ok {
+		spec = u.Schedule(cfg)
+	}
+	if spec == "" {
+		return cfg.ScheduleTime
+	}
+	return spec
+}
+
+// ComponentEnabled reports whether the component has anything to do with the current config
+func ComponentEnabled(cfg *config.Config, name string) bool {
+	u, ok := LookupUpdater(name)
+	return ok && u.Enabled(cfg)
+}
+
+// componentVersion returns the published version of the component as stored in the DB.
+// Components without a version (Web Filter, custom files) return an empty string.
+func componentVersion(conn *sql.DB, cfg *config.Config, name string) string {
+	if u, ok := LookupUpdater(name); ok {
+		return u.Status(conn, cfg).Version
+	}
+	return ""
+}
+
+// runComponent runs the updater of a single component and returns its result
+// with download stats and timing filled in. An aborted component is not recorded as run.
+func runComponent(ctx context.Context, conn *sql.DB, cfg *config.Config, logger *logrus.Logger, name string) ComponentResult {
+	start := time.Now()
+	ctx, stats := utils.WithDownloadStats(ctx)
+	ctx = UpstreamContext(ctx, cfg, name)
+	ctx = progress.startComponent(ctx, name, stats)
+
+	u, ok := LookupUpdater(name)
+	if !ok {
+		logger.Warnf("Unknown component: %s", name)
+		res := newResult(name).failed(fmt.Errorf("unknown component: %s", name))
+		res.StartedAt = start
+		progress.finishComponent(name, res.Status)
+		return *res
+	}
+	res := u.Apply(ctx, conn, cfg, logger)
+	resetUsage(name)
+
+	res.finish(ctx)
+	res.StartedAt = start
+	res.Bytes = stats.Bytes()
+	res.Files = stats.Files()
+	res.DurationMs = time.Since(start).Milliseconds()
+	if ctx.Err() != nil {
+		logger.Warnf("%s: aborted", res.Title)
+		res.Status = StatusAborted
+		progress.finishComponent(name, res.Status)
+		return *res
+	}
+	if res.Status != StatusSkipped {
+		if err := db.SetComponentLastRun(conn, name, time.Now()); err != nil {
+			logger.Errorf("Failed to save last run time for %s: %v", name, err)
+		}
+	}
+	progress.finishComponent(name, res.Status)
+	return *res
+}
+
+// ComponentScheduleInfo describes the schedule state of a single component
+type ComponentScheduleInfo struct {
+	Name     string       `json:"name"`
+	Title    string       `json:"title"`
+	Schedule string       `json:"schedule"`
+	Enabled  bool         `json:"enabled"`
+	LastRun  string       `json:"last_run"`
+	NextRun  string       `json:"next_run"`
+	Error    string       `json:"error,omitempty"`
+	Upstream UpstreamInfo `json:"upstream"`
+}
+
+// ComponentSchedules returns schedule info for all components.
+// LastRun comes from the DB, NextRun is computed from the current config
+// and moved to the end of a blackout window it falls into.
+func ComponentSchedules(conn *sql.DB, cfg *config.Config) []ComponentScheduleInfo {
+	now := time.Now()
+	var infos []ComponentScheduleInfo
+	for _, name := range ComponentNames() {
+		info := ComponentScheduleInfo{
+			Name:     name,
+			Title:    ComponentTitle(name),
+			Schedule: ComponentSchedule(cfg, name),
+			Enabled:  ComponentEnabled(cfg, name),
+			LastRun:  "-",
+			NextRun:  "-",
+			Upstream: ComponentUpstream(name),
+		}
+		if conn != nil {
+			if lastRun, _, err := db.GetComponentSchedule(conn, name); err == nil && lastRun != "" {
+				info.LastRun = lastRun
+			}
+		}
+		sched, err := ParseSchedule(info.Schedule)
+		if err != nil {
+			info.Error = err.Error()
+		} else if info.Enabled {
+			next := sched.Next(now)
+			if end := BlackoutUntil(cfg, next); !end.IsZero() {
+				next = end
+			}
+			info.NextRun = next.Format(time.RFC3339)
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
@@ -0,0 +1,46 @@
+package mirror
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"kerio-mirror-go/config"
+	"kerio-mirror-go/db"
+	"kerio-mirror-go/utils"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fetchIfChanged downloads url to destPath with a conditional GET based on the validators stored
+// for url. Conditional headers are only sent when published is true, i.e. the result of the previous
+// download is still in place; otherwise the file is fetched unconditionally.
+// It returns changed=false if upstream answered 304, destPath is not touched then.
+// The returned validators must be stored with saveValidators once the new data is published.
+func fetchIfChanged(ctx context.Context, conn *sql.DB, cfg *config.Config, logger *logrus.Logger, url, destPath string, published bool, want utils.Expect) (utils.Validators, bool, error) {
+	var prev utils.Validators
+	if published {
+		etag, lastModified, err := db.GetHTTPValidators(conn, url)
+		if err != nil {
+			logger.Warnf("Failed to load validators for %s: %v", url, err)
+		}
+		prev = utils.Validators{ETag: etag, LastModified: lastModified}
+	}
+	got, err := utils.DownloadFileConditional(ctx, url, destPath, cfg.ProxyURL, cfg.RetryCount, time.Duration(cfg.RetryDelaySeconds)*time.Second, want, prev)
+	if errors.Is(err, utils.ErrNotModified) {
+		logger.Infof("Not modified upstream: %s", url)
+		return prev, false, nil
+	}
+	if err != nil {
+		return utils.Validators{}, false, err
+	}
+	return got, true, nil
+}
+
+// saveValidators stores the validators of a published download for the next conditional request
+func saveValidators(conn *sql.DB, logger *logrus.Logger, url string, v utils.Validators) {
+	if err := db.SetHTTPValidators(conn, url, v.ETag, v.LastModified, time.Now()); err != nil {
+		logger.Warnf("Failed to save validators for %s: %v", url, err)
+	}
+}
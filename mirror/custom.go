@@ -1,18 +1,32 @@
 package mirror
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
 	"kerio-mirror-go/config"
 	"kerio-mirror-go/utils"
+	"os"
 	"strings"
-	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
-// DownloadCustomFiles скачивает все файлы из CustomDownloadURLs, сохраняя относительный путь
-func DownloadCustomFiles(cfg *config.Config, logger *logrus.Logger) {
+// DownloadCustomFiles скачивает все файлы из CustomDownloadURLs, сохраняя относительный путь.
+// Уже скачанные файлы запрашиваются условно (ETag / If-Modified-Since), при 304 файл не перезаписывается.
+// Файлы, которые не удалось скачать, попадают в ошибки результата.
+func DownloadCustomFiles(ctx context.Context, conn *sql.DB, cfg *config.Config, logger *logrus.Logger) *ComponentResult {
+	res := newResult(ComponentCustom)
+	if len(cfg.CustomDownloadURLs) == 0 {
+		return res.skip("no custom URLs configured")
+	}
 	customDir := "mirror/custom"
+	var failed []string
 	for _, url := range cfg.CustomDownloadURLs {
+		if ctx.Err() != nil {
+			logger.Warn("Custom files download aborted")
+			return res.abort()
+		}
 		if url == "" {
 			continue
 		}
@@ -22,13 +36,22 @@ func DownloadCustomFiles(cfg *config.Config, logger *logrus.Logger) {
 			continue
 		}
 		destPath := customDir + "/" + relPath
-		ok := utils.DownloadFileWithProxy(url, destPath, cfg.ProxyURL, cfg.RetryCount, time.Duration(cfg.RetryDelaySeconds)*time.Second, logger)
-		if ok {
+		_, statErr := os.Stat(destPath)
+		validators, changed, err := fetchIfChanged(ctx, conn, cfg, logger, url, destPath, statErr == nil, utils.Expect{})
+		switch {
+		case err != nil:
+			logger.Warnf("Failed to download custom file %s: %v", url, err)
+			failed = append(failed, url)
+		case changed:
 			logger.Infof("Downloaded custom file: %s", destPath)
-		} else {
-			logger.Warnf("Failed to download custom file: %s", url)
+			saveValidators(conn, logger, url, validators)
+			res.changed("custom/" + relPath)
 		}
 	}
+	if len(failed) > 0 {
+		return res.failed(fmt.Errorf("failed to download %d custom file(s): %s", len(failed), strings.Join(failed, ", ")))
+	}
+	return res.finish(ctx)
 }
 
 // getRelativePathFromURL extracts the relative path from a URL (without scheme and host)
@@ -45,3 +68,26 @@ func getRelativePathFromURL(urlStr string) string {
 	}
 	return ""
 }
+
+// customUpdater downloads the files listed in CustomDownloadURLs
+type customUpdater struct{}
+
+func (customUpdater) Name() string  { return ComponentCustom }
+func (customUpdater) Title() string { return "Custom files" }
+
+func (customUpdater) Schedule(cfg *config.Config) string { return cfg.CustomSchedule }
+
+func (customUpdater) Enabled(cfg *config.Config) bool { return len(cfg.CustomDownloadURLs) > 0 }
+
+// Check returns nil: custom files have no version to compare
+func (customUpdater) Check(ctx context.Context, conn *sql.DB, cfg *config.Config, logger *logrus.Logger) []VersionCheck {
+	return nil
+}
+
+func (customUpdater) Apply(ctx context.Context, conn *sql.DB, cfg *config.Config, logger *logrus.Logger) *ComponentResult {
+	return DownloadCustomFiles(ctx, conn, cfg, logger)
+}
+
+func (customUpdater) Status(conn *sql.DB, cfg *config.Config) ComponentStatus {
+	return lastSuccessStatus(conn, ComponentCustom)
+}
@@ -0,0 +1,70 @@
+package mirror
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"kerio-mirror-go/utils"
+)
+
+// ParseFailover parses UPSTREAM_FAILOVER, one component per item:
+// "bitdefender: https://upgrade.bitdefender.com, https://mirror2.example.com/bd".
+// Base URLs are listed in order of preference, the primary one first.
+func ParseFailover(items []string) (map[string][]string, error) {
+	lists := map[string][]string{}
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(item, ":")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !ok || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("invalid failover list %q, expected component: url, url", item)
+		}
+		if _, ok := LookupUpdater(name); !ok {
+			return nil, fmt.Errorf("unknown component %q", name)
+		}
+		if _, dup := lists[name]; dup {
+			return nil, fmt.Errorf("duplicate failover list for %s", name)
+		}
+		var bases []string
+		for _, base := range strings.Split(value, ",") {
+			base = strings.TrimSuffix(strings.TrimSpace(base), "/")
+			if base == "" {
+				continue
+			}
+			u, err := url.Parse(base)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" {
+				return nil, fmt.Errorf("%s: invalid base URL %q", name, base)
+			}
+			bases = append(bases, base)
+		}
+		if len(bases) == 0 {
+			return nil, fmt.Errorf("%s: no base URLs", name)
+		}
+		lists[name] = bases
+	}
+	return lists, nil
+}
+
+// UpstreamInfo is the upstream a component currently uses
+type UpstreamInfo struct {
+	Active    string                 `json:"active,omitempty"`    // база последнего успешного запроса
+	Failover  bool                   `json:"failover,omitempty"`  // активен не первый upstream списка
+	Upstreams []utils.UpstreamHealth `json:"upstreams,omitempty"` // список failover со здоровьем
+}
+
+// ComponentUpstream returns the active upstream of a component and the health of its failover list
+func ComponentUpstream(name string) UpstreamInfo {
+	active, list := utils.UpstreamStatus(name)
+	info := UpstreamInfo{Active: active, Upstreams: list}
+	if len(list) > 0 {
+		if active == "" {
+			info.Active = list[0].Base
+		}
+		info.Failover = info.Active != list[0].Base
+	}
+	return info
+}
@@ -0,0 +1,41 @@
+package mirror
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFailover(t *testing.T) {
+	tests := []struct {
+		items    []string
+		expected map[string][]string
+		ok       bool
+	}{
+		{nil, map[string][]string{}, true},
+		{[]string{"", "  "}, map[string][]string{}, true},
+		{
+			[]string{"Bitdefender: https://upgrade.bitdefender.com/, http://10.0.0.5:8080/bd", "ids: http://download.kerio.com"},
+			map[string][]string{
+				"bitdefender": {"https://upgrade.bitdefender.com", "http://10.0.0.5:8080/bd"},
+				"ids":         {"http://download.kerio.com"},
+			},
+			true,
+		},
+		{[]string{"https://upgrade.bitdefender.com"}, nil, false},
+		{[]string{"unknown: http://a.test"}, nil, false},
+		{[]string{"ids:"}, nil, false},
+		{[]string{"ids: ftp://a.test"}, nil, false},
+		{[]string{"ids: http://a.test?x=1"}, nil, false},
+		{[]string{"ids: http://a.test", "ids: http://b.test"}, nil, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseFailover(tt.items)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParseFailover(%q): expected ok=%v, got error %v", tt.items, tt.ok, err)
+			continue
+		}
+		if tt.ok && !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("ParseFailover(%q): expected %v, got %v", tt.items, tt.expected, got)
+		}
+	}
+}
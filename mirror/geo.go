@@ -3,14 +3,15 @@ package mirror
 import (
 	"bufio"
 	"compress/gzip"
+	"context"
 	"database/sql"
 	"encoding/csv"
 	"errors"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -20,87 +21,110 @@ import (
 	"kerio-mirror-go/utils"
 )
 
+// GeoDownload is the result of DownloadAndProcessGeo
+type GeoDownload struct {
+	Path       string           // опубликованный файл
+	Changed    bool             // false - upstream ответил 304, файл не изменялся
+	Validators utils.Validators // сохранить через saveValidators после публикации
+}
+
 // DownloadAndProcessGeo downloads a CSV file, processes its content, and saves the result.
-func DownloadAndProcessGeo(url, outputFilename string, modify bool, logger func(string, ...any)) (string, error) {
+// The download resumes after a dropped connection (utils.DownloadFile), so the raw file is kept
+// next to the output until it is processed. If published is true and the output file exists,
+// a conditional GET is made and a 304 keeps the output as is without processing it again.
+//
+// GeoIP goes through the upstream client like every other component instead of http.DefaultClient:
+// resuming is a repeated request, so RETRY_COUNT applies, and PROXY_URL, which GeoIP used to bypass,
+// applies as well.
+func DownloadAndProcessGeo(ctx context.Context, conn *sql.DB, cfg *config.Config, url, outputFilename string, modify, published bool, logger *logrus.Logger) (GeoDownload, error) {
 	saveDir := "mirror/geo"
 	if err := os.MkdirAll(saveDir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create directory: %w", err)
+		return GeoDownload{}, fmt.Errorf("failed to create directory: %w", err)
 	}
 	outputPath := filepath.Join(saveDir, outputFilename)
-
-	logger("Downloading file: %s", url)
-	resp, err := http.Get(url)
-	if err != nil {
-		return "", fmt.Errorf("error downloading: %w", err)
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("bad status: %d", resp.StatusCode)
+	if _, err := os.Stat(outputPath); err != nil {
+		published = false
 	}
 
-	if modify {
-		// Process data in memory without creating a temporary file
-		reader := csv.NewReader(resp.Body)
-		header, err := reader.Read()
+	logger.Infof("Downloading file: %s", url)
+	if !modify {
+		// Файл публикуется как есть, DownloadFile сам заменяет его только после полной загрузки
+		v, changed, err := fetchIfChanged(ctx, conn, cfg, logger, url, outputPath, published, utils.Expect{})
 		if err != nil {
-			return "", fmt.Errorf("error reading header: %w", err)
+			return GeoDownload{}, fmt.Errorf("error downloading: %w", err)
 		}
-
-		var rows [][]string
-		for {
-			row, err := reader.Read()
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				return "", fmt.Errorf("error reading row: %w", err)
-			}
-			if len(row) >= 3 {
-				if row[1] != "" {
-					row[2] = row[1]
-				} else if row[2] != "" {
-					row[1] = row[2]
-				}
-			}
-			rows = append(rows, row)
+		if changed {
+			logger.Infof("File downloaded and saved at %s", outputPath)
 		}
+		return GeoDownload{Path: outputPath, Changed: changed, Validators: v}, nil
+	}
 
-		// Write processed data to the output file
-		f, err := os.Create(outputPath)
-		if err != nil {
-			return "", fmt.Errorf("error creating output file: %w", err)
-		}
-		defer f.Close()
+	// Сырой файл нужен только до обработки, остаток прерванного запуска убирает CleanupTemp
+	rawPath := outputPath + ".download"
+	defer os.Remove(rawPath)
+	v, changed, err := fetchIfChanged(ctx, conn, cfg, logger, url, rawPath, published, utils.Expect{})
+	if err != nil {
+		return GeoDownload{}, fmt.Errorf("error downloading: %w", err)
+	}
+	if !changed {
+		return GeoDownload{Path: outputPath, Validators: v}, nil
+	}
+	raw, err := os.Open(rawPath)
+	if err != nil {
+		return GeoDownload{}, fmt.Errorf("error opening downloaded file: %w", err)
+	}
+	defer raw.Close()
 
-		w := csv.NewWriter(f)
-		if err := w.Write(header); err != nil {
-			return "", fmt.Errorf("error writing header: %w", err)
-		}
-		if err := w.WriteAll(rows); err != nil {
-			return "", fmt.Errorf("error writing rows: %w", err)
-		}
-		w.Flush()
-		if err := w.Error(); err != nil {
-			return "", fmt.Errorf("error flushing writer: %w", err)
+	// Process data in memory
+	reader := csv.NewReader(raw)
+	header, err := reader.Read()
+	if err != nil {
+		return GeoDownload{}, fmt.Errorf("error reading header: %w", err)
+	}
+
+	var rows [][]string
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
 		}
-	} else {
-		// Save file without modifications using streaming
-		f, err := os.Create(outputPath)
 		if err != nil {
-			return "", fmt.Errorf("error creating output file: %w", err)
+			return GeoDownload{}, fmt.Errorf("error reading row: %w", err)
 		}
-		defer f.Close()
-
-		// Use buffered copy for better performance
-		buf := make([]byte, 32*1024) // 32KB buffer
-		_, err = io.CopyBuffer(f, resp.Body, buf)
-		if err != nil {
-			return "", fmt.Errorf("error copying data: %w", err)
+		if len(row) >= 3 {
+			if row[1] != "" {
+				row[2] = row[1]
+			} else if row[2] != "" {
+				row[1] = row[2]
+			}
 		}
+		rows = append(rows, row)
+	}
+
+	// Write processed data to the output file
+	f, err := utils.CreateAtomic(outputPath)
+	if err != nil {
+		return GeoDownload{}, fmt.Errorf("error creating output file: %w", err)
 	}
+	defer f.Abort()
 
-	logger("File downloaded, processed and saved at %s", outputPath)
-	return outputPath, nil
+	w := csv.NewWriter(f)
+	if err := w.Write(header); err != nil {
+		return GeoDownload{}, fmt.Errorf("error writing header: %w", err)
+	}
+	if err := w.WriteAll(rows); err != nil {
+		return GeoDownload{}, fmt.Errorf("error writing rows: %w", err)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return GeoDownload{}, fmt.Errorf("error flushing writer: %w", err)
+	}
+	if err := f.Commit(utils.Expect{}); err != nil {
+		return GeoDownload{}, fmt.Errorf("error saving output file: %w", err)
+	}
+
+	logger.Infof("File downloaded, processed and saved at %s", outputPath)
+	return GeoDownload{Path: outputPath, Changed: true, Validators: v}, nil
 }
 
 // CombineAndCompressGeoFiles combines two geo CSVs, extracts first two columns, and gzips the result.
@@ -112,11 +136,12 @@ func CombineAndCompressGeoFiles(v4Filename, v6Filename string, logger func(strin
 	outputGzPath := filepath.Join(saveDir, fmt.Sprintf("full-4-%s.gz", fileVersion))
 
 	try := func() error {
-		gzf, err := os.Create(outputGzPath)
+		// Архив за сегодня может уже раздаваться клиентам, поэтому пишем во временный файл
+		gzf, err := utils.CreateAtomic(outputGzPath)
 		if err != nil {
 			return err
 		}
-		defer gzf.Close()
+		defer gzf.Abort()
 		gw := gzip.NewWriter(gzf)
 		w := csv.NewWriter(gw)
 		for _, path := range []string{v4Path, v6Path} {
@@ -149,8 +174,13 @@ func CombineAndCompressGeoFiles(v4Filename, v6Filename string, logger func(strin
 			f.Close()
 		}
 		w.Flush()
-		gw.Close()
-		return nil
+		if err := w.Error(); err != nil {
+			return err
+		}
+		if err := gw.Close(); err != nil {
+			return err
+		}
+		return gzf.Commit(utils.Expect{})
 	}
 
 	maxAttempts := 5
@@ -176,37 +206,114 @@ func CombineAndCompressGeoFiles(v4Filename, v6Filename string, logger func(strin
 	return "", lastErr
 }
 
-// UpdateGeoIPDatabases handles downloading, processing, combining, and DB update for GeoIP databases.
-func UpdateGeoIPDatabases(conn *sql.DB, cfg *config.Config, logger *logrus.Logger) {
-	v4Path, err := DownloadAndProcessGeo(cfg.GeoIP4URL, "v4.csv", true, logger.Infof)
-	if err != nil {
-		logger.Errorf("GeoIP4 download error: %v", err)
-	}
-	v6Path, err := DownloadAndProcessGeo(cfg.GeoIP6URL, "v6.csv", true, logger.Infof)
-	if err != nil {
-		logger.Errorf("GeoIP6 download error: %v", err)
+// UpdateGeoIPDatabases handles downloading, processing, combining, and DB update for GeoIP databases
+// (published to clients as IDSv4) and downloads the locations file if configured.
+func UpdateGeoIPDatabases(ctx context.Context, conn *sql.DB, cfg *config.Config, logger *logrus.Logger) *ComponentResult {
+	res := newResult(ComponentGeoIP)
+	if !cfg.EnableIDS4 {
+		logger.Info("IDSv4 (GeoIP): update is disabled by config")
+		return res.skip("disabled by config")
 	}
-	if v4Path != "" && v6Path != "" {
-		outputPath, err := CombineAndCompressGeoFiles("v4.csv", "v6.csv", logger.Infof)
+	res.OldVersion = componentVersion(conn, cfg, ComponentGeoIP)
+	res.NewVersion = res.OldVersion
+
+	if cfg.GeoIP4URL == "" || cfg.GeoIP6URL == "" {
+		logger.Infof("IDSv4 (GeoIP): URLs are not configured")
+	} else {
+		// Без опубликованной версии 304 не спасает: архив надо собрать заново
+		published := db.GetIDSVersion(conn, "4") != 0
+		v4, err := DownloadAndProcessGeo(ctx, conn, cfg, cfg.GeoIP4URL, "v4.csv", true, published, logger)
+		if err != nil {
+			res.failf(logger, "GeoIP4 download: %w", err)
+		}
+		v6, err := DownloadAndProcessGeo(ctx, conn, cfg, cfg.GeoIP6URL, "v6.csv", true, published, logger)
 		if err != nil {
-			logger.Errorf("GeoIP combine error: %v", err)
-		} else if outputPath != "" {
-			fileVersion := time.Now().Format("20060102")
-			version := utils.AtoiSafe(fileVersion)
-			filename := filepath.Base(outputPath)
-			if updateErr := db.UpdateIDSVersion(conn, "4", version, filename, true, time.Now()); updateErr != nil {
-				logger.Errorf("Failed to update GeoIP version in DB: %v", updateErr)
-			} else {
-				logger.Infof("GeoIP update complete, version 4.%s", fileVersion)
+			res.failf(logger, "GeoIP6 download: %w", err)
+		}
+		// Отмена до сборки архива оставляет опубликованную версию GeoIP нетронутой
+		if ctx.Err() != nil {
+			logger.Warn("GeoIP update aborted, keeping published version")
+			return res.abort()
+		}
+		if v4.Path != "" && v6.Path != "" && !v4.Changed && !v6.Changed {
+			logger.Infof("GeoIP not modified upstream, keeping version %s", res.OldVersion)
+		} else if v4.Path != "" && v6.Path != "" {
+			outputPath, err := CombineAndCompressGeoFiles("v4.csv", "v6.csv", logger.Infof)
+			if err != nil {
+				res.failf(logger, "GeoIP combine: %w", err)
+			} else if outputPath != "" {
+				fileVersion := time.Now().Format("20060102")
+				version := utils.AtoiSafe(fileVersion)
+				filename := filepath.Base(outputPath)
+				if updateErr := db.UpdateIDSVersion(conn, "4", version, filename, true, time.Now()); updateErr != nil {
+					res.failf(logger, "GeoIP DB update: %w", updateErr)
+				} else {
+					logger.Infof("GeoIP update complete, version 4.%s", fileVersion)
+					res.NewVersion = strconv.Itoa(version)
+					res.changed("geo/" + filename)
+					// Валидаторы сохраняются только после публикации архива, иначе 304 скроет неудачную сборку
+					saveValidators(conn, logger, cfg.GeoIP4URL, v4.Validators)
+					saveValidators(conn, logger, cfg.GeoIP6URL, v6.Validators)
+				}
 			}
 		}
 	}
+
+	if cfg.GeoLocURL != "" {
+		if changed, err := DownloadGeoLocations(ctx, conn, cfg, logger); err != nil {
+			res.failed(err)
+		} else if changed {
+			res.changed("geo/locations.csv")
+		}
+	}
+	return res.finish(ctx)
 }
 
-// DownloadGeoLocations downloads and processes the locations file if configured.
-func DownloadGeoLocations(cfg *config.Config, logger *logrus.Logger) {
-	_, err := DownloadAndProcessGeo(cfg.GeoLocURL, "locations.csv", false, logger.Infof)
+// DownloadGeoLocations downloads the locations file if configured.
+// It returns false if the file was not modified upstream.
+func DownloadGeoLocations(ctx context.Context, conn *sql.DB, cfg *config.Config, logger *logrus.Logger) (bool, error) {
+	loc, err := DownloadAndProcessGeo(ctx, conn, cfg, cfg.GeoLocURL, "locations.csv", false, true, logger)
 	if err != nil {
 		logger.Errorf("GeoLoc download error: %v", err)
+		return false, fmt.Errorf("GeoLoc download: %w", err)
+	}
+	if loc.Changed {
+		saveValidators(conn, logger, cfg.GeoLocURL, loc.Validators)
+	}
+	return loc.Changed, nil
+}
+
+// geoIPUpdater publishes the GeoIP database as IDSv4
+type geoIPUpdater struct{}
+
+func (geoIPUpdater) Name() string  { return ComponentGeoIP }
+func (geoIPUpdater) Title() string { return "GeoIP" }
+
+func (geoIPUpdater) Schedule(cfg *config.Config) string { return cfg.GeoIPSchedule }
+
+func (geoIPUpdater) Enabled(cfg *config.Config) bool { return cfg.EnableIDS4 }
+
+func (geoIPUpdater) Check(ctx context.Context, conn *sql.DB, cfg *config.Config, logger *logrus.Logger) []VersionCheck {
+	if cfg.GeoIP4URL == "" || cfg.GeoIP6URL == "" {
+		return nil
+	}
+	return []VersionCheck{checkGeoIP(ctx, conn, cfg)}
+}
+
+func (geoIPUpdater) Apply(ctx context.Context, conn *sql.DB, cfg *config.Config, logger *logrus.Logger) *ComponentResult {
+	return UpdateGeoIPDatabases(ctx, conn, cfg, logger)
+}
+
+func (geoIPUpdater) Status(conn *sql.DB, cfg *config.Config) ComponentStatus {
+	var st ComponentStatus
+	ver := db.GetIDSVersion(conn, "4")
+	if ver > 0 {
+		st.Version = strconv.Itoa(ver)
+	}
+	success, at, err := db.GetIDSUpdateStatus(conn, "4")
+	if err == nil {
+		st.LastSuccess, _ = db.ParseTime(at)
 	}
+	st.Databases = []DatabaseStatus{{Name: "GeoIP", Version: strconv.Itoa(ver), Success: success}}
+	return st
 }
@@ -0,0 +1,64 @@
+package mirror
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"kerio-mirror-go/config"
+	"kerio-mirror-go/db"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Statuses of runs and components stored in the history
+const (
+	StatusRunning   = "running"
+	StatusSuccess   = "success"
+	StatusUnchanged = "unchanged" // компонент отработал, новых данных нет
+	StatusFailed    = "failed"
+	StatusSkipped   = "skipped"
+	StatusAborted   = "aborted"
+)
+
+// recordRunStart stores a new run with status running
+func recordRunStart(cfg *config.Config, info RunInfo, logger *logrus.Logger) {
+	conn, err := sql.Open("sqlite", cfg.DatabasePath)
+	if err != nil {
+		logger.Errorf("DB open error: %v", err)
+		return
+	}
+	defer conn.Close()
+	if err := db.InsertRun(conn, info.ID, info.Trigger, strings.Join(info.Components, ","), *info.StartedAt); err != nil {
+		logger.Errorf("Failed to save run %s: %v", info.ID, err)
+	}
+}
+
+// recordRunFinish stores the outcome of the run with its component results
+// and removes runs older than cfg.HistoryRetentionDays.
+func recordRunFinish(cfg *config.Config, info RunInfo, report RunReport, logger *logrus.Logger) {
+	conn, err := sql.Open("sqlite", cfg.DatabasePath)
+	if err != nil {
+		logger.Errorf("DB open error: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	for _, c := range report.Components {
+		if err := db.InsertRunComponent(conn, c.record(info.ID)); err != nil {
+			logger.Errorf("Failed to save %s result of run %s: %v", c.Component, info.ID, err)
+		}
+	}
+	if err := db.FinishRun(conn, info.ID, info.Status, info.Error, *info.FinishedAt); err != nil {
+		logger.Errorf("Failed to save run %s: %v", info.ID, err)
+	}
+
+	if cfg.HistoryRetentionDays > 0 {
+		cutoff := time.Now().AddDate(0, 0, -cfg.HistoryRetentionDays)
+		if n, err := db.DeleteRunsBefore(conn, cutoff); err != nil {
+			logger.Errorf("Failed to clean up run history: %v", err)
+		} else if n > 0 {
+			logger.Infof("Removed %d run(s) older than %d days from history", n, cfg.HistoryRetentionDays)
+		}
+	}
+}
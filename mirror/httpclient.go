@@ -0,0 +1,349 @@
+package mirror
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"kerio-mirror-go/config"
+	"kerio-mirror-go/utils"
+)
+
+// ParseHeaders parses HTTP_HEADERS: one "Name: value" per item
+func ParseHeaders(items []string) (map[string]string, error) {
+	headers := map[string]string{}
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, value, err := parseHeader(item)
+		if err != nil {
+			return nil, err
+		}
+		headers[name] = value
+	}
+	return headers, nil
+}
+
+func parseHeader(s string) (string, string, error) {
+	name, value, ok := strings.Cut(s, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" || strings.ContainsAny(name, " \t") {
+		return "", "", fmt.Errorf("invalid header %q, expected Name: value", s)
+	}
+	return name, strings.TrimSpace(value), nil
+}
+
+// ParseHostSettings parses HTTP_HOST_SETTINGS, one host per item:
+// "upgrade.bitdefender.com; timeout=10m; conns=4; user-agent=...; header=Name: value".
+// The host may be "*.domain" for all its subdomains, timeout is a duration or seconds.
+// TLS keys: "ca=file" and "pin=sha256/..." may repeat, "cert=file", "key=file", "tls-min=1.3";
+// the files are loaded to check them.
+func ParseHostSettings(items []string) (map[string]utils.HostConfig, error) {
+	hosts := map[string]utils.HostConfig{}
+	for _, item := range items {
+		fields := strings.Split(item, ";")
+		host := strings.ToLower(strings.TrimSpace(fields[0]))
+		if host == "" {
+			if strings.TrimSpace(item) == "" {
+				continue
+			}
+			return nil, fmt.Errorf("missing host in %q", item)
+		}
+		if strings.ContainsAny(host, "/: ") {
+			return nil, fmt.Errorf("invalid host %q, expected a host name without scheme and port", host)
+		}
+		var h utils.HostConfig
+		for _, field := range fields[1:] {
+			field = strings.TrimSpace(field)
+			if field == "" {
+				continue
+			}
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				return nil, fmt.Errorf("%s: invalid setting %q, expected key=value", host, field)
+			}
+			value = strings.TrimSpace(value)
+			switch strings.ToLower(strings.TrimSpace(key)) {
+			case "timeout":
+				d, err := parseSeconds(value)
+				if err != nil || d <= 0 {
+					return nil, fmt.Errorf("%s: invalid timeout %q", host, value)
+				}
+				h.Timeout = d
+			case "conns":
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 0 {
+					return nil, fmt.Errorf("%s: invalid conns %q", host, value)
+				}
+				h.MaxConns = n
+			case "user-agent":
+				h.UserAgent = value
+			case "header":
+				name, hv, err := parseHeader(value)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", host, err)
+				}
+				if h.Headers == nil {
+					h.Headers = map[string]string{}
+				}
+				h.Headers[name] = hv
+			case "ca":
+				h.TLS.CAFiles = append(h.TLS.CAFiles, value)
+			case "cert":
+				h.TLS.CertFile = value
+			case "key":
+				h.TLS.KeyFile = value
+			case "tls-min":
+				v, err := utils.ParseTLSVersion(value)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", host, err)
+				}
+				h.TLS.MinVersion = v
+			case "pin":
+				pin, err := utils.ParsePin(value)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", host, err)
+				}
+				h.TLS.Pins = append(h.TLS.Pins, pin)
+			default:
+				return nil, fmt.Errorf("%s: unknown setting %q", host, key)
+			}
+		}
+		if h.TLS.KeyFile != "" && h.TLS.CertFile == "" {
+			return nil, fmt.Errorf("%s: key without cert", host)
+		}
+		if _, err := h.TLS.Build(); err != nil {
+			return nil, fmt.Errorf("%s: %w", host, err)
+		}
+		hosts[host] = h
+	}
+	return hosts, nil
+}
+
+// parseSeconds accepts a duration ("90s", "10m") or a plain number of seconds
+func parseSeconds(s string) (time.Duration, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		return time.Duration(n) * time.Second, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// ParseProxyComponents parses PROXY_COMPONENTS, one component per item: "bitdefender: socks5://10.0.0.1:1080",
+// "ids: direct", "webfilter: fallback http://proxy2:3128". The value is a policy, a proxy URL or both.
+func ParseProxyComponents(items []string) (map[string]utils.ProxyRoute, error) {
+	routes := map[string]utils.ProxyRoute{}
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(item, ":")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !ok || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("invalid proxy setting %q, expected component: policy and/or proxy URL", item)
+		}
+		if _, ok := LookupUpdater(name); !ok {
+			return nil, fmt.Errorf("unknown component %q", name)
+		}
+		var r utils.ProxyRoute
+		for _, field := range strings.Fields(value) {
+			if p := strings.ToLower(field); utils.ValidProxyPolicy(p) && r.Policy == "" {
+				r.Policy = p
+				continue
+			}
+			if r.URL != "" {
+				return nil, fmt.Errorf("%s: unexpected %q", name, field)
+			}
+			if err := validateProxyURL(field); err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			r.URL = field
+		}
+		if r == (utils.ProxyRoute{}) {
+			return nil, fmt.Errorf("%s: missing policy or proxy URL", name)
+		}
+		routes[name] = r
+	}
+	return routes, nil
+}
+
+// ParseNoProxy parses NO_PROXY: comma-separated hosts, domains, IPs and subnets in CIDR notation
+func ParseNoProxy(spec string) ([]string, error) {
+	var list []string
+	for _, e := range strings.Split(spec, ",") {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if e == "" {
+			continue
+		}
+		if strings.Contains(e, "/") {
+			if _, _, err := net.ParseCIDR(e); err != nil {
+				return nil, fmt.Errorf("invalid subnet %q", e)
+			}
+		} else if net.ParseIP(e) == nil && strings.ContainsAny(e, ": ") {
+			return nil, fmt.Errorf("invalid host %q, expected a host name without scheme and port", e)
+		}
+		list = append(list, e)
+	}
+	return list, nil
+}
+
+// validateProxyURL checks that s is an HTTP, HTTPS or SOCKS5 proxy URL
+func validateProxyURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid proxy URL %q", s)
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https", "socks5", "socks5h":
+		return nil
+	}
+	return fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+}
+
+// ParseTLS builds the global TLS settings of upstream connections and loads their files to check them
+func ParseTLS(caFiles []string, certFile, keyFile, minVersion string) (utils.TLSConfig, error) {
+	var t utils.TLSConfig
+	for _, f := range caFiles {
+		if f = strings.TrimSpace(f); f != "" {
+			t.CAFiles = append(t.CAFiles, f)
+		}
+	}
+	t.CertFile, t.KeyFile = strings.TrimSpace(certFile), strings.TrimSpace(keyFile)
+	if t.KeyFile != "" && t.CertFile == "" {
+		return utils.TLSConfig{}, fmt.Errorf("client key without certificate")
+	}
+	v, err := utils.ParseTLSVersion(minVersion)
+	if err != nil {
+		return utils.TLSConfig{}, err
+	}
+	t.MinVersion = v
+	if _, err := t.Build(); err != nil {
+		return utils.TLSConfig{}, err
+	}
+	return t, nil
+}
+
+// ParseUpstreamHosts parses UPSTREAM_HOSTS, one host per item: "ids-update.kerio.com = 1.2.3.4, 2001:db8::1"
+func ParseUpstreamHosts(items []string) (map[string][]string, error) {
+	hosts := map[string][]string{}
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		host, value, ok := strings.Cut(item, "=")
+		host = strings.ToLower(strings.TrimSpace(host))
+		if !ok || host == "" || strings.ContainsAny(host, "/: ") {
+			return nil, fmt.Errorf("invalid host entry %q, expected host = IP, IP", item)
+		}
+		var ips []string
+		for _, s := range strings.Split(value, ",") {
+			s = strings.TrimSpace(s)
+			if s == "" {
+				continue
+			}
+			if net.ParseIP(s) == nil {
+				return nil, fmt.Errorf("%s: invalid IP %q", host, s)
+			}
+			ips = append(ips, s)
+		}
+		if len(ips) == 0 {
+			return nil, fmt.Errorf("%s: no IP addresses", host)
+		}
+		hosts[host] = ips
+	}
+	return hosts, nil
+}
+
+// ParseUpstreamDNS checks UPSTREAM_DNS: empty for the system resolver, a DNS server "10.0.0.53" or
+// "10.0.0.53:53", or a DoH endpoint "https://1.1.1.1/dns-query"
+func ParseUpstreamDNS(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", nil
+	}
+	if strings.Contains(s, "://") {
+		u, err := url.Parse(s)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return "", fmt.Errorf("invalid DoH URL %q", s)
+		}
+		return s, nil
+	}
+	host := s
+	if h, port, err := net.SplitHostPort(s); err == nil {
+		if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+			return "", fmt.Errorf("invalid DNS server port %q", port)
+		}
+		host = h
+	}
+	if net.ParseIP(host) == nil {
+		return "", fmt.Errorf("invalid DNS server %q, expected an IP", s)
+	}
+	return s, nil
+}
+
+// HTTPClientConfig builds the shared client settings from cfg
+func HTTPClientConfig(cfg *config.Config) (utils.ClientConfig, error) {
+	headers, err := ParseHeaders(cfg.HTTPHeaders)
+	if err != nil {
+		return utils.ClientConfig{}, err
+	}
+	hosts, err := ParseHostSettings(cfg.HTTPHostSettings)
+	if err != nil {
+		return utils.ClientConfig{}, err
+	}
+	if cfg.ProxyPolicy != "" && !utils.ValidProxyPolicy(cfg.ProxyPolicy) {
+		return utils.ClientConfig{}, fmt.Errorf("unknown proxy policy %q", cfg.ProxyPolicy)
+	}
+	proxies, err := ParseProxyComponents(cfg.ProxyComponents)
+	if err != nil {
+		return utils.ClientConfig{}, err
+	}
+	noProxy, err := ParseNoProxy(cfg.NoProxy)
+	if err != nil {
+		return utils.ClientConfig{}, err
+	}
+	tlsCfg, err := ParseTLS(cfg.TLSCAFiles, cfg.TLSClientCert, cfg.TLSClientKey, cfg.TLSMinVersion)
+	if err != nil {
+		return utils.ClientConfig{}, err
+	}
+	upstreamHosts, err := ParseUpstreamHosts(cfg.UpstreamHosts)
+	if err != nil {
+		return utils.ClientConfig{}, err
+	}
+	dns, err := ParseUpstreamDNS(cfg.UpstreamDNS)
+	if err != nil {
+		return utils.ClientConfig{}, err
+	}
+	return utils.ClientConfig{
+		Timeout:         time.Duration(cfg.HTTPTimeoutSeconds) * time.Second,
+		MaxConnsPerHost: cfg.HTTPMaxConnsPerHost,
+		UserAgent:       cfg.HTTPUserAgent,
+		Headers:         headers,
+		Hosts:           hosts,
+		Proxy:           utils.ProxyConfig{Policy: cfg.ProxyPolicy, Components: proxies, NoProxy: noProxy},
+		TLS:             tlsCfg,
+		Resolver:        utils.ResolverConfig{Hosts: upstreamHosts, DNS: dns},
+	}, nil
+}
+
+// ConfigureHTTPClient replaces the shared client with one built from cfg and sets the upstream
+// failover lists. Called at startup and after the settings are saved.
+func ConfigureHTTPClient(cfg *config.Config) error {
+	cc, err := HTTPClientConfig(cfg)
+	if err != nil {
+		return err
+	}
+	lists, err := ParseFailover(cfg.UpstreamFailover)
+	if err != nil {
+		return err
+	}
+	utils.SetSharedClient(utils.NewClient(cc))
+	utils.SetFailover(lists)
+	return nil
+}
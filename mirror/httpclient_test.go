@@ -0,0 +1,173 @@
+package mirror
+
+import (
+	"crypto/tls"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"kerio-mirror-go/utils"
+)
+
+func TestParseHostSettings(t *testing.T) {
+	hosts, err := ParseHostSettings([]string{
+		"Upgrade.Bitdefender.com; timeout=10m; conns=4; header=X-Key: a:b",
+		"*.cloudfront.net; timeout=90; user-agent=Mozilla/5.0",
+		"ids-update.kerio.com; tls-min=1.3; pin=sha256/" + strings.Repeat("A", 43) + "=",
+		"",
+	})
+	if err != nil {
+		t.Fatalf("ParseHostSettings failed: %v", err)
+	}
+	bd := hosts["upgrade.bitdefender.com"]
+	if bd.Timeout != 10*time.Minute || bd.MaxConns != 4 || bd.Headers["X-Key"] != "a:b" {
+		t.Errorf("Expected bitdefender settings, got %+v", bd)
+	}
+	cf := hosts["*.cloudfront.net"]
+	if cf.Timeout != 90*time.Second || cf.UserAgent != "Mozilla/5.0" {
+		t.Errorf("Expected cloudfront settings, got %+v", cf)
+	}
+	kerio := hosts["ids-update.kerio.com"].TLS
+	if kerio.MinVersion != tls.VersionTLS13 || len(kerio.Pins) != 1 {
+		t.Errorf("Expected kerio TLS settings, got %+v", kerio)
+	}
+
+	// Ошибки конфигурации отклоняются на странице настроек
+	invalid := []string{
+		"https://upgrade.bitdefender.com",
+		"host; timeout=0",
+		"host; conns=-1",
+		"host; retries=3",
+		"host; header=bad",
+		"; timeout=10",
+		"host; tls-min=1.4",
+		"host; pin=abc",
+		"host; ca=/nonexistent/ca.pem",
+		"host; key=/nonexistent/client.key",
+	}
+	for _, item := range invalid {
+		if _, err := ParseHostSettings([]string{item}); err == nil {
+			t.Errorf("Expected error for %q", item)
+		}
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	headers, err := ParseHeaders([]string{"X-A: 1", " Accept : */* ", ""})
+	if err != nil {
+		t.Fatalf("ParseHeaders failed: %v", err)
+	}
+	if len(headers) != 2 || headers["X-A"] != "1" || headers["Accept"] != "*/*" {
+		t.Errorf("Expected 2 headers, got %v", headers)
+	}
+	if _, err := ParseHeaders([]string{"no colon"}); err == nil {
+		t.Error("Expected error for header without colon")
+	}
+}
+
+func TestParseProxyComponents(t *testing.T) {
+	tests := []struct {
+		items    []string
+		expected map[string]utils.ProxyRoute
+		ok       bool
+	}{
+		{nil, map[string]utils.ProxyRoute{}, true},
+		{
+			[]string{"Bitdefender: socks5://10.0.0.1:1080", "ids: direct", "webfilter: fallback http://proxy2:3128", ""},
+			map[string]utils.ProxyRoute{
+				"bitdefender": {URL: "socks5://10.0.0.1:1080"},
+				"ids":         {Policy: utils.ProxyPolicyDirect},
+				"webfilter":   {URL: "http://proxy2:3128", Policy: utils.ProxyPolicyFallback},
+			},
+			true,
+		},
+		{[]string{"ids"}, nil, false},
+		{[]string{"ids:"}, nil, false},
+		{[]string{"unknown: direct"}, nil, false},
+		{[]string{"ids: ftp://proxy:21"}, nil, false},
+		{[]string{"ids: proxy3128"}, nil, false},
+		{[]string{"ids: http://a:1 http://b:2"}, nil, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseProxyComponents(tt.items)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParseProxyComponents(%q): expected ok=%v, got error %v", tt.items, tt.ok, err)
+			continue
+		}
+		if tt.ok && !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("ParseProxyComponents(%q): expected %v, got %v", tt.items, tt.expected, got)
+		}
+	}
+}
+
+func TestParseNoProxy(t *testing.T) {
+	list, err := ParseNoProxy(" Kerio.com, *.example.org,10.0.0.0/8, ::1,")
+	if err != nil {
+		t.Fatalf("ParseNoProxy failed: %v", err)
+	}
+	if !reflect.DeepEqual(list, []string{"kerio.com", "*.example.org", "10.0.0.0/8", "::1"}) {
+		t.Errorf("Expected 4 entries, got %v", list)
+	}
+	for _, spec := range []string{"10.0.0.0/33", "http://kerio.com", "kerio.com:80"} {
+		if _, err := ParseNoProxy(spec); err == nil {
+			t.Errorf("ParseNoProxy(%q): expected error", spec)
+		}
+	}
+}
+
+func TestParseTLS(t *testing.T) {
+	got, err := ParseTLS([]string{" ", ""}, "", "", "1.2")
+	if err != nil || got.MinVersion != tls.VersionTLS12 || len(got.CAFiles) != 0 {
+		t.Errorf("Expected TLS 1.2 without files, got %+v, %v", got, err)
+	}
+	if _, err := ParseTLS([]string{"/nonexistent/ca.pem"}, "", "", ""); err == nil {
+		t.Errorf("Expected error for a missing CA file")
+	}
+	if _, err := ParseTLS(nil, "", "client.key", ""); err == nil {
+		t.Errorf("Expected error for a key without certificate")
+	}
+	if _, err := ParseTLS(nil, "", "", "ssl3"); err == nil {
+		t.Errorf("Expected error for an unknown TLS version")
+	}
+}
+
+func TestParseUpstreamHosts(t *testing.T) {
+	hosts, err := ParseUpstreamHosts([]string{"IDS-Update.kerio.com = 1.2.3.4, 2001:db8::1", "", "bdupdate.kerio.com=5.6.7.8"})
+	if err != nil {
+		t.Fatalf("ParseUpstreamHosts failed: %v", err)
+	}
+	expected := map[string][]string{
+		"ids-update.kerio.com": {"1.2.3.4", "2001:db8::1"},
+		"bdupdate.kerio.com":   {"5.6.7.8"},
+	}
+	if !reflect.DeepEqual(hosts, expected) {
+		t.Errorf("Expected %v, got %v", expected, hosts)
+	}
+	for _, item := range []string{"ids-update.kerio.com", "ids-update.kerio.com =", "host = 1.2.3", "http://host = 1.2.3.4"} {
+		if _, err := ParseUpstreamHosts([]string{item}); err == nil {
+			t.Errorf("Expected error for %q", item)
+		}
+	}
+}
+
+func TestParseUpstreamDNS(t *testing.T) {
+	tests := []struct {
+		s  string
+		ok bool
+	}{
+		{"", true},
+		{"8.8.8.8", true},
+		{"10.0.0.53:5353", true},
+		{"[2001:db8::53]:53", true},
+		{"https://1.1.1.1/dns-query", true},
+		{"dns.example.com", false},
+		{"8.8.8.8:0", false},
+		{"ftp://1.1.1.1", false},
+	}
+	for _, tt := range tests {
+		if _, err := ParseUpstreamDNS(tt.s); (err == nil) != tt.ok {
+			t.Errorf("ParseUpstreamDNS(%q): expected ok=%v, got %v", tt.s, tt.ok, err)
+		}
+	}
+}
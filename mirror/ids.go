@@ -1,10 +1,14 @@
 package mirror
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"kerio-mirror-go/config"
@@ -14,93 +18,39 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-// DownloadAndUpdateIDS implements the python logic for IDS update discovery and download
-func DownloadAndUpdateIDS(conn *sql.DB, cfg *config.Config, logger *logrus.Logger) {
+// DownloadAndUpdateIDS implements the python logic for IDS update discovery and download.
+// IDSv4 (GeoIP) is handled separately by UpdateGeoIPDatabases.
+// Errors of all IDS versions are collected in the result.
+func DownloadAndUpdateIDS(ctx context.Context, conn *sql.DB, cfg *config.Config, logger *logrus.Logger) *ComponentResult {
+	res := newResult(ComponentIDS)
+	res.OldVersion = componentVersion(conn, cfg, ComponentIDS)
 	if cfg.IDSURL == "" {
 		logger.Warn("IDS URL is not configured")
-		return
+		return res.skip("IDS URL is not configured")
 	}
-	idsVersions := []string{"1", "2", "3", "4", "5"}
-	for _, version := range idsVersions {
+	for _, d := range idsVersionsOf(ComponentIDS) {
+		version := d.version
+		if ctx.Err() != nil {
+			logger.Warn("IDS update aborted")
+			break
+		}
 		// Новая проверка на включение IDS
-		enabled := false
-		switch version {
-		case "1":
-			enabled = cfg.EnableIDS1
-		case "2":
-			enabled = cfg.EnableIDS2
-		case "3":
-			enabled = cfg.EnableIDS3
-		case "4":
-			enabled = cfg.EnableIDS4
-		case "5":
-			enabled = cfg.EnableIDS5
-		}
-		if !enabled {
+		if !d.enabled(cfg) {
 			logger.Infof("IDSv%s: update is disabled by config", version)
 			continue
 		}
 
-		// Special handling for version 4 (GeoIP)
-		if version == "4" {
-			if cfg.GeoIP4URL != "" && cfg.GeoIP6URL != "" {
-				UpdateGeoIPDatabases(conn, cfg, logger)
-			} else {
-				logger.Infof("IDSv4 (GeoIP): URLs are not configured")
-			}
-			continue
-		}
-
 		if cfg.LicenseNumber == "" {
 			logger.Infof("IDSv%s: passing because license key is not configured", version)
 			continue
 		}
-		url := fmt.Sprintf(cfg.IDSURL, cfg.LicenseNumber, version)
-		resp, err := utils.HTTPGetWithRetry(url, cfg.RetryCount, time.Duration(cfg.RetryDelaySeconds)*time.Second, cfg.ProxyURL)
-		if err != nil {
-			logger.Errorf("IDSv%s: request error: %v", version, err)
-			continue
-		}
-		defer resp.Body.Close()
-		if resp.StatusCode != 200 {
-			logger.Warnf("IDSv%s: bad status: %d", version, resp.StatusCode)
+		remoteVersion, downloadLink, err := fetchIDSUpdateInfo(ctx, cfg, logger, version)
+		if errors.Is(err, errIDSNoUpdate) {
+			logger.Warnf("IDSv%s: parse error or no update", version)
 			continue
 		}
-		lines, err := utils.ReadLines(resp.Body)
 		if err != nil {
-			logger.Errorf("IDSv%s: read body error: %v", version, err)
-			continue
-		}
-		var remoteVersion int
-		var downloadLink string
-		parseErr := false
-		for _, line := range lines {
-			if len(line) == 0 {
-				continue
-			}
-			kv := utils.SplitKV(line, ':')
-			if len(kv) != 2 {
-				parseErr = true
-				break
-			}
-			key, value := kv[0], kv[1]
-			if key == "0" {
-				parts := utils.SplitKV(value, '.')
-				if len(parts) != 2 {
-					parseErr = true
-					break
-				}
-				remoteVersion = utils.AtoiSafe(parts[1])
-			} else if key == "full" {
-				downloadLink = value
-			} else {
-				logger.Warnf("IDSv%s: error: %s", version, line)
-				parseErr = true
-				break
-			}
-		}
-		if parseErr || downloadLink == "" || remoteVersion == 0 {
-			logger.Warnf("IDSv%s: parse error or no update", version)
+			res.failf(logger, "IDSv%s: %w", version, err)
 			continue
 		}
 		// get current version from DB
@@ -114,34 +64,41 @@ func DownloadAndUpdateIDS(conn *sql.DB, cfg *config.Config, logger *logrus.Logge
 		}
 		logger.Infof("IDSv%s: downloading new version: %d", version, remoteVersion)
 		if err := os.MkdirAll("mirror", 0755); err != nil {
-			logger.Errorf("IDSv%s: failed to create mirror directory: %v", version, err)
+			res.failf(logger, "IDSv%s: failed to create mirror directory: %w", version, err)
 			continue
 		}
 		filename := filepath.Base(downloadLink)
 		destPath := filepath.Join("mirror", filename)
-		if !utils.DownloadFileWithProxy(downloadLink, destPath, cfg.ProxyURL, cfg.RetryCount, time.Duration(cfg.RetryDelaySeconds)*time.Second, logger) {
-			logger.Errorf("IDSv%s: failed to download main file", version)
+		if !utils.DownloadFileWithProxy(ctx, downloadLink, destPath, cfg.ProxyURL, cfg.RetryCount, time.Duration(cfg.RetryDelaySeconds)*time.Second, logger) {
+			res.failf(logger, "IDSv%s: failed to download main file", version)
 			continue
 		}
-		if version == "1" || version == "2" || version == "3" || version == "5" {
-			sigPath := destPath + ".sig"
-			sigURL := downloadLink + ".sig"
-			if !utils.DownloadFileWithProxy(sigURL, sigPath, cfg.ProxyURL, cfg.RetryCount, time.Duration(cfg.RetryDelaySeconds)*time.Second, logger) {
-				logger.Errorf("IDSv%s: failed to download signature file", version)
-				continue
-			}
+		// Все версии IDS публикуются с подписью
+		sigPath := destPath + ".sig"
+		sigURL := downloadLink + ".sig"
+		if !utils.DownloadFileWithProxy(ctx, sigURL, sigPath, cfg.ProxyURL, cfg.RetryCount, time.Duration(cfg.RetryDelaySeconds)*time.Second, logger) {
+			res.failf(logger, "IDSv%s: failed to download signature file", version)
+			continue
+		}
+		// Новая версия публикуется только записью в БД, поэтому при отмене оставляем старую
+		if ctx.Err() != nil {
+			logger.Warnf("IDSv%s: update aborted, keeping published version %d", version, currentVersion)
+			break
 		}
 		err = db.UpdateIDSVersion(conn, version, remoteVersion, filename, true, time.Now())
 		if err != nil {
-			logger.Errorf("IDSv%s: failed to update version in DB: %v", version, err)
+			res.failf(logger, "IDSv%s: failed to update version in DB: %w", version, err)
 			continue
 		}
 		logger.Infof("IDSv%s: downloaded new version - %d", version, remoteVersion)
+		res.changed(filename, filename+".sig")
 
 		// For IDS5, also download Snort template (used by Kerio 9.5 IPS)
-		if version == "5" {
-			if !downloadSnortTemplate(conn, cfg, logger) {
+		if d.snort {
+			if changed, ok := downloadSnortTemplate(ctx, conn, cfg, logger); !ok {
 				logger.Warn("IDSv5: failed to download Snort template, but IDS5 update succeeded")
+			} else if changed {
+				res.changed("custom/control-update/config/v1/snort.tpl")
 			}
 		}
 
@@ -165,4 +122,174 @@ func DownloadAndUpdateIDS(conn *sql.DB, cfg *config.Config, logger *logrus.Logge
 			}
 		}
 	}
+	res.NewVersion = componentVersion(conn, cfg, ComponentIDS)
+	return res.finish(ctx)
+}
+
+// idsDatabase is an IDS database version, published by the IDS updater or, for IDSv4, by GeoIP
+type idsDatabase struct {
+	version   string
+	component string // компонент, который публикует эту версию
+	title     string
+	enabled   func(cfg *config.Config) bool
+	snort     bool // вместе с версией публикуется шаблон Snort
+}
+
+// idsDatabases lists all IDS versions Kerio Control asks update.php for
+var idsDatabases = []idsDatabase{
+	{version: "1", component: ComponentIDS, title: "IPS/IDS Snort (Windows)", enabled: func(cfg *config.Config) bool { return cfg.EnableIDS1 }},
+	{version: "2", component: ComponentIDS, title: "Compromised IPs List", enabled: func(cfg *config.Config) bool { return cfg.EnableIDS2 }},
+	{version: "3", component: ComponentIDS, title: "IPS/IDS Snort (Linux <9.5)", enabled: func(cfg *config.Config) bool { return cfg.EnableIDS3 }},
+	{version: "4", component: ComponentGeoIP, title: "GeoIP Database", enabled: func(cfg *config.Config) bool { return cfg.EnableIDS4 }},
+	{version: "5", component: ComponentIDS, title: "IPS/IDS Snort (Linux ≥9.5)", enabled: func(cfg *config.Config) bool { return cfg.EnableIDS5 }, snort: true},
+}
+
+// idsVersionsOf returns the IDS versions published by the component
+func idsVersionsOf(component string) []idsDatabase {
+	var out []idsDatabase
+	for _, d := range idsDatabases {
+		if d.component == component {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// IDSDatabase is the published state of one IDS version
+type IDSDatabase struct {
+	Version       string `json:"version"`
+	Title         string `json:"title"`
+	Component     string `json:"component"`
+	Enabled       bool   `json:"enabled"`
+	Published     int    `json:"published"`
+	Success       bool   `json:"success"`
+	SnortTemplate bool   `json:"snort_template,omitempty"` // вместе с версией публикуется шаблон Snort
+}
+
+// IDSDatabases returns the IDS versions of the registered updaters with their published versions
+func IDSDatabases(conn *sql.DB, cfg *config.Config) []IDSDatabase {
+	var out []IDSDatabase
+	for _, d := range idsDatabases {
+		if _, ok := LookupUpdater(d.component); !ok {
+			continue
+		}
+		success, _, _ := db.GetIDSUpdateStatus(conn, d.version)
+		out = append(out, IDSDatabase{
+			Version:       d.version,
+			Title:         d.title,
+			Component:     d.component,
+			Enabled:       d.enabled(cfg),
+			Published:     db.GetIDSVersion(conn, d.version),
+			Success:       success,
+			SnortTemplate: d.snort,
+		})
+	}
+	return out
+}
+
+// errIDSNoUpdate is returned by fetchIDSUpdateInfo when update.php offers no full update
+// or its answer can't be parsed
+var errIDSNoUpdate = errors.New("parse error or no update")
+
+// fetchIDSUpdateInfo asks update.php for the latest version of the IDS database
+// and returns the remote version and the download link of the full update.
+func fetchIDSUpdateInfo(ctx context.Context, cfg *config.Config, logger *logrus.Logger, version string) (int, string, error) {
+	url := fmt.Sprintf(cfg.IDSURL, cfg.LicenseNumber, version)
+	resp, err := utils.HTTPGetWithRetry(ctx, url, cfg.RetryCount, time.Duration(cfg.RetryDelaySeconds)*time.Second, cfg.ProxyURL)
+	if err != nil {
+		return 0, "", fmt.Errorf("request error: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return 0, "", fmt.Errorf("bad status: %d", resp.StatusCode)
+	}
+	lines, err := utils.ReadLines(resp.Body)
+	if err != nil {
+		return 0, "", fmt.Errorf("read body error: %w", err)
+	}
+	var remoteVersion int
+	var downloadLink string
+	for _, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		kv := utils.SplitKV(line, ':')
+		if len(kv) != 2 {
+			return 0, "", errIDSNoUpdate
+		}
+		key, value := kv[0], kv[1]
+		if key == "0" {
+			parts := utils.SplitKV(value, '.')
+			if len(parts) != 2 {
+				return 0, "", errIDSNoUpdate
+			}
+			remoteVersion = utils.AtoiSafe(parts[1])
+		} else if key == "full" {
+			downloadLink = value
+		} else {
+			logger.Warnf("IDSv%s: error: %s", version, line)
+			return 0, "", errIDSNoUpdate
+		}
+	}
+	if downloadLink == "" || remoteVersion == 0 {
+		return 0, "", errIDSNoUpdate
+	}
+	return remoteVersion, downloadLink, nil
+}
+
+// idsUpdater publishes IDS versions 1, 2, 3 and 5 (IDSv4 is GeoIP, see geoIPUpdater)
+type idsUpdater struct{}
+
+func (idsUpdater) Name() string  { return ComponentIDS }
+func (idsUpdater) Title() string { return "IDS" }
+
+func (idsUpdater) Schedule(cfg *config.Config) string { return cfg.IDSSchedule }
+
+func (idsUpdater) Enabled(cfg *config.Config) bool {
+	for _, d := range idsVersionsOf(ComponentIDS) {
+		if d.enabled(cfg) {
+			return true
+		}
+	}
+	return false
+}
+
+func (idsUpdater) Check(ctx context.Context, conn *sql.DB, cfg *config.Config, logger *logrus.Logger) []VersionCheck {
+	return checkIDS(ctx, conn, cfg, logger)
+}
+
+func (idsUpdater) Apply(ctx context.Context, conn *sql.DB, cfg *config.Config, logger *logrus.Logger) *ComponentResult {
+	// Загрузка баз IDS 1, 2, 3, 5 (и шаблона Snort вместе с IDS5)
+	return DownloadAndUpdateIDS(ctx, conn, cfg, logger)
+}
+
+// Status reports every enabled IDS version as a separate database.
+// LastSuccess is the oldest of them: one stale version makes the component stale.
+func (idsUpdater) Status(conn *sql.DB, cfg *config.Config) ComponentStatus {
+	var st ComponentStatus
+	var parts []string
+	stale := false // хотя бы одна включённая версия ни разу не обновлялась
+	for _, d := range idsVersionsOf(ComponentIDS) {
+		v := d.version
+		ver := db.GetIDSVersion(conn, v)
+		if ver > 0 {
+			parts = append(parts, fmt.Sprintf("%s:%d", v, ver))
+		}
+		if !d.enabled(cfg) {
+			continue
+		}
+		success, at, err := db.GetIDSUpdateStatus(conn, v)
+		st.Databases = append(st.Databases, DatabaseStatus{Name: "IDSv" + v, Version: strconv.Itoa(ver), Success: success})
+		t, ok := db.ParseTime(at)
+		if err != nil || !ok {
+			stale = true
+		} else if st.LastSuccess.IsZero() || t.Before(st.LastSuccess) {
+			st.LastSuccess = t
+		}
+	}
+	if stale {
+		st.LastSuccess = time.Time{}
+	}
+	st.Version = strings.Join(parts, " ")
+	return st
 }
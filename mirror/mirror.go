@@ -1,8 +1,10 @@
 package mirror
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"html"
 	"strings"
 	"time"
 
@@ -13,13 +15,29 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-func Update(cfg *config.Config, logger *logrus.Logger) {
-	start := time.Now()
-	logger.Info("MirrorUpdate started")
+// Update runs all components in a fixed order.
+// It does not guard against concurrent runs, use Runner for that.
+func Update(ctx context.Context, cfg *config.Config, logger *logrus.Logger) RunReport {
+	return UpdateComponents(ctx, cfg, logger, ComponentNames())
+}
+
+// UpdateComponents runs the given components one after another and combines their results into a report.
+// Cancelling ctx stops the current download and skips the remaining components;
+// already published data stays in place.
+// Only a run of all components announces its start and, if it succeeded, updates last_update;
+// a run of some components reports only what it changed or failed to update.
+func UpdateComponents(ctx context.Context, cfg *config.Config, logger *logrus.Logger, components []string) RunReport {
+	report := RunReport{StartedAt: time.Now()}
+	full := isFullRun(components)
+	logger.Infof("MirrorUpdate started (%s)", strings.Join(components, ", "))
+	progress.begin(components)
+	defer progress.end()
 
 	notifier := telegram.New(cfg)
-	if err := notifier.NotifyStart("&#128260; <b>Kerio Mirror</b>: scheduled update started"); err != nil {
-		logger.Warnf("Telegram notify start: %v", err)
+	if full {
+		if err := notifier.NotifyStart("&#128260; <b>Kerio Mirror</b>: update started"); err != nil {
+			logger.Warnf("Telegram notify start: %v", err)
+		}
 	}
 
 	// open DB
@@ -29,115 +47,108 @@ func Update(cfg *config.Config, logger *logrus.Logger) {
 		if err2 := notifier.NotifyError(fmt.Sprintf("&#10060; <b>Kerio Mirror</b>: failed to open database: %v", err)); err2 != nil {
 			logger.Warnf("Telegram notify error: %v", err2)
 		}
-		return
+		report.finish(false, fmt.Errorf("failed to open database: %w", err))
+		return report
 	}
 	defer conn.Close()
 
-	// Загрузка баз IDS (включая GeoIP как IDS4)
-	DownloadAndUpdateIDS(conn, cfg, logger)
-
-	// Download locations file if configured (part of GeoIP/IDS4)
-	if cfg.EnableIDS4 && cfg.GeoLocURL != "" {
-		DownloadGeoLocations(cfg, logger)
+	for _, name := range components {
+		if ctx.Err() != nil {
+			break
+		}
+		report.Components = append(report.Components, runComponent(ctx, conn, cfg, logger, name))
+	}
+	report.finish(ctx.Err() != nil, nil)
+	resetCheck()
+	storage := Storage(cfg)
+	for _, w := range storage.Warnings {
+		logger.Warnf("Storage: %s", w)
+		report.Warnings = append(report.Warnings, w)
 	}
 
-	// Загрузка баз WebFilter
-	UpdateWebFilterKey(conn, cfg, logger)
-	// Загрузка баз Bitdefender
-	if cfg.BitdefenderMode == "mirror" {
-		downloadAndStoreBitdefender(conn, cfg.BitdefenderURLs, "mirror/bitdefender", cfg, logger)
-	} else if cfg.BitdefenderMode == "proxy" {
-		// В proxy mode выполняем только очистку старых версий
-		currentVersion := db.GetBitdefenderVersion(conn)
-		if currentVersion > 0 {
-			cleanupOldBitdefenderVersions("mirror/bitdefender", currentVersion, cfg.BitdefenderKeepVersions, logger)
-		} else {
-			logger.Info("Bitdefender proxy mode: no current version in DB, skipping cleanup")
+	duration := report.Duration()
+	if report.Status == StatusAborted {
+		logger.Warnf("MirrorUpdate aborted after %s", duration)
+		if err := notifier.NotifyError(fmt.Sprintf("&#9940; <b>Kerio Mirror</b>: update aborted after %s", duration.Round(time.Second))); err != nil {
+			logger.Warnf("Telegram notify error: %v", err)
 		}
-	} else {
-		logger.Infof("Bitdefender is disabled by config (current mode: %s).", cfg.BitdefenderMode)
+		return report
 	}
-
-	// Загрузка Shield Matrix
-	UpdateShieldMatrix(conn, cfg, logger)
-
-	// --- Custom Download URLs ---
-	DownloadCustomFiles(cfg, logger)
-	// --- END Custom Download URLs ---
-
-	duration := time.Since(start)
 	logger.Infof("MirrorUpdate completed in %s", duration)
 
-	// Send Telegram summary notification
-	sendUpdateSummary(notifier, conn, cfg, duration, logger)
+	// Send Telegram summary notification.
+	// Одни и те же предупреждения о месте отправляются один раз, а не после каждого запуска
+	summary := report
+	if !newWarnings(storage) {
+		summary.Warnings = nil
+	}
+	sendUpdateSummary(notifier, summary, full, logger)
 
-	// Сохраняем время последнего обновления
-	err = saveLastUpdate(conn)
-	if err != nil {
-		logger.Errorf("Failed to save last update time: %v", err)
+	// Время последнего обновления - только для полного и успешного запуска
+	if full && report.Status == StatusSuccess {
+		if err := saveLastUpdate(conn); err != nil {
+			logger.Errorf("Failed to save last update time: %v", err)
+		}
 	}
+	return report
 }
 
-// sendUpdateSummary checks component statuses in DB and sends a Telegram notification.
-func sendUpdateSummary(notifier *telegram.Notifier, conn *sql.DB, cfg *config.Config, duration time.Duration, logger *logrus.Logger) {
-	if !notifier.Enabled() {
-		return
+// isFullRun reports whether components covers every registered component
+func isFullRun(components []string) bool {
+	listed := make(map[string]bool, len(components))
+	for _, name := range components {
+		listed[name] = true
 	}
-
-	var failed []string
-	var ok []string
-
-	for _, v := range []string{"1", "2", "3", "4", "5"} {
-		enabled := false
-		switch v {
-		case "1":
-			enabled = cfg.EnableIDS1
-		case "2":
-			enabled = cfg.EnableIDS2
-		case "3":
-			enabled = cfg.EnableIDS3
-		case "4":
-			enabled = cfg.EnableIDS4
-		case "5":
-			enabled = cfg.EnableIDS5
-		}
-		if !enabled {
-			continue
-		}
-		success, _, err := db.GetIDSUpdateStatus(conn, v)
-		if err != nil {
-			continue // no data yet
-		}
-		if success {
-			ok = append(ok, "IDS "+v)
-		} else {
-			failed = append(failed, "IDS "+v)
+	for _, name := range ComponentNames() {
+		if !listed[name] {
+			return false
 		}
 	}
+	return true
+}
 
-	if cfg.BitdefenderMode == "mirror" {
-		success, _, err := db.GetBitdefenderUpdateStatus(conn)
-		if err == nil {
-			if success {
-				ok = append(ok, "Bitdefender")
-			} else {
-				failed = append(failed, "Bitdefender")
-			}
+// sendUpdateSummary sends a Telegram notification built from the run report
+func sendUpdateSummary(notifier *telegram.Notifier, report RunReport, full bool, logger *logrus.Logger) {
+	if !notifier.Enabled() {
+		return
+	}
+	msg, failed := updateSummary(report, full)
+	if msg == "" {
+		return
+	}
+	if failed {
+		if err := notifier.NotifyError(msg); err != nil {
+			logger.Warnf("Telegram notify error: %v", err)
 		}
+		return
 	}
+	if err := notifier.NotifySuccess(msg); err != nil {
+		logger.Warnf("Telegram notify success: %v", err)
+	}
+}
 
-	if cfg.EnableShieldMatrix {
-		success, _, err := db.GetShieldMatrixUpdateStatus(conn)
-		if err == nil {
-			if success {
-				ok = append(ok, "Shield Matrix")
-			} else {
-				failed = append(failed, "Shield Matrix")
-			}
-		}
+// updateSummary formats the report as a Telegram message and reports whether it needs attention:
+// a component failed or storage is running out. The message is empty if no component did anything
+// (all skipped) and there are no storage warnings. For a run of some components (full is false)
+// it is also empty when nothing changed, so a component scheduled every few minutes does not report each run.
+func updateSummary(report RunReport, full bool) (string, bool) {
+	if !full && len(report.WithStatus(StatusSuccess, StatusFailed)) == 0 && len(report.Warnings) == 0 {
+		return "", false
+	}
+	var failed, ok []string
+	for _, c := range report.WithStatus(StatusFailed) {
+		failed = append(failed, summaryItem(c))
 	}
+	for _, c := range report.WithStatus(StatusSuccess, StatusUnchanged) {
+		ok = append(ok, summaryItem(c))
+	}
+
+	durationStr := report.Duration().Round(time.Second).String()
 
-	durationStr := duration.Round(time.Second).String()
+	var warnings string
+	if len(report.Warnings) > 0 {
+		warnings = "\n<b>Storage:</b> " + html.EscapeString(strings.Join(report.Warnings, "; "))
+	}
 
 	if len(failed) > 0 {
 		msg := fmt.Sprintf("&#10060; <b>Kerio Mirror</b>: update finished with errors\n\n<b>Failed:</b> %s\n<b>Duration:</b> %s",
@@ -145,40 +156,182 @@ func sendUpdateSummary(notifier *telegram.Notifier, conn *sql.DB, cfg *config.Co
 		if len(ok) > 0 {
 			msg += fmt.Sprintf("\n<b>OK:</b> %s", strings.Join(ok, ", "))
 		}
-		if err := notifier.NotifyError(msg); err != nil {
-			logger.Warnf("Telegram notify error: %v", err)
-		}
-		return
+		return msg + warnings, true
 	}
 
 	if len(ok) > 0 {
-		msg := fmt.Sprintf("&#9989; <b>Kerio Mirror</b>: update completed\n\n<b>OK:</b> %s\n<b>Duration:</b> %s",
-			strings.Join(ok, ", "), durationStr)
-		if err := notifier.NotifySuccess(msg); err != nil {
-			logger.Warnf("Telegram notify success: %v", err)
+		if warnings != "" {
+			return fmt.Sprintf("&#9888;&#65039; <b>Kerio Mirror</b>: update completed with warnings\n\n<b>OK:</b> %s\n<b>Duration:</b> %s",
+				strings.Join(ok, ", "), durationStr) + warnings, true
 		}
+		return fmt.Sprintf("&#9989; <b>Kerio Mirror</b>: update completed\n\n<b>OK:</b> %s\n<b>Duration:</b> %s",
+			strings.Join(ok, ", "), durationStr), false
 	}
+	if warnings != "" {
+		return "&#9888;&#65039; <b>Kerio Mirror</b>: storage needs attention\n" + warnings, true
+	}
+	return "", false
+}
+
+// summaryItem describes a single component in the Telegram summary
+func summaryItem(c ComponentResult) string {
+	switch c.Status {
+	case StatusFailed:
+		if len(c.Errors) > 0 {
+			return fmt.Sprintf("%s (%s)", c.Title, html.EscapeString(c.Errors[0]))
+		}
+	case StatusSuccess:
+		if c.NewVersion != "" && c.NewVersion != c.OldVersion {
+			return fmt.Sprintf("%s → %s", c.Title, html.EscapeString(c.NewVersion))
+		}
+	case StatusUnchanged:
+		return c.Title + " (no changes)"
+	}
+	return c.Title
 }
 
-func StartScheduler(cfg *config.Config, logger *logrus.Logger) {
+// StartScheduler runs every component according to its own schedule
+// (see ComponentSchedule). Components that are due at the same time run in one batch.
+// Schedules are re-read every minute so changes made in the settings take effect without restart.
+// Runs go through the runner, so a scheduled run waits for a manual one to finish instead of overlapping it.
+// Components that failed are retried with cfg.FailedRetryBackoff delays until their next regular run.
+// Runs and retries that fall into a blackout window (cfg.BlackoutWindows) are moved to the window's end.
+// StartScheduler returns once the runner is shut down.
+func StartScheduler(cfg *config.Config, logger *logrus.Logger, runner *Runner) {
+	type state struct {
+		spec  string
+		sched Schedule
+		next  time.Time
+		retry retryPlan
+	}
+	states := make(map[string]*state)
+
 	for {
+		if runner.isClosed() {
+			logger.Info("Scheduler stopped: mirror is shutting down")
+			return
+		}
 		now := time.Now()
-		target, err := time.ParseInLocation("15:04", cfg.ScheduleTime, now.Location())
+		var due, retries []string
+		wake := now.Add(time.Minute)
+		blackoutEnd := BlackoutUntil(cfg, now)
+		for _, name := range ComponentNames() {
+			st, ok := states[name]
+			if !ok {
+				st = &state{}
+				states[name] = st
+			}
+			spec := ComponentSchedule(cfg, name)
+			if spec != st.spec {
+				st.spec = spec
+				st.next = time.Time{}
+				var err error
+				st.sched, err = ParseSchedule(spec)
+				if err != nil {
+					logger.Errorf("Invalid schedule %q for %s: %v", spec, name, err)
+				}
+			}
+			if st.sched == nil {
+				continue
+			}
+			if st.next.IsZero() {
+				st.next = st.sched.Next(now)
+				logger.Infof("Next scheduled update of %s at %s (in %s)", name, st.next.Format("2006-01-02 15:04:05"), st.next.Sub(now).Round(time.Second))
+				saveComponentNextRun(cfg, name, st.next, logger)
+			}
+			if !now.Before(st.next) {
+				if !blackoutEnd.IsZero() && ComponentEnabled(cfg, name) {
+					// Запуск внутри окна переносится на его конец, повтор тогда не нужен
+					logger.Infof("Scheduled update of %s deferred to the end of the blackout window at %s", name, blackoutEnd.Format("2006-01-02 15:04:05"))
+					st.next = blackoutEnd
+					st.retry = retryPlan{}
+					saveComponentNextRun(cfg, name, st.next, logger)
+				} else {
+					if ComponentEnabled(cfg, name) {
+						due = append(due, name)
+					}
+					st.next = time.Time{}
+					st.retry = retryPlan{}
+					continue
+				}
+			}
+			if st.retry.due(now) {
+				if blackoutEnd.IsZero() {
+					retries = append(retries, name)
+					continue
+				}
+				st.retry.at = blackoutEnd
+			}
+			if st.next.Before(wake) {
+				wake = st.next
+			}
+			if !st.retry.at.IsZero() && st.retry.at.Before(wake) {
+				wake = st.retry.at
+			}
+		}
+
+		var info RunInfo
+		switch {
+		case len(due) > 0:
+			info = runner.Run(TriggerSchedule, due)
+		case len(retries) > 0:
+			logger.Infof("Retrying failed components: %s", strings.Join(retries, ", "))
+			info = runner.Run(TriggerRetry, retries)
+		default:
+			time.Sleep(time.Until(wake))
+			continue
+		}
+		if info.Report == nil {
+			// Отчёта нет только у запуска, отклонённого после Shutdown: на следующем круге выходим
+			continue
+		}
+
+		backoff, err := ParseBackoff(cfg.FailedRetryBackoff)
 		if err != nil {
-			logger.Errorf("Invalid ScheduleTime format: %v", err)
-			return
+			logger.Errorf("Invalid FAILED_RETRY_BACKOFF %q: %v", cfg.FailedRetryBackoff, err)
 		}
-		targetTime := time.Date(now.Year(), now.Month(), now.Day(), target.Hour(), target.Minute(), 0, 0, now.Location())
-		if now.After(targetTime) {
-			targetTime = targetTime.Add(24 * time.Hour)
+		now = time.Now()
+		for _, c := range info.Report.Components {
+			st, ok := states[c.Component]
+			if !ok {
+				continue
+			}
+			next := st.next
+			if next.IsZero() && st.sched != nil {
+				next = st.sched.Next(now)
+			}
+			st.retry.update(c.Status, backoff, now, next)
+			if !st.retry.at.IsZero() {
+				logger.Warnf("%s failed, retry %d at %s", c.Title, st.retry.attempt, st.retry.at.Format("2006-01-02 15:04:05"))
+			} else if c.Status == StatusFailed {
+				logger.Warnf("%s failed, no retry before the next regular run", c.Title)
+			}
 		}
-		dur := targetTime.Sub(now)
-		logger.Infof("Next scheduled update at %s (in %s)", targetTime.Format("2006-01-02 15:04:05"), dur)
-		time.Sleep(dur)
-		Update(cfg, logger)
 	}
 }
 
+// saveComponentNextRun stores the computed next run time of the component in the DB
+func saveComponentNextRun(cfg *config.Config, name string, next time.Time, logger *logrus.Logger) {
+	conn, err := sql.Open("sqlite", cfg.DatabasePath)
+	if err != nil {
+		logger.Errorf("DB open error: %v", err)
+		return
+	}
+	defer conn.Close()
+	if err := db.SetComponentNextRun(conn, name, next); err != nil {
+		logger.Errorf("Failed to save next run time for %s: %v", name, err)
+	}
+}
+
+// NextScheduledRuns returns the next n run times for the configured schedule.
+func NextScheduledRuns(cfg *config.Config, n int) ([]time.Time, error) {
+	sched, err := ParseSchedule(cfg.ScheduleTime)
+	if err != nil {
+		return nil, err
+	}
+	return NextRuns(sched, time.Now(), n), nil
+}
+
 // contains is a helper for substring search
 func contains(s, substr string) bool {
 	return len(s) >= len(substr) && (s == substr || (len(s) > len(substr) && (contains(s[1:], substr) || contains(s[:len(s)-1], substr)))) || (len(s) < len(substr) && false)
@@ -0,0 +1,101 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"kerio-mirror-go/config"
+	"kerio-mirror-go/utils"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fileDownload is one file of a bulk download
+type fileDownload struct {
+	URL  string
+	Dest string
+}
+
+// downloadFiles downloads files with up to workers parallel downloads and reports the progress
+// to the tracker and to the log every 10 files as "<label>: update in progress...". After the first failed file no new
+// downloads are started, the files already in progress are finished and all failures are
+// returned joined. On cancellation it returns as soon as the running downloads stop,
+// the caller checks ctx.Err() itself.
+func downloadFiles(ctx context.Context, cfg *config.Config, logger *logrus.Logger, label string, workers int, files []fileDownload) error {
+	if workers < 1 {
+		workers = 1
+	}
+	total := len(files)
+	prog := progressFrom(ctx)
+	prog.addFiles(total)
+	jobs := make(chan fileDownload)
+	failed := make(chan struct{})
+	var (
+		mu       sync.Mutex
+		errs     []error
+		finished int
+		failOnce sync.Once
+		wg       sync.WaitGroup
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range jobs {
+				err := downloadOne(ctx, cfg, f)
+				if err != nil && ctx.Err() != nil {
+					// Прерванные отменой загрузки не считаются ошибками файлов
+					continue
+				}
+				prog.fileDone()
+				mu.Lock()
+				finished++
+				n := finished
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", f.URL, err))
+				}
+				mu.Unlock()
+				if err != nil {
+					logger.Errorf("%s: failed to download %s: %v", label, f.URL, err)
+					failOnce.Do(func() { close(failed) })
+					continue
+				}
+				logger.Debugf("Stored %s -> %s", label, f.Dest)
+				if n%10 == 0 || n == total {
+					percent := float64(n) / float64(total) * 100
+					logger.Infof("%s: update in progress... %.1f%% (%d/%d)", label, percent, n, total)
+				}
+			}
+		}()
+	}
+
+dispatch:
+	for _, f := range files {
+		select {
+		case jobs <- f:
+		case <-failed:
+			break dispatch
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		logger.Warnf("%s: download aborted at %d/%d", label, finished, total)
+	}
+	return errors.Join(errs...)
+}
+
+func downloadOne(ctx context.Context, cfg *config.Config, f fileDownload) error {
+	if err := os.MkdirAll(filepath.Dir(f.Dest), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	return utils.DownloadFile(ctx, f.URL, f.Dest, cfg.ProxyURL, cfg.RetryCount, time.Duration(cfg.RetryDelaySeconds)*time.Second)
+}
@@ -0,0 +1,96 @@
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"kerio-mirror-go/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+func testPoolFiles(dir, base string, n int) []fileDownload {
+	files := make([]fileDownload, n)
+	for i := range files {
+		files[i] = fileDownload{
+			URL:  fmt.Sprintf("%s/avx/%d.gzip", base, i),
+			Dest: filepath.Join(dir, "avx", fmt.Sprintf("%d.gzip", i)),
+		}
+	}
+	return files
+}
+
+func TestDownloadFiles_Parallel(t *testing.T) {
+	var active, peak int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&active, 1)
+		defer atomic.AddInt32(&active, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer server.Close()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	dir := t.TempDir()
+	files := testPoolFiles(dir, server.URL, 12)
+	if err := downloadFiles(context.Background(), &config.Config{}, logger, "test", 3, files); err != nil {
+		t.Fatalf("downloadFiles failed: %v", err)
+	}
+	for _, f := range files {
+		data, err := os.ReadFile(f.Dest)
+		if err != nil || !strings.HasSuffix(f.URL, string(data)) {
+			t.Errorf("Expected %s to contain its URL path, got %q (%v)", f.Dest, data, err)
+		}
+	}
+	// Не больше workers одновременных загрузок, но и не по одной
+	if peak > 3 || peak < 2 {
+		t.Errorf("Expected 2-3 parallel downloads, got %d", peak)
+	}
+}
+
+func TestDownloadFiles_FailureStopsPool(t *testing.T) {
+	var mu sync.Mutex
+	requested := map[string]bool{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested[r.URL.Path] = true
+		mu.Unlock()
+		if r.URL.Path == "/avx/1.gzip" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	files := testPoolFiles(t.TempDir(), server.URL, 50)
+	err := downloadFiles(context.Background(), &config.Config{}, logger, "test", 2, files)
+	if err == nil || !strings.Contains(err.Error(), "/avx/1.gzip") {
+		t.Fatalf("Expected error for 1.gzip, got %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	// После первой ошибки новые загрузки не запускаются
+	if len(requested) >= len(files) {
+		t.Errorf("Expected pool to stop after failure, got %d of %d files requested", len(requested), len(files))
+	}
+}
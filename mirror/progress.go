@@ -0,0 +1,215 @@
+package mirror
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"kerio-mirror-go/utils"
+)
+
+// ProgressPending is the state of a component that has not started yet in the current update
+const ProgressPending = "pending"
+
+// ComponentProgress is the live state of one component of the current or last update
+type ComponentProgress struct {
+	Name        string     `json:"name"`
+	Title       string     `json:"title"`
+	State       string     `json:"state"` // pending, running или статус результата
+	FilesDone   int64      `json:"files_done"`
+	FilesTotal  int64      `json:"files_total"` // 0 - число файлов заранее неизвестно
+	Bytes       int64      `json:"bytes"`
+	BytesPerSec int64      `json:"bytes_per_sec"`
+	Percent     float64    `json:"percent"`               // по файлам, 0 - неизвестно
+	ETASeconds  int64      `json:"eta_seconds,omitempty"` // по скорости загрузки файлов
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
+
+// Progress is a snapshot of the progress of the current update, or of the last one when Running is false
+type Progress struct {
+	Running    bool                `json:"running"`
+	StartedAt  *time.Time          `json:"started_at,omitempty"`
+	FinishedAt *time.Time          `json:"finished_at,omitempty"`
+	Components []ComponentProgress `json:"components"`
+}
+
+// componentProgress is updated by the downloads of one component
+type componentProgress struct {
+	name     string
+	state    string
+	stats    *utils.DownloadStats
+	started  time.Time
+	finished time.Time
+
+	filesDone  atomic.Int64 // файлы пакетной загрузки (downloadFiles)
+	filesTotal atomic.Int64
+}
+
+// addFiles announces n more files of a bulk download
+func (p *componentProgress) addFiles(n int) {
+	if p != nil {
+		p.filesTotal.Add(int64(n))
+	}
+}
+
+// fileDone counts one finished file of a bulk download
+func (p *componentProgress) fileDone() {
+	if p != nil {
+		p.filesDone.Add(1)
+	}
+}
+
+type progressKey struct{}
+
+// progressFrom returns the progress of the component running with ctx or nil
+func progressFrom(ctx context.Context) *componentProgress {
+	p, _ := ctx.Value(progressKey{}).(*componentProgress)
+	return p
+}
+
+// progressTracker keeps the progress of the current update and notifies subscribers of state changes.
+// Bytes change all the time and are not notified, subscribers poll the snapshot for them.
+type progressTracker struct {
+	mu         sync.Mutex
+	running    bool
+	started    time.Time
+	finished   time.Time
+	components []*componentProgress
+	subs       map[chan struct{}]struct{}
+}
+
+var progress = &progressTracker{subs: map[chan struct{}]struct{}{}}
+
+// CurrentProgress returns the progress of the running update, or of the last one
+func CurrentProgress() Progress {
+	return progress.snapshot(time.Now())
+}
+
+// SubscribeProgress returns a channel that receives a value whenever a component or the update
+// starts or finishes. cancel must be called when the subscriber is done.
+func SubscribeProgress() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	progress.mu.Lock()
+	progress.subs[ch] = struct{}{}
+	progress.mu.Unlock()
+	return ch, func() {
+		progress.mu.Lock()
+		delete(progress.subs, ch)
+		progress.mu.Unlock()
+	}
+}
+
+// notify wakes up the subscribers, the caller holds mu
+func (t *progressTracker) notify() {
+	for ch := range t.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+			// Подписчик ещё не забрал прошлое уведомление, снимок он всё равно возьмёт свежий
+		}
+	}
+}
+
+// begin starts tracking an update of components, all of them pending
+func (t *progressTracker) begin(components []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.running, t.started, t.finished = true, time.Now(), time.Time{}
+	t.components = nil
+	for _, name := range components {
+		t.components = append(t.components, &componentProgress{name: name, state: ProgressPending})
+	}
+	t.notify()
+}
+
+// end marks the update as finished, components that did not run stay pending
+func (t *progressTracker) end() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.running, t.finished = false, time.Now()
+	t.notify()
+}
+
+// startComponent marks name as running and returns ctx carrying its progress for the downloads
+func (t *progressTracker) startComponent(ctx context.Context, name string, stats *utils.DownloadStats) context.Context {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var p *componentProgress
+	for _, c := range t.components {
+		if c.name == name {
+			p = c
+		}
+	}
+	if p == nil {
+		p = &componentProgress{name: name}
+		t.components = append(t.components, p)
+	}
+	p.state, p.stats, p.started = StatusRunning, stats, time.Now()
+	t.notify()
+	return context.WithValue(ctx, progressKey{}, p)
+}
+
+// finishComponent records the final status of name
+func (t *progressTracker) finishComponent(name, status string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, c := range t.components {
+		if c.name == name {
+			c.state, c.finished = status, time.Now()
+		}
+	}
+	t.notify()
+}
+
+func (t *progressTracker) snapshot(now time.Time) Progress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p := Progress{Running: t.running, Components: []ComponentProgress{}}
+	if !t.started.IsZero() {
+		p.StartedAt = timePtr(t.started)
+	}
+	if !t.finished.IsZero() {
+		p.FinishedAt = timePtr(t.finished)
+	}
+	for _, c := range t.components {
+		p.Components = append(p.Components, c.snapshot(now))
+	}
+	return p
+}
+
+func (c *componentProgress) snapshot(now time.Time) ComponentProgress {
+	s := ComponentProgress{Name: c.name, Title: ComponentTitle(c.name), State: c.state}
+	if c.started.IsZero() {
+		return s
+	}
+	s.StartedAt = timePtr(c.started)
+	end := now
+	if !c.finished.IsZero() {
+		s.FinishedAt = timePtr(c.finished)
+		end = c.finished
+	}
+	if c.stats != nil {
+		s.Bytes, s.FilesDone = c.stats.Bytes(), c.stats.Files()
+	}
+	// Пакетная загрузка знает число файлов заранее, по нему считаются процент и ETA
+	if total := c.filesTotal.Load(); total > 0 {
+		s.FilesDone, s.FilesTotal = c.filesDone.Load(), total
+		s.Percent = float64(s.FilesDone) / float64(total) * 100
+	}
+	elapsed := end.Sub(c.started)
+	if elapsed > 0 {
+		s.BytesPerSec = int64(float64(s.Bytes) / elapsed.Seconds())
+	}
+	if c.state == StatusRunning && s.FilesTotal > 0 && s.FilesDone > 0 && s.FilesDone < s.FilesTotal {
+		perFile := elapsed / time.Duration(s.FilesDone)
+		s.ETASeconds = int64((perFile * time.Duration(s.FilesTotal-s.FilesDone)).Seconds())
+	}
+	if c.state == StatusSuccess || c.state == StatusUnchanged {
+		s.Percent = 100
+	}
+	return s
+}
+
+func timePtr(t time.Time) *time.Time { return &t }
@@ -0,0 +1,98 @@
+package mirror
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"kerio-mirror-go/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestProgressTracker(t *testing.T) {
+	tr := &progressTracker{subs: map[chan struct{}]struct{}{}}
+	tr.begin([]string{ComponentIDS, ComponentBitdefender})
+
+	p := tr.snapshot(time.Now())
+	if !p.Running || len(p.Components) != 2 || p.Components[0].State != ProgressPending {
+		t.Fatalf("Expected two pending components of a running update, got %+v", p)
+	}
+
+	ctx := tr.startComponent(context.Background(), ComponentIDS, nil)
+	cp := progressFrom(ctx)
+	if cp == nil {
+		t.Fatal("Expected component progress in ctx")
+	}
+	cp.started = time.Now().Add(-4 * time.Second)
+	cp.addFiles(4)
+	cp.fileDone()
+
+	// 1 файл из 4 за 4 секунды: 25%, ещё около 12 секунд
+	p = tr.snapshot(time.Now())
+	ids := p.Components[0]
+	if ids.State != StatusRunning || ids.FilesDone != 1 || ids.FilesTotal != 4 || ids.Percent != 25 {
+		t.Errorf("Expected running IDS with 1/4 files, got %+v", ids)
+	}
+	if ids.ETASeconds < 11 || ids.ETASeconds > 13 {
+		t.Errorf("Expected ETA about 12s, got %d", ids.ETASeconds)
+	}
+
+	tr.finishComponent(ComponentIDS, StatusSuccess)
+	tr.end()
+	p = tr.snapshot(time.Now())
+	if p.Running || p.FinishedAt == nil {
+		t.Errorf("Expected finished update, got %+v", p)
+	}
+	if p.Components[0].State != StatusSuccess || p.Components[0].Percent != 100 || p.Components[0].ETASeconds != 0 {
+		t.Errorf("Expected finished IDS at 100%%, got %+v", p.Components[0])
+	}
+	if p.Components[1].State != ProgressPending {
+		t.Errorf("Expected Bitdefender to stay pending, got %s", p.Components[1].State)
+	}
+}
+
+func TestSubscribeProgress(t *testing.T) {
+	ch, cancel := SubscribeProgress()
+	defer cancel()
+	progress.begin([]string{ComponentGeoIP})
+	defer progress.end()
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatal("Expected notification on update start")
+	}
+	// Уведомления не копятся: канал на одно значение, лишние отбрасываются
+	progress.startComponent(context.Background(), ComponentGeoIP, nil)
+	progress.finishComponent(ComponentGeoIP, StatusFailed)
+	<-ch
+	select {
+	case <-ch:
+		t.Error("Expected a single pending notification")
+	default:
+	}
+}
+
+func TestDownloadFiles_Progress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("data"))
+	}))
+	defer server.Close()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	tr := &progressTracker{subs: map[chan struct{}]struct{}{}}
+	tr.begin([]string{ComponentIDS})
+	ctx := tr.startComponent(context.Background(), ComponentIDS, nil)
+	files := testPoolFiles(t.TempDir(), server.URL, 5)
+	if err := downloadFiles(ctx, &config.Config{}, logger, "test", 2, files); err != nil {
+		t.Fatalf("downloadFiles failed: %v", err)
+	}
+	ids := tr.snapshot(time.Now()).Components[0]
+	if ids.FilesDone != 5 || ids.FilesTotal != 5 || ids.Percent != 100 {
+		t.Errorf("Expected 5/5 files, got %+v", ids)
+	}
+}
@@ -0,0 +1,164 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"kerio-mirror-go/db"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ComponentResult is what a single updater reports about its run
+type ComponentResult struct {
+	Component    string    `json:"component"`
+	Title        string    `json:"title"`
+	Status       string    `json:"status"` // success, unchanged, failed, skipped, aborted
+	OldVersion   string    `json:"old_version,omitempty"`
+	NewVersion   string    `json:"new_version,omitempty"`
+	FilesChanged []string  `json:"files_changed,omitempty"`
+	Errors       []string  `json:"errors,omitempty"`
+	Message      string    `json:"message,omitempty"` // почему компонент пропущен или не изменился
+	Bytes        int64     `json:"bytes"`
+	Files        int64     `json:"files"`
+	StartedAt    time.Time `json:"started_at"`
+	DurationMs   int64     `json:"duration_ms"`
+}
+
+func newResult(component string) *ComponentResult {
+	return &ComponentResult{Component: component, Title: ComponentTitle(component)}
+}
+
+// fail logs the error and adds it to the result
+func (r *ComponentResult) fail(logger *logrus.Logger, err error) {
+	logger.Error(err)
+	r.Errors = append(r.Errors, err.Error())
+}
+
+// failf is fail with fmt.Errorf formatting
+func (r *ComponentResult) failf(logger *logrus.Logger, format string, args ...any) {
+	r.fail(logger, fmt.Errorf(format, args...))
+}
+
+// failed adds an already logged error and marks the result as failed
+func (r *ComponentResult) failed(err error) *ComponentResult {
+	r.Errors = append(r.Errors, err.Error())
+	r.Status = StatusFailed
+	return r
+}
+
+// changed records files that were written to the mirror
+func (r *ComponentResult) changed(files ...string) {
+	r.FilesChanged = append(r.FilesChanged, files...)
+}
+
+// skip marks the component as skipped with the given reason
+func (r *ComponentResult) skip(reason string) *ComponentResult {
+	r.Status = StatusSkipped
+	r.Message = reason
+	return r
+}
+
+// abort marks the result as aborted and returns it
+func (r *ComponentResult) abort() *ComponentResult {
+	r.Status = StatusAborted
+	return r
+}
+
+// finish sets the status from the collected data unless the updater already set it
+func (r *ComponentResult) finish(ctx context.Context) *ComponentResult {
+	if r.Status != "" {
+		return r
+	}
+	switch {
+	case ctx.Err() != nil:
+		r.Status = StatusAborted
+	case len(r.Errors) > 0:
+		r.Status = StatusFailed
+	case len(r.FilesChanged) > 0 || r.OldVersion != r.NewVersion:
+		r.Status = StatusSuccess
+	default:
+		r.Status = StatusUnchanged
+	}
+	return r
+}
+
+// Err returns the errors of the component joined into one, or nil
+func (r *ComponentResult) Err() error {
+	if len(r.Errors) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(r.Errors, "; "))
+}
+
+// record converts the result into a history record of the run
+func (r *ComponentResult) record(runID string) db.RunComponent {
+	return db.RunComponent{
+		RunID:      runID,
+		Component:  r.Component,
+		Status:     r.Status,
+		OldVersion: r.OldVersion,
+		NewVersion: r.NewVersion,
+		Bytes:      r.Bytes,
+		Files:      r.Files,
+		DurationMs: r.DurationMs,
+		Error:      strings.Join(r.Errors, "; "),
+		StartedAt:  r.StartedAt.Format("2006-01-02 15:04:05"),
+	}
+}
+
+// RunReport is the combined result of an update run
+type RunReport struct {
+	RunID      string            `json:"run_id,omitempty"`
+	Trigger    string            `json:"trigger,omitempty"`
+	Status     string            `json:"status"` // success, failed, aborted
+	Error      string            `json:"error,omitempty"`
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt time.Time         `json:"finished_at"`
+	Components []ComponentResult `json:"components"`
+	Warnings   []string          `json:"warnings,omitempty"` // квоты и свободное место после запуска
+}
+
+// Duration returns how long the run took
+func (r *RunReport) Duration() time.Duration {
+	return r.FinishedAt.Sub(r.StartedAt)
+}
+
+// WithStatus returns the components with one of the given statuses
+func (r *RunReport) WithStatus(statuses ...string) []ComponentResult {
+	var out []ComponentResult
+	for _, c := range r.Components {
+		for _, s := range statuses {
+			if c.Status == s {
+				out = append(out, c)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// finish sets the end time, status and error summary of the report.
+// runErr is set when the run could not start at all.
+func (r *RunReport) finish(aborted bool, runErr error) {
+	r.FinishedAt = time.Now()
+	switch {
+	case runErr != nil:
+		r.Status, r.Error = StatusFailed, runErr.Error()
+	case aborted:
+		r.Status, r.Error = StatusAborted, ""
+	default:
+		var failed []string
+		for _, c := range r.WithStatus(StatusFailed) {
+			failed = append(failed, c.Title)
+		}
+		if len(failed) > 0 {
+			r.Status, r.Error = StatusFailed, "failed: "+strings.Join(failed, ", ")
+		} else {
+			r.Status, r.Error = StatusSuccess, ""
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package mirror
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestComponentResultFinish(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		res      ComponentResult
+		expected string
+	}{
+		{"no changes", context.Background(), ComponentResult{OldVersion: "1", NewVersion: "1"}, StatusUnchanged},
+		{"new version", context.Background(), ComponentResult{OldVersion: "1", NewVersion: "2"}, StatusSuccess},
+		{"files changed", context.Background(), ComponentResult{FilesChanged: []string{"custom/a.txt"}}, StatusSuccess},
+		{"errors", context.Background(), ComponentResult{FilesChanged: []string{"x"}, Errors: []string{"boom"}}, StatusFailed},
+		{"aborted", cancelled, ComponentResult{Errors: []string{"boom"}}, StatusAborted},
+		{"status set by updater", context.Background(), ComponentResult{Status: StatusSkipped}, StatusSkipped},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := tt.res
+			if got := res.finish(tt.ctx).Status; got != tt.expected {
+				t.Errorf("Expected status %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestRunReportFinish(t *testing.T) {
+	report := RunReport{
+		StartedAt: time.Now(),
+		Components: []ComponentResult{
+			{Component: ComponentIDS, Title: "IDS", Status: StatusSuccess},
+			{Component: ComponentBitdefender, Title: "Bitdefender", Status: StatusFailed, Errors: []string{"timeout"}},
+			{Component: ComponentCustom, Title: "Custom files", Status: StatusSkipped},
+		},
+	}
+	report.finish(false, nil)
+	if report.Status != StatusFailed || report.Error != "failed: Bitdefender" {
+		t.Errorf("Expected failed report with 'failed: Bitdefender', got %s '%s'", report.Status, report.Error)
+	}
+	if report.FinishedAt.Before(report.StartedAt) {
+		t.Errorf("Expected FinishedAt to be set")
+	}
+
+	report.finish(true, nil)
+	if report.Status != StatusAborted || report.Error != "" {
+		t.Errorf("Expected aborted report, got %s '%s'", report.Status, report.Error)
+	}
+}
+
+func TestUpdateSummary(t *testing.T) {
+	start := time.Date(2025, 3, 14, 3, 0, 0, 0, time.UTC)
+	report := RunReport{
+		StartedAt:  start,
+		FinishedAt: start.Add(95 * time.Second),
+		Components: []ComponentResult{
+			{Title: "IDS", Status: StatusSuccess, OldVersion: "1:10", NewVersion: "1:11"},
+			{Title: "GeoIP", Status: StatusUnchanged},
+			{Title: "Shield Matrix", Status: StatusFailed, Errors: []string{"bad status <503>"}},
+			{Title: "Custom files", Status: StatusSkipped},
+		},
+	}
+	msg, failed := updateSummary(report, true)
+	if !failed {
+		t.Errorf("Expected summary to report a failure")
+	}
+	for _, want := range []string{
+		"<b>Failed:</b> Shield Matrix (bad status &lt;503&gt;)",
+		"<b>OK:</b> IDS → 1:11, GeoIP (no changes)",
+		"<b>Duration:</b> 1m35s",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("Expected summary to contain '%s', got '%s'", want, msg)
+		}
+	}
+	if strings.Contains(msg, "Custom files") {
+		t.Errorf("Expected skipped components to be left out, got '%s'", msg)
+	}
+
+	if msg, _ := updateSummary(RunReport{Components: []ComponentResult{{Status: StatusSkipped}}}, true); msg != "" {
+		t.Errorf("Expected no summary when everything was skipped, got '%s'", msg)
+	}
+
+	// Запуск части компонентов без изменений ничего не сообщает, изменения и ошибки - сообщает
+	unchanged := RunReport{Components: []ComponentResult{{Title: "Bitdefender", Status: StatusUnchanged}}}
+	if msg, _ := updateSummary(unchanged, false); msg != "" {
+		t.Errorf("Expected no summary for an unchanged partial run, got '%s'", msg)
+	}
+	if msg, _ := updateSummary(unchanged, true); msg == "" {
+		t.Errorf("Expected summary for an unchanged full run")
+	}
+	partial := RunReport{Components: []ComponentResult{{Title: "Bitdefender", Status: StatusFailed, Errors: []string{"timeout"}}}}
+	if msg, failed := updateSummary(partial, false); !failed || !strings.Contains(msg, "Bitdefender (timeout)") {
+		t.Errorf("Expected failure summary for a partial run, got '%s' (failed=%v)", msg, failed)
+	}
+
+	// Предупреждения о месте отправляются как ошибка, даже если компоненты ничего не делали
+	msg, failed = updateSummary(RunReport{Components: []ComponentResult{{Status: StatusSkipped}}, Warnings: []string{"Low disk space"}}, true)
+	if !failed || !strings.Contains(msg, "<b>Storage:</b> Low disk space") {
+		t.Errorf("Expected storage warning summary, got '%s' (failed=%v)", msg, failed)
+	}
+}
+
+func TestIsFullRun(t *testing.T) {
+	tests := []struct {
+		components []string
+		expected   bool
+	}{
+		{ComponentNames(), true},
+		{[]string{ComponentBitdefender}, false},
+		{append([]string{"unknown"}, ComponentNames()...), true},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := isFullRun(tt.components); got != tt.expected {
+			t.Errorf("isFullRun(%v): expected %v, got %v", tt.components, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package mirror
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TriggerRetry marks automatic retries of failed components
+const TriggerRetry = "retry"
+
+// minRetryDelay защищает upstream от слишком частых повторов
+const minRetryDelay = time.Minute
+
+// ParseBackoff parses a comma separated list of retry delays like "5m,15m,1h".
+// An empty spec disables retries and returns nil.
+func ParseBackoff(spec string) ([]time.Duration, error) {
+	var delays []time.Duration
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		d, err := time.ParseDuration(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retry delay %q: %w", part, err)
+		}
+		if d < minRetryDelay {
+			return nil, fmt.Errorf("retry delay %s is shorter than %s", d, minRetryDelay)
+		}
+		delays = append(delays, d)
+	}
+	return delays, nil
+}
+
+// retryPlan tracks automatic retries of a failed component
+type retryPlan struct {
+	attempt int       // сколько повторов уже запланировано подряд
+	at      time.Time // когда повторить, zero - повтор не нужен
+}
+
+// due reports whether the retry should run now
+func (p *retryPlan) due(now time.Time) bool {
+	return !p.at.IsZero() && !now.Before(p.at)
+}
+
+// update plans the next retry from the status of the component in the last run.
+// The n-th retry waits backoff[n-1], the last delay repeats. Retries stop on success,
+// when the component was skipped or aborted, and when the retry would not happen
+// before the next regular run, which covers it anyway.
+func (p *retryPlan) update(status string, backoff []time.Duration, now, nextRegular time.Time) {
+	if status != StatusFailed || len(backoff) == 0 {
+		*p = retryPlan{}
+		return
+	}
+	delay := backoff[min(p.attempt, len(backoff)-1)]
+	at := now.Add(delay)
+	if !nextRegular.IsZero() && !at.Before(nextRegular) {
+		*p = retryPlan{}
+		return
+	}
+	p.attempt++
+	p.at = at
+}
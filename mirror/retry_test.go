@@ -0,0 +1,73 @@
+package mirror
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseBackoff(t *testing.T) {
+	delays, err := ParseBackoff(" 5m, 15m ,1h")
+	if err != nil {
+		t.Fatalf("ParseBackoff failed: %v", err)
+	}
+	expected := []time.Duration{5 * time.Minute, 15 * time.Minute, time.Hour}
+	if len(delays) != len(expected) {
+		t.Fatalf("Expected %d delays, got %d", len(expected), len(delays))
+	}
+	for i, d := range delays {
+		if d != expected[i] {
+			t.Errorf("Delay %d: expected %s, got %s", i, expected[i], d)
+		}
+	}
+
+	if delays, err := ParseBackoff(""); err != nil || delays != nil {
+		t.Errorf("Expected empty spec to disable retries, got %v, %v", delays, err)
+	}
+	for _, spec := range []string{"5x", "10s", "5m,foo"} {
+		if _, err := ParseBackoff(spec); err == nil {
+			t.Errorf("Expected error for backoff %q", spec)
+		}
+	}
+}
+
+func TestRetryPlan(t *testing.T) {
+	backoff := []time.Duration{5 * time.Minute, 15 * time.Minute, time.Hour}
+	now := time.Date(2025, 3, 14, 3, 0, 0, 0, time.UTC)
+	nextRegular := now.Add(24 * time.Hour)
+
+	var p retryPlan
+	var got []time.Duration
+	for i := 0; i < 5; i++ {
+		p.update(StatusFailed, backoff, now, nextRegular)
+		if p.at.IsZero() {
+			t.Fatalf("Attempt %d: expected a retry to be planned", i+1)
+		}
+		got = append(got, p.at.Sub(now))
+		if p.due(now) || !p.due(p.at) {
+			t.Errorf("Attempt %d: retry should be due at %s only", i+1, p.at)
+		}
+		now = p.at
+	}
+	expected := []time.Duration{5 * time.Minute, 15 * time.Minute, time.Hour, time.Hour, time.Hour}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("Retry %d: expected delay %s, got %s", i+1, expected[i], got[i])
+		}
+	}
+
+	p.update(StatusSuccess, backoff, now, nextRegular)
+	if !p.at.IsZero() || p.attempt != 0 {
+		t.Errorf("Expected success to reset the plan, got %+v", p)
+	}
+
+	// Повтор после следующего регулярного запуска не нужен
+	p.update(StatusFailed, backoff, now, now.Add(4*time.Minute))
+	if !p.at.IsZero() {
+		t.Errorf("Expected no retry after the next regular run, got %s", p.at)
+	}
+
+	p.update(StatusFailed, nil, now, nextRegular)
+	if !p.at.IsZero() {
+		t.Errorf("Expected no retry with empty backoff, got %s", p.at)
+	}
+}
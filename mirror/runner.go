@@ -0,0 +1,347 @@
+package mirror
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"kerio-mirror-go/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// What started an update run
+const (
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+	TriggerAPI      = "api"
+)
+
+// ErrAlreadyQueued is returned when a run is already waiting for the current one to finish
+var ErrAlreadyQueued = errors.New("another update is already queued")
+
+// ErrBusy is returned when the runner is taken by a run that is just starting or finishing
+var ErrBusy = errors.New("another update is starting or finishing")
+
+// ErrNotRunning is returned by Abort when no update is in progress
+var ErrNotRunning = errors.New("no update is running")
+
+// ErrShuttingDown is returned when an update is requested after Shutdown
+var ErrShuttingDown = errors.New("mirror is shutting down")
+
+// ErrUpdateInterrupted is returned by Shutdown when the running update had to be aborted
+var ErrUpdateInterrupted = errors.New("running update was aborted")
+
+// abortWait limits how long Shutdown waits for an aborted update to stop
+const abortWait = 15 * time.Second
+
+// RunInfo describes a single update run
+type RunInfo struct {
+	ID         string     `json:"id"`
+	Trigger    string     `json:"trigger"`
+	Components []string   `json:"components"`
+	QueuedAt   time.Time  `json:"queued_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Aborted    bool       `json:"aborted,omitempty"`
+	Status     string     `json:"status,omitempty"` // running, success, failed, aborted
+	Error      string     `json:"error,omitempty"`
+	Report     *RunReport `json:"report,omitempty"` // результаты компонентов, есть у завершённого запуска
+}
+
+// AlreadyRunningError is returned when an update is requested while another one is in progress
+type AlreadyRunningError struct {
+	Current RunInfo
+}
+
+func (e *AlreadyRunningError) Error() string {
+	return fmt.Sprintf("update %s is already running (trigger: %s)", e.Current.ID, e.Current.Trigger)
+}
+
+// RunnerStatus is a snapshot of the runner state
+type RunnerStatus struct {
+	Running bool     `json:"running"`
+	Current *RunInfo `json:"current,omitempty"`
+	Queued  *RunInfo `json:"queued,omitempty"`
+	Last    *RunInfo `json:"last,omitempty"`
+}
+
+// Runner makes sure only one update runs at a time.
+// All update runs (scheduled, manual, API) must go through it.
+type Runner struct {
+	cfg    *config.Config
+	logger *logrus.Logger
+	sem    chan struct{}
+	update func(ctx context.Context, components []string) RunReport // UpdateComponents, подменяется в тестах
+
+	mu      sync.Mutex
+	cancel  context.CancelFunc // отмена текущего запуска
+	current *RunInfo
+	queued  *RunInfo
+	last    *RunInfo
+	closed  bool // Shutdown вызван, новые запуски не принимаются
+}
+
+// NewRunner creates a Runner for the given config
+func NewRunner(cfg *config.Config, logger *logrus.Logger) *Runner {
+	r := &Runner{
+		cfg:    cfg,
+		logger: logger,
+		sem:    make(chan struct{}, 1),
+	}
+	r.update = func(ctx context.Context, components []string) RunReport {
+		return UpdateComponents(ctx, cfg, logger, components)
+	}
+	return r
+}
+
+// Start begins an update in the background.
+// If another update is in progress it returns *AlreadyRunningError with that run,
+// or ErrBusy if the runner is taken by a run that has not started or has already finished.
+func (r *Runner) Start(trigger string, components []string) (RunInfo, error) {
+	if r.isClosed() {
+		return RunInfo{}, ErrShuttingDown
+	}
+	info := newRunInfo(trigger, components)
+	select {
+	case r.sem <- struct{}{}:
+	default:
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.current != nil {
+			return RunInfo{}, &AlreadyRunningError{Current: *r.current}
+		}
+		if r.queued != nil {
+			// Семафор занял запуск из очереди, он начнётся сразу
+			return RunInfo{}, &AlreadyRunningError{Current: *r.queued}
+		}
+		return RunInfo{}, ErrBusy
+	}
+	started := r.begin(&info)
+	go r.execute(&info)
+	return started, nil
+}
+
+// Queue starts an update right away if the runner is idle, otherwise schedules it
+// to start as soon as the current one finishes. Only one run can wait in the queue.
+func (r *Runner) Queue(trigger string, components []string) (RunInfo, error) {
+	info, err := r.Start(trigger, components)
+	if err == nil || errors.Is(err, ErrShuttingDown) {
+		return info, err
+	}
+
+	r.mu.Lock()
+	if r.queued != nil {
+		queued := *r.queued
+		r.mu.Unlock()
+		return queued, ErrAlreadyQueued
+	}
+	queued := newRunInfo(trigger, components)
+	r.queued = &queued
+	result := queued
+	r.mu.Unlock()
+
+	r.logger.Infof("Update %s queued (trigger: %s)", queued.ID, trigger)
+	go func() {
+		r.sem <- struct{}{}
+		r.mu.Lock()
+		// Запуск из очереди становится текущим под той же блокировкой, чтобы Start видел один из них
+		r.queued = nil
+		if r.closed {
+			r.mu.Unlock()
+			<-r.sem
+			return
+		}
+		r.beginLocked(&queued)
+		r.mu.Unlock()
+		r.execute(&queued)
+	}()
+	return result, nil
+}
+
+// Run performs an update synchronously, waiting for the current one to finish first.
+// After Shutdown it returns at once with the aborted status and no report.
+func (r *Runner) Run(trigger string, components []string) RunInfo {
+	info := newRunInfo(trigger, components)
+	r.sem <- struct{}{}
+	if r.isClosed() {
+		<-r.sem
+		info.Status, info.Error = StatusAborted, ErrShuttingDown.Error()
+		return info
+	}
+	r.begin(&info)
+	r.execute(&info)
+	return info
+}
+
+// Status returns a snapshot of the runner state
+func (r *Runner) Status() RunnerStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	st := RunnerStatus{Running: r.current != nil}
+	if r.current != nil {
+		c := *r.current
+		st.Current = &c
+	}
+	if r.queued != nil {
+		q := *r.queued
+		st.Queued = &q
+	}
+	if r.last != nil {
+		l := *r.last
+		st.Last = &l
+	}
+	return st
+}
+
+// Abort cancels the current update. Downloads in progress are interrupted
+// and the previously published data is kept. A queued update still starts afterwards.
+func (r *Runner) Abort() (RunInfo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.current == nil || r.cancel == nil {
+		return RunInfo{}, ErrNotRunning
+	}
+	r.current.Aborted = true
+	r.cancel()
+	r.logger.Warnf("Update %s abort requested", r.current.ID)
+	return *r.current, nil
+}
+
+// Shutdown stops accepting new runs and drops the queued one. The current run may finish
+// until ctx is done, after that it is aborted and Shutdown waits up to abortWait for it to stop.
+// It returns ErrUpdateInterrupted if the run had to be aborted.
+func (r *Runner) Shutdown(ctx context.Context) error {
+	r.mu.Lock()
+	r.closed = true
+	if r.queued != nil {
+		r.logger.Infof("Queued update %s dropped: %v", r.queued.ID, ErrShuttingDown)
+		r.queued = nil
+	}
+	r.mu.Unlock()
+
+	// Семафор сразу отпускаем: после closed запуски отказываются, как только его займут
+	select {
+	case r.sem <- struct{}{}:
+		<-r.sem
+		return nil
+	case <-ctx.Done():
+	}
+
+	info, err := r.Abort()
+	if err == nil {
+		r.logger.Warnf("Update %s is still running, aborting it", info.ID)
+	}
+	select {
+	case r.sem <- struct{}{}:
+		<-r.sem
+	case <-time.After(abortWait):
+		return fmt.Errorf("update did not stop within %s after abort", abortWait)
+	}
+	if err != nil {
+		// Запуск завершился сам между ожиданием и отменой
+		return nil
+	}
+	return ErrUpdateInterrupted
+}
+
+// isClosed reports whether Shutdown was called
+func (r *Runner) isClosed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.closed
+}
+
+// begin marks info as the current run. The caller must hold the semaphore.
+func (r *Runner) begin(info *RunInfo) RunInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.beginLocked(info)
+}
+
+// beginLocked is begin for a caller that holds r.mu
+func (r *Runner) beginLocked(info *RunInfo) RunInfo {
+	now := time.Now()
+	info.StartedAt = &now
+	info.Status = StatusRunning
+	r.current = info
+	return *info
+}
+
+// execute runs the update and releases the semaphore.
+// A panic in the update fails the run instead of leaving the Runner busy forever.
+func (r *Runner) execute(info *RunInfo) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.mu.Lock()
+	r.cancel = cancel
+	started := *info
+	r.mu.Unlock()
+
+	defer func() { <-r.sem }()
+
+	report := RunReport{StartedAt: time.Now()}
+	defer func() {
+		if p := recover(); p != nil {
+			r.logger.Errorf("Update %s panicked: %v\n%s", started.ID, p, debug.Stack())
+			report.Status, report.Error = StatusFailed, fmt.Sprintf("update panicked: %v", p)
+			report.FinishedAt = time.Now()
+		}
+
+		aborted := ctx.Err() != nil
+		now := time.Now()
+		r.mu.Lock()
+		cancel()
+		r.cancel = nil
+		report.RunID, report.Trigger = started.ID, started.Trigger
+		if (aborted || info.Aborted) && report.Status != StatusAborted {
+			report.Status, report.Error = StatusAborted, ""
+		}
+		info.FinishedAt = &now
+		info.Status, info.Error = report.Status, report.Error
+		info.Report = &report
+		finished := *info
+		r.mu.Unlock()
+
+		// Запуск освобождается, даже если запись в историю упала
+		defer func() {
+			r.mu.Lock()
+			r.current = nil
+			r.last = &finished
+			r.mu.Unlock()
+		}()
+
+		// Запуск сначала записывается в историю: кто увидел его завершённым в Status, найдёт его и в БД
+		recordRunFinish(r.cfg, finished, report, r.logger)
+		r.logger.Infof("Update %s finished: %s", finished.ID, finished.Status)
+	}()
+
+	r.logger.Infof("Update %s started (trigger: %s)", started.ID, started.Trigger)
+	recordRunStart(r.cfg, started, r.logger)
+	report = r.update(ctx, started.Components)
+}
+
+func newRunInfo(trigger string, components []string) RunInfo {
+	if len(components) == 0 {
+		components = ComponentNames()
+	}
+	return RunInfo{
+		ID:         newRunID(),
+		Trigger:    trigger,
+		Components: append([]string(nil), components...),
+		QueuedAt:   time.Now(),
+	}
+}
+
+// newRunID generates a sortable, human readable run ID like 20250314-030000-1a2b3c
+func newRunID() string {
+	b := make([]byte, 3)
+	if _, err := rand.Read(b); err != nil {
+		return time.Now().Format("20060102-150405.000000")
+	}
+	return time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(b)
+}
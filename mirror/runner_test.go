@@ -0,0 +1,281 @@
+package mirror
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"kerio-mirror-go/config"
+	"kerio-mirror-go/db"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newTestRunner(t *testing.T) (*Runner, chan struct{}, chan []string) {
+	t.Helper()
+	dbPath := filepath.Join(t.TempDir(), "mirror.db")
+	if err := db.Init(dbPath); err != nil {
+		t.Fatalf("DB init failed: %v", err)
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	r := NewRunner(&config.Config{DatabasePath: dbPath, HistoryRetentionDays: 30}, logger)
+	release := make(chan struct{})
+	started := make(chan []string, 4)
+	r.update = func(ctx context.Context, components []string) RunReport {
+		report := RunReport{StartedAt: time.Now()}
+		started <- components
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+		for _, name := range components {
+			res := newResult(name)
+			res.OldVersion, res.NewVersion, res.Bytes, res.Files = "41", "42", 100, 1
+			report.Components = append(report.Components, *res.finish(ctx))
+		}
+		report.finish(ctx.Err() != nil, nil)
+		return report
+	}
+	return r, release, started
+}
+
+func waitStatus(t *testing.T, r *Runner, cond func(RunnerStatus) bool) RunnerStatus {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if st := r.Status(); cond(st) {
+			return st
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("Timeout waiting for runner status, got %+v", r.Status())
+	return RunnerStatus{}
+}
+
+func TestRunnerRejectsConcurrentStart(t *testing.T) {
+	r, release, started := newTestRunner(t)
+
+	first, err := r.Start(TriggerManual, nil)
+	if err != nil {
+		t.Fatalf("First start failed: %v", err)
+	}
+	<-started
+
+	_, err = r.Start(TriggerAPI, nil)
+	var busy *AlreadyRunningError
+	if !errors.As(err, &busy) {
+		t.Fatalf("Expected AlreadyRunningError, got %v", err)
+	}
+	if busy.Current.ID != first.ID {
+		t.Errorf("Expected current run ID %s, got %s", first.ID, busy.Current.ID)
+	}
+
+	close(release)
+	st := waitStatus(t, r, func(st RunnerStatus) bool { return !st.Running })
+	if st.Last == nil || st.Last.ID != first.ID || st.Last.FinishedAt == nil {
+		t.Errorf("Expected last run %s to be finished, got %+v", first.ID, st.Last)
+	}
+}
+
+func TestRunnerBusyWithoutCurrent(t *testing.T) {
+	r, _, _ := newTestRunner(t)
+
+	// Семафор занят запуском, который ещё не стал текущим: чужой RunInfo не возвращается
+	r.sem <- struct{}{}
+	_, err := r.Start(TriggerAPI, nil)
+	var busy *AlreadyRunningError
+	if errors.As(err, &busy) || !errors.Is(err, ErrBusy) {
+		t.Errorf("Expected ErrBusy, got %v", err)
+	}
+	<-r.sem
+}
+
+func TestRunnerQueue(t *testing.T) {
+	r, release, started := newTestRunner(t)
+
+	if _, err := r.Start(TriggerManual, []string{ComponentIDS}); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	<-started
+
+	queued, err := r.Queue(TriggerManual, []string{ComponentGeoIP})
+	if err != nil {
+		t.Fatalf("Queue failed: %v", err)
+	}
+	if queued.StartedAt != nil {
+		t.Errorf("Expected queued run not to be started yet")
+	}
+	if _, err := r.Queue(TriggerAPI, nil); !errors.Is(err, ErrAlreadyQueued) {
+		t.Errorf("Expected ErrAlreadyQueued, got %v", err)
+	}
+
+	release <- struct{}{}
+	components := <-started
+	if len(components) != 1 || components[0] != ComponentGeoIP {
+		t.Errorf("Expected queued run of [geoip], got %v", components)
+	}
+	st := r.Status()
+	if st.Current == nil || st.Current.ID != queued.ID || st.Queued != nil {
+		t.Errorf("Expected queued run %s to be current, got %+v", queued.ID, st)
+	}
+	close(release)
+	waitStatus(t, r, func(st RunnerStatus) bool { return !st.Running })
+}
+
+func TestRunnerAbort(t *testing.T) {
+	r, _, started := newTestRunner(t)
+
+	if _, err := r.Abort(); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("Expected ErrNotRunning, got %v", err)
+	}
+
+	info, err := r.Start(TriggerManual, nil)
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	<-started
+
+	aborted, err := r.Abort()
+	if err != nil {
+		t.Fatalf("Abort failed: %v", err)
+	}
+	if aborted.ID != info.ID {
+		t.Errorf("Expected aborted run %s, got %s", info.ID, aborted.ID)
+	}
+	st := waitStatus(t, r, func(st RunnerStatus) bool { return !st.Running })
+	if st.Last == nil || !st.Last.Aborted {
+		t.Errorf("Expected last run to be marked aborted, got %+v", st.Last)
+	}
+}
+
+func TestRunnerRecordsHistory(t *testing.T) {
+	r, release, started := newTestRunner(t)
+
+	info, err := r.Start(TriggerAPI, []string{ComponentIDS, ComponentGeoIP})
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	<-started
+	close(release)
+	st := waitStatus(t, r, func(st RunnerStatus) bool { return !st.Running })
+	if rep := st.Last.Report; rep == nil || rep.RunID != info.ID || rep.Trigger != TriggerAPI || len(rep.Components) != 2 {
+		t.Errorf("Expected report of run %s with 2 components, got %+v", info.ID, st.Last.Report)
+	}
+
+	conn, err := sql.Open("sqlite", r.cfg.DatabasePath)
+	if err != nil {
+		t.Fatalf("DB open failed: %v", err)
+	}
+	defer conn.Close()
+
+	runs, err := db.ListRuns(conn, 10, 0)
+	if err != nil {
+		t.Fatalf("ListRuns failed: %v", err)
+	}
+	if len(runs) != 1 {
+		t.Fatalf("Expected 1 run, got %d", len(runs))
+	}
+	if runs[0].ID != info.ID || runs[0].Trigger != TriggerAPI || runs[0].Status != StatusSuccess {
+		t.Errorf("Unexpected run record: %+v", runs[0])
+	}
+	items, err := db.GetRunComponents(conn, info.ID)
+	if err != nil {
+		t.Fatalf("GetRunComponents failed: %v", err)
+	}
+	if len(items) != 2 || items[0].Component != ComponentIDS || items[1].Bytes != 100 {
+		t.Errorf("Unexpected component records: %+v", items)
+	}
+}
+
+func TestRunnerRecoversPanic(t *testing.T) {
+	r, release, started := newTestRunner(t)
+	update := r.update
+	r.update = func(ctx context.Context, components []string) RunReport {
+		panic("boom")
+	}
+	if _, err := r.Start(TriggerManual, nil); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	st := waitStatus(t, r, func(st RunnerStatus) bool { return !st.Running && st.Last != nil })
+	if st.Last.Status != StatusFailed || st.Last.Error == "" {
+		t.Errorf("Expected failed run with error, got %+v", st.Last)
+	}
+
+	// Семафор освобождён, следующий запуск проходит
+	r.update = update
+	close(release)
+	if _, err := r.Start(TriggerManual, nil); err != nil {
+		t.Fatalf("Expected start after panic, got %v", err)
+	}
+	<-started
+	waitStatus(t, r, func(st RunnerStatus) bool { return !st.Running })
+}
+
+func TestRunnerShutdown(t *testing.T) {
+	// Текущий запуск успевает завершиться до таймаута
+	r, release, started := newTestRunner(t)
+	if _, err := r.Start(TriggerManual, []string{ComponentIDS}); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	<-started
+	if _, err := r.Queue(TriggerAPI, nil); err != nil {
+		t.Fatalf("Queue failed: %v", err)
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := r.Shutdown(ctx); err != nil {
+		t.Errorf("Expected clean shutdown, got %v", err)
+	}
+	st := r.Status()
+	if st.Last == nil || st.Last.Status != StatusSuccess || st.Queued != nil || st.Running {
+		t.Errorf("Expected finished run and dropped queue, got %+v", st)
+	}
+	if _, err := r.Start(TriggerManual, nil); !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("Expected ErrShuttingDown, got %v", err)
+	}
+
+	// Текущий запуск не успевает и прерывается
+	r, _, started = newTestRunner(t)
+	if _, err := r.Start(TriggerManual, []string{ComponentIDS}); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	<-started
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := r.Shutdown(ctx); !errors.Is(err, ErrUpdateInterrupted) {
+		t.Errorf("Expected ErrUpdateInterrupted, got %v", err)
+	}
+	if st := r.Status(); st.Last == nil || st.Last.Status != StatusAborted {
+		t.Errorf("Expected aborted run, got %+v", st.Last)
+	}
+	if info := r.Run(TriggerSchedule, nil); info.Status != StatusAborted || info.Report != nil {
+		t.Errorf("Expected Run to be refused after shutdown, got %+v", info)
+	}
+}
+
+func TestStartSchedulerStopsAfterShutdown(t *testing.T) {
+	r, _, _ := newTestRunner(t)
+	r.cfg.ScheduleTime = "@every 1m"
+	if err := r.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		StartScheduler(r.cfg, r.logger, r)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected StartScheduler to return after Shutdown")
+	}
+}
@@ -0,0 +1,288 @@
+package mirror
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule computes the moments when an update should run.
+type Schedule interface {
+	// Next returns the first run time strictly after t.
+	Next(t time.Time) time.Time
+}
+
+// ParseSchedule parses a schedule specification. Supported formats:
+//
+//	"03:00"              - once a day at the given time (legacy SCHEDULE_TIME format)
+//	"03:00,15:00"        - list of daily times (comma or space separated)
+//	"@every 6h"          - fixed interval, aligned to local midnight
+//	"@hourly", "@daily", "@weekly", "@monthly"
+//	"0 */6 * * *"        - standard 5-field cron expression (minute hour day month weekday)
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, fmt.Errorf("empty schedule")
+	}
+
+	switch strings.ToLower(spec) {
+	case "@hourly":
+		return parseCron("0 * * * *")
+	case "@daily", "@midnight":
+		return parseCron("0 0 * * *")
+	case "@weekly":
+		return parseCron("0 0 * * 0")
+	case "@monthly":
+		return parseCron("0 0 1 * *")
+	}
+
+	lower := strings.ToLower(spec)
+	if strings.HasPrefix(lower, "@every ") || strings.HasPrefix(lower, "every ") {
+		raw := strings.TrimSpace(spec[strings.Index(spec, " ")+1:])
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval %q: %w", raw, err)
+		}
+		if d < time.Minute {
+			return nil, fmt.Errorf("interval %s is too short, minimum is 1m", d)
+		}
+		return intervalSchedule{every: d}, nil
+	}
+
+	if strings.Contains(spec, ":") {
+		return parseDailyTimes(spec)
+	}
+
+	return parseCron(spec)
+}
+
+// NextRuns returns the next n run times of s after t.
+func NextRuns(s Schedule, t time.Time, n int) []time.Time {
+	runs := make([]time.Time, 0, n)
+	for i := 0; i < n; i++ {
+		t = s.Next(t)
+		if t.IsZero() {
+			break
+		}
+		runs = append(runs, t)
+	}
+	return runs
+}
+
+// dailySchedule runs at fixed times of day.
+type dailySchedule struct {
+	minutes []int // minutes since midnight, sorted
+}
+
+func parseDailyTimes(spec string) (Schedule, error) {
+	fields := strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == ' ' || r == ';' })
+	seen := make(map[int]bool)
+	var minutes []int
+	for _, f := range fields {
+		t, err := time.Parse("15:04", f)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time %q, expected HH:MM", f)
+		}
+		m := t.Hour()*60 + t.Minute()
+		if !seen[m] {
+			seen[m] = true
+			minutes = append(minutes, m)
+		}
+	}
+	if len(minutes) == 0 {
+		return nil, fmt.Errorf("no times in schedule %q", spec)
+	}
+	sort.Ints(minutes)
+	return dailySchedule{minutes: minutes}, nil
+}
+
+func (s dailySchedule) Next(t time.Time) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	for d := 0; d < 2; d++ {
+		for _, m := range s.minutes {
+			candidate := time.Date(day.Year(), day.Month(), day.Day()+d, m/60, m%60, 0, 0, t.Location())
+			if candidate.After(t) {
+				return candidate
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// intervalSchedule runs every fixed duration counted in wall-clock time from local midnight of the current day,
+// so that "@every 6h" fires at 00:00, 06:00, 12:00 and 18:00 whatever the zone offset or DST.
+// The count starts again at every midnight: "@every 7h" fires at 00:00, 07:00, 14:00 and 21:00.
+// Intervals of a day or longer are counted from local midnight of 1970-01-01.
+type intervalSchedule struct {
+	every time.Duration
+}
+
+func (s intervalSchedule) Next(t time.Time) time.Time {
+	if s.every >= 24*time.Hour {
+		anchor := time.Date(1970, 1, 1, 0, 0, 0, 0, t.Location())
+		return anchor.Add((t.Sub(anchor)/s.every + 1) * s.every)
+	}
+	y, m, d := t.Date()
+	sinceMidnight := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())
+	n := sinceMidnight/s.every + 1
+	// time.Date переносит наносекунды в часы и дни по часам этого пояса, а не по абсолютному времени
+	next := time.Date(y, m, d, 0, 0, 0, int(n*s.every), t.Location())
+	if midnight := time.Date(y, m, d+1, 0, 0, 0, 0, t.Location()); next.After(midnight) {
+		return midnight
+	}
+	return next
+}
+
+// cronSchedule is a parsed 5-field cron expression.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+type cronField struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	cronMinute = cronField{0, 59, nil}
+	cronHour   = cronField{0, 23, nil}
+	cronDom    = cronField{1, 31, nil}
+	cronMonth  = cronField{1, 12, map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	cronDow = cronField{0, 7, map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+func parseCron(spec string) (Schedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 fields, got %d", spec, len(fields))
+	}
+	var s cronSchedule
+	var err error
+	if s.minute, err = parseCronField(fields[0], cronMinute); err != nil {
+		return nil, fmt.Errorf("cron minute: %w", err)
+	}
+	if s.hour, err = parseCronField(fields[1], cronHour); err != nil {
+		return nil, fmt.Errorf("cron hour: %w", err)
+	}
+	if s.dom, err = parseCronField(fields[2], cronDom); err != nil {
+		return nil, fmt.Errorf("cron day of month: %w", err)
+	}
+	if s.month, err = parseCronField(fields[3], cronMonth); err != nil {
+		return nil, fmt.Errorf("cron month: %w", err)
+	}
+	if s.dow, err = parseCronField(fields[4], cronDow); err != nil {
+		return nil, fmt.Errorf("cron day of week: %w", err)
+	}
+	// 7 is an alias for Sunday
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domStar = fields[2] == "*" || fields[2] == "?"
+	s.dowStar = fields[4] == "*" || fields[4] == "?"
+	return s, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges and steps into a bit set.
+func parseCronField(field string, f cronField) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			step = n
+			part = part[:i]
+		}
+		lo, hi := f.min, f.max
+		switch {
+		case part == "*" || part == "?":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = parseCronValue(bounds[0], f); err != nil {
+				return 0, err
+			}
+			if hi, err = parseCronValue(bounds[1], f); err != nil {
+				return 0, err
+			}
+		default:
+			v, err := parseCronValue(part, f)
+			if err != nil {
+				return 0, err
+			}
+			lo = v
+			if step == 1 {
+				hi = v
+			}
+		}
+		if lo > hi {
+			return 0, fmt.Errorf("invalid range %d-%d", lo, hi)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func parseCronValue(s string, f cronField) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("value %d out of range %d-%d", v, f.min, f.max)
+	}
+	return v, nil
+}
+
+func (s cronSchedule) dayMatches(t time.Time) bool {
+	domOK := s.dom&(1<<uint(t.Day())) != 0
+	dowOK := s.dow&(1<<uint(t.Weekday())) != 0
+	// Classic cron semantics: if both fields are restricted, either may match.
+	if !s.domStar && !s.dowStar {
+		return domOK || dowOK
+	}
+	return domOK && dowOK
+}
+
+func (s cronSchedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
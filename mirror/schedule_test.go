@@ -0,0 +1,165 @@
+package mirror
+
+import (
+	"testing"
+	"time"
+
+	"kerio-mirror-go/config"
+)
+
+func TestParseSchedule(t *testing.T) {
+	base := time.Date(2025, 3, 14, 10, 30, 0, 0, time.UTC) // Friday
+
+	tests := []struct {
+		name     string
+		spec     string
+		expected []time.Time
+	}{
+		{
+			name: "legacy daily time",
+			spec: "03:00",
+			expected: []time.Time{
+				time.Date(2025, 3, 15, 3, 0, 0, 0, time.UTC),
+				time.Date(2025, 3, 16, 3, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "list of daily times",
+			spec: "15:00, 03:00",
+			expected: []time.Time{
+				time.Date(2025, 3, 14, 15, 0, 0, 0, time.UTC),
+				time.Date(2025, 3, 15, 3, 0, 0, 0, time.UTC),
+				time.Date(2025, 3, 15, 15, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "interval",
+			spec: "@every 6h",
+			expected: []time.Time{
+				time.Date(2025, 3, 14, 12, 0, 0, 0, time.UTC),
+				time.Date(2025, 3, 14, 18, 0, 0, 0, time.UTC),
+				time.Date(2025, 3, 15, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "interval not dividing a day starts again at midnight",
+			spec: "@every 7h",
+			expected: []time.Time{
+				time.Date(2025, 3, 14, 14, 0, 0, 0, time.UTC),
+				time.Date(2025, 3, 14, 21, 0, 0, 0, time.UTC),
+				time.Date(2025, 3, 15, 0, 0, 0, 0, time.UTC),
+				time.Date(2025, 3, 15, 7, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "cron every 4 hours at :15",
+			spec: "15 */4 * * *",
+			expected: []time.Time{
+				time.Date(2025, 3, 14, 12, 15, 0, 0, time.UTC),
+				time.Date(2025, 3, 14, 16, 15, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "cron weekdays only",
+			spec: "0 2 * * mon-fri",
+			expected: []time.Time{
+				time.Date(2025, 3, 17, 2, 0, 0, 0, time.UTC),
+				time.Date(2025, 3, 18, 2, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "weekly macro",
+			spec: "@weekly",
+			expected: []time.Time{
+				time.Date(2025, 3, 16, 0, 0, 0, 0, time.UTC),
+				time.Date(2025, 3, 23, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "cron day of month or weekday",
+			spec: "0 0 1 * sun",
+			expected: []time.Time{
+				time.Date(2025, 3, 16, 0, 0, 0, 0, time.UTC),
+				time.Date(2025, 3, 23, 0, 0, 0, 0, time.UTC),
+				time.Date(2025, 3, 30, 0, 0, 0, 0, time.UTC),
+				time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sched, err := ParseSchedule(tt.spec)
+			if err != nil {
+				t.Fatalf("ParseSchedule(%q) failed: %v", tt.spec, err)
+			}
+			runs := NextRuns(sched, base, len(tt.expected))
+			if len(runs) != len(tt.expected) {
+				t.Fatalf("Expected %d runs, got %d", len(tt.expected), len(runs))
+			}
+			for i, r := range runs {
+				if !r.Equal(tt.expected[i]) {
+					t.Errorf("Run %d: expected %s, got %s", i, tt.expected[i], r)
+				}
+			}
+		})
+	}
+}
+
+func TestIntervalScheduleLocalMidnight(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("Time zone data not available: %v", err)
+	}
+	sched, _ := ParseSchedule("@every 6h")
+
+	// Летом смещение +2, а в 1970 было +1: запуски всё равно в 06:00 и 12:00 по местному времени
+	tests := []struct {
+		from     time.Time
+		expected time.Time
+	}{
+		{time.Date(2025, 7, 14, 5, 30, 0, 0, loc), time.Date(2025, 7, 14, 6, 0, 0, 0, loc)},
+		{time.Date(2025, 7, 14, 6, 0, 0, 0, loc), time.Date(2025, 7, 14, 12, 0, 0, 0, loc)},
+		{time.Date(2025, 7, 14, 23, 0, 0, 0, loc), time.Date(2025, 7, 15, 0, 0, 0, 0, loc)},
+		// День перехода на летнее время: 02:00-03:00 пропускается
+		{time.Date(2025, 3, 30, 1, 0, 0, 0, loc), time.Date(2025, 3, 30, 6, 0, 0, 0, loc)},
+	}
+	for _, tt := range tests {
+		if got := sched.Next(tt.from); !got.Equal(tt.expected) {
+			t.Errorf("Next(%s): expected %s, got %s", tt.from, tt.expected, got)
+		}
+	}
+}
+
+func TestParseScheduleInvalid(t *testing.T) {
+	for _, spec := range []string{"", "25:00", "@every 10s", "@every foo", "* * *", "61 * * * *", "0 0 * * 8", "5-1 * * * *"} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("Expected error for schedule %q", spec)
+		}
+	}
+}
+
+func BenchmarkCronNext(b *testing.B) {
+	sched, err := ParseSchedule("0 3 29 2 *")
+	if err != nil {
+		b.Fatal(err)
+	}
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sched.Next(start)
+	}
+}
+
+func TestComponentScheduleFallback(t *testing.T) {
+	cfg := &config.Config{
+		ScheduleTime:        "03:00",
+		BitdefenderSchedule: "@hourly",
+	}
+	if got := ComponentSchedule(cfg, ComponentBitdefender); got != "@hourly" {
+		t.Errorf("Expected Bitdefender schedule '@hourly', got '%s'", got)
+	}
+	if got := ComponentSchedule(cfg, ComponentGeoIP); got != "03:00" {
+		t.Errorf("Expected GeoIP schedule to fall back to '03:00', got '%s'", got)
+	}
+}
@@ -1,8 +1,10 @@
 package mirror
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -24,115 +26,39 @@ type ShieldMatrixCheckUpdateResponse struct {
 }
 
 // UpdateShieldMatrix проверяет и обновляет Shield Matrix (Kerio 9.5+)
-func UpdateShieldMatrix(conn *sql.DB, cfg *config.Config, logger *logrus.Logger) {
+func UpdateShieldMatrix(ctx context.Context, conn *sql.DB, cfg *config.Config, logger *logrus.Logger) *ComponentResult {
 	logger.Debug("Shield Matrix: starting update check...")
+	res := newResult(ComponentShieldMatrix)
 
 	if !cfg.EnableShieldMatrix {
 		logger.Info("Shield Matrix: update is disabled by config")
-		return
+		return res.skip("disabled by config")
 	}
 
 	if cfg.ShieldMatrixBaseURL == "" {
 		logger.Warn("Shield Matrix: base URL is not configured")
-		return
+		return res.skip("base URL is not configured")
 	}
 
 	logger.Infof("Shield Matrix: checking for updates (base URL: %s)", cfg.ShieldMatrixBaseURL)
 
 	// Получаем текущую версию из БД
 	currentVersion := db.GetShieldMatrixVersion(conn)
+	res.OldVersion, res.NewVersion = currentVersion, currentVersion
 	logger.Infof("Shield Matrix: current version in DB: '%s'", currentVersion)
 
-	// Шаг 1: Проверяем наличие обновлений через check_update endpoint
-	// Формируем URL: https://shieldmatrix-updates.gfikeriocontrol.com/check_update/?client-id=control&version=9.5.0&last-update=0
-	checkUpdateURL := fmt.Sprintf("%s?client-id=%s&version=%s&last-update=0",
-		strings.TrimSuffix(cfg.ShieldMatrixBaseURL, "/"),
-		cfg.ShieldMatrixClientID,
-		cfg.ShieldMatrixVersion)
-	logger.Debugf("Shield Matrix: requesting check_update from: %s", checkUpdateURL)
-
-	// Запрашиваем информацию об обновлениях
-	resp, err := utils.HTTPGetWithRetry(checkUpdateURL, cfg.RetryCount, time.Duration(cfg.RetryDelaySeconds)*time.Second, cfg.ProxyURL)
+	cloudFrontBaseURL, remoteVersion, err := checkShieldMatrixRemote(ctx, cfg, logger)
 	if err != nil {
-		logger.Errorf("Shield Matrix: failed to check updates: %v", err)
+		logger.Errorf("Shield Matrix: %v", err)
 		db.UpdateShieldMatrixVersion(conn, currentVersion, false, time.Now())
-		return
+		return res.failed(fmt.Errorf("shield matrix: %w", err))
 	}
-	defer resp.Body.Close()
-
-	logger.Debugf("Shield Matrix: check_update response status: %d", resp.StatusCode)
-
-	if resp.StatusCode != 200 {
-		logger.Errorf("Shield Matrix: bad status code from check_update: %d", resp.StatusCode)
-		db.UpdateShieldMatrixVersion(conn, currentVersion, false, time.Now())
-		return
-	}
-
-	// Читаем JSON ответ
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		logger.Errorf("Shield Matrix: failed to read check_update response: %v", err)
-		db.UpdateShieldMatrixVersion(conn, currentVersion, false, time.Now())
-		return
-	}
-
-	logger.Debugf("Shield Matrix: check_update response: %s", string(body))
-
-	// Парсим JSON ответ
-	var checkUpdateResp ShieldMatrixCheckUpdateResponse
-	if err := json.Unmarshal(body, &checkUpdateResp); err != nil {
-		logger.Errorf("Shield Matrix: failed to parse check_update response: %v", err)
-		db.UpdateShieldMatrixVersion(conn, currentVersion, false, time.Now())
-		return
-	}
-
-	// Проверяем доступность обновлений
-	if !checkUpdateResp.Available {
+	if cloudFrontBaseURL == "" {
 		logger.Info("Shield Matrix: no updates available")
 		db.UpdateShieldMatrixVersion(conn, currentVersion, true, time.Now())
-		return
-	}
-
-	if checkUpdateResp.URL == "" {
-		logger.Warn("Shield Matrix: update is available but URL is empty")
-		db.UpdateShieldMatrixVersion(conn, currentVersion, false, time.Now())
-		return
+		res.Message = "no updates available"
+		return res.finish(ctx)
 	}
-
-	logger.Infof("Shield Matrix: update available, CloudFront URL: %s", checkUpdateResp.URL)
-
-	// Шаг 2: Получаем версию из CloudFront URL
-	// Формируем URL для проверки версии: {CloudFront URL}/version
-	cloudFrontBaseURL := strings.TrimSuffix(checkUpdateResp.URL, "/")
-	versionURL := fmt.Sprintf("%s/version", cloudFrontBaseURL)
-	logger.Debugf("Shield Matrix: requesting version from: %s", versionURL)
-
-	// Запрашиваем версию с CloudFront
-	versionResp, err := utils.HTTPGetWithRetry(versionURL, cfg.RetryCount, time.Duration(cfg.RetryDelaySeconds)*time.Second, cfg.ProxyURL)
-	if err != nil {
-		logger.Errorf("Shield Matrix: failed to get version from CloudFront: %v", err)
-		db.UpdateShieldMatrixVersion(conn, currentVersion, false, time.Now())
-		return
-	}
-	defer versionResp.Body.Close()
-
-	logger.Debugf("Shield Matrix: version response status: %d", versionResp.StatusCode)
-
-	if versionResp.StatusCode != 200 {
-		logger.Errorf("Shield Matrix: bad status code from version endpoint: %d", versionResp.StatusCode)
-		db.UpdateShieldMatrixVersion(conn, currentVersion, false, time.Now())
-		return
-	}
-
-	// Читаем версию
-	versionBody, err := io.ReadAll(versionResp.Body)
-	if err != nil {
-		logger.Errorf("Shield Matrix: failed to read version response: %v", err)
-		db.UpdateShieldMatrixVersion(conn, currentVersion, false, time.Now())
-		return
-	}
-
-	remoteVersion := strings.TrimSpace(string(versionBody))
 	logger.Infof("Shield Matrix: remote version: '%s' (current: '%s')", remoteVersion, currentVersion)
 
 	// Проверяем версию и наличие файлов (если включена предзагрузка)
@@ -143,7 +69,7 @@ func UpdateShieldMatrix(conn *sql.DB, cfg *config.Config, logger *logrus.Logger)
 			if checkShieldMatrixFilesExist(logger) {
 				logger.Info("Shield Matrix: already up to date, all files exist")
 				db.UpdateShieldMatrixVersionWithURL(conn, currentVersion, cloudFrontBaseURL, true, time.Now())
-				return
+				return res.finish(ctx)
 			}
 			// Файлы отсутствуют, нужно загрузить
 			logger.Warn("Shield Matrix: version is up to date but files are missing, re-downloading...")
@@ -151,7 +77,7 @@ func UpdateShieldMatrix(conn *sql.DB, cfg *config.Config, logger *logrus.Logger)
 			// При on-demand режиме файлы не нужны
 			logger.Info("Shield Matrix: already up to date, no changes needed")
 			db.UpdateShieldMatrixVersionWithURL(conn, currentVersion, cloudFrontBaseURL, true, time.Now())
-			return
+			return res.finish(ctx)
 		}
 	} else {
 		// Новая версия доступна
@@ -162,6 +88,12 @@ func UpdateShieldMatrix(conn *sql.DB, cfg *config.Config, logger *logrus.Logger)
 	// Файлы не скачиваются заранее, а загружаются только когда Kerio Control их запрашивает
 	// Поэтому здесь мы только создаём директории и очищаем старые данные
 
+	// Дальше удаляются старые данные, поэтому при отмене выходим здесь
+	if ctx.Err() != nil {
+		logger.Warnf("Shield Matrix: update aborted, keeping version %s", currentVersion)
+		return res.abort()
+	}
+
 	// Создаём директории
 	matrixDir := "mirror/matrix"
 	ipv4Dir := filepath.Join(matrixDir, "ipv4")
@@ -180,20 +112,27 @@ func UpdateShieldMatrix(conn *sql.DB, cfg *config.Config, logger *logrus.Logger)
 	if err := os.MkdirAll(ipv4Dir, 0755); err != nil {
 		logger.Errorf("Shield Matrix: failed to create ipv4 directory: %v", err)
 		db.UpdateShieldMatrixVersionWithURL(conn, currentVersion, cloudFrontBaseURL, false, time.Now())
-		return
+		return res.failed(fmt.Errorf("shield matrix: failed to create ipv4 directory: %w", err))
 	}
 
 	logger.Debugf("Shield Matrix: creating directory: %s", ipv6Dir)
 	if err := os.MkdirAll(ipv6Dir, 0755); err != nil {
 		logger.Errorf("Shield Matrix: failed to create ipv6 directory: %v", err)
 		db.UpdateShieldMatrixVersionWithURL(conn, currentVersion, cloudFrontBaseURL, false, time.Now())
-		return
+		return res.failed(fmt.Errorf("shield matrix: failed to create ipv6 directory: %w", err))
 	}
 
 	// Проверяем, нужно ли предзагружать файлы
 	if cfg.ShieldMatrixPreloadFiles {
 		logger.Info("Shield Matrix: preload mode enabled, downloading all files...")
-		PreloadShieldMatrixFiles(cloudFrontBaseURL, cfg, logger)
+		for _, f := range PreloadShieldMatrixFiles(ctx, cloudFrontBaseURL, cfg, logger) {
+			res.changed("matrix/" + f)
+		}
+		if ctx.Err() != nil {
+			// Версию в БД не меняем: недостающие файлы догрузятся по запросу или при следующем запуске
+			logger.Warnf("Shield Matrix: preload aborted, keeping version %s in DB", currentVersion)
+			return res.abort()
+		}
 	} else {
 		logger.Info("Shield Matrix: directories prepared, files will be downloaded on-demand when requested by Kerio Control")
 	}
@@ -202,7 +141,7 @@ func UpdateShieldMatrix(conn *sql.DB, cfg *config.Config, logger *logrus.Logger)
 	logger.Debugf("Shield Matrix: updating version in DB: %s -> %s, CloudFront URL: %s", currentVersion, remoteVersion, cloudFrontBaseURL)
 	if err := db.UpdateShieldMatrixVersionWithURL(conn, remoteVersion, cloudFrontBaseURL, true, time.Now()); err != nil {
 		logger.Errorf("Shield Matrix: failed to update version in DB: %v", err)
-		return
+		return res.failed(fmt.Errorf("shield matrix: failed to update version in DB: %w", err))
 	}
 
 	if cfg.ShieldMatrixPreloadFiles {
@@ -210,12 +149,15 @@ func UpdateShieldMatrix(conn *sql.DB, cfg *config.Config, logger *logrus.Logger)
 	} else {
 		logger.Infof("Shield Matrix: successfully updated to version %s (DB updated, directories ready)", remoteVersion)
 	}
+	res.NewVersion = remoteVersion
+	res.Status = StatusSuccess
+	return res
 }
 
 // DownloadShieldMatrixFile загружает один файл Shield Matrix по запросу
 // Используется в HTTP обработчике когда Kerio Control запрашивает файл
 // cloudFrontURL - базовый URL CloudFront для скачивания файлов
-func DownloadShieldMatrixFile(subpath string, cloudFrontURL string, cfg *config.Config, logger *logrus.Logger) error {
+func DownloadShieldMatrixFile(ctx context.Context, subpath string, cloudFrontURL string, cfg *config.Config, logger *logrus.Logger) error {
 	// Формируем URL для загрузки
 	// cloudFrontURL: https://d2akeya8d016xi.cloudfront.net/9.5.0
 	downloadURL := fmt.Sprintf("%s/%s", strings.TrimSuffix(cloudFrontURL, "/"), subpath)
@@ -223,43 +165,19 @@ func DownloadShieldMatrixFile(subpath string, cloudFrontURL string, cfg *config.
 	logger.Infof("Shield Matrix: initiating on-demand download for: %s", subpath)
 	logger.Debugf("Shield Matrix: download URL: %s", downloadURL)
 
-	resp, err := utils.HTTPGetWithRetry(downloadURL, cfg.RetryCount, time.Duration(cfg.RetryDelaySeconds)*time.Second, cfg.ProxyURL)
-	if err != nil {
-		logger.Errorf("Shield Matrix: download failed for %s: %v", subpath, err)
-		return fmt.Errorf("download failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	logger.Debugf("Shield Matrix: download response status: %d for %s", resp.StatusCode, subpath)
-
-	if resp.StatusCode != 200 {
-		logger.Errorf("Shield Matrix: bad status code %d for %s", resp.StatusCode, subpath)
-		return fmt.Errorf("bad status code: %d", resp.StatusCode)
-	}
-
 	// Определяем путь для сохранения
 	savePath := filepath.Join("mirror", "matrix", subpath)
 	logger.Debugf("Shield Matrix: saving to: %s", savePath)
 
-	// Создаём директорию если нужно
-	dirPath := filepath.Dir(savePath)
-	if err := os.MkdirAll(dirPath, 0755); err != nil {
-		logger.Errorf("Shield Matrix: failed to create directory %s: %v", dirPath, err)
-		return fmt.Errorf("failed to create directory: %w", err)
-	}
-
-	// Сохраняем файл
-	out, err := os.Create(savePath)
-	if err != nil {
-		logger.Errorf("Shield Matrix: failed to create file %s: %v", savePath, err)
-		return fmt.Errorf("failed to create file: %w", err)
+	// Файл пишется во временный, проверяется и переименовывается, клиенты не увидят обрезанный файл
+	ctx = UpstreamContext(ctx, cfg, ComponentShieldMatrix)
+	if err := utils.DownloadFile(ctx, downloadURL, savePath, cfg.ProxyURL, cfg.RetryCount, time.Duration(cfg.RetryDelaySeconds)*time.Second); err != nil {
+		logger.Errorf("Shield Matrix: download failed for %s: %v", subpath, err)
+		return fmt.Errorf("download failed: %w", err)
 	}
-	defer out.Close()
-
-	written, err := io.Copy(out, resp.Body)
-	if err != nil {
-		logger.Errorf("Shield Matrix: failed to save file %s: %v", savePath, err)
-		return fmt.Errorf("failed to save file: %w", err)
+	var written int64
+	if fi, err := os.Stat(savePath); err == nil {
+		written = fi.Size()
 	}
 
 	logger.Infof("Shield Matrix: successfully downloaded %s (%d bytes) -> %s", subpath, written, savePath)
@@ -304,9 +222,11 @@ func checkShieldMatrixFilesExist(logger *logrus.Logger) bool {
 // PreloadShieldMatrixFiles загружает все файлы Shield Matrix заранее (по расписанию)
 // Скачивает файлы threat_data_1.dat до threat_data_5.dat для IPv4 и IPv6
 // cloudFrontURL - базовый URL CloudFront для скачивания файлов
-func PreloadShieldMatrixFiles(cloudFrontURL string, cfg *config.Config, logger *logrus.Logger) {
+// Возвращает пути скачанных файлов относительно mirror/matrix
+func PreloadShieldMatrixFiles(ctx context.Context, cloudFrontURL string, cfg *config.Config, logger *logrus.Logger) []string {
 	logger.Info("Shield Matrix: starting preload of all files...")
 
+	var downloaded []string
 	totalFiles := 0
 	ipv4Files := 0
 	ipv6Files := 0
@@ -315,29 +235,142 @@ func PreloadShieldMatrixFiles(cloudFrontURL string, cfg *config.Config, logger *
 	logger.Debug("Shield Matrix: preloading IPv4 threat data files...")
 	for i := 1; i <= 5; i++ { // Shield Matrix использует файлы threat_data_1.dat до threat_data_5.dat
 		subpath := fmt.Sprintf("ipv4/threat_data_%d.dat", i)
-		err := DownloadShieldMatrixFile(subpath, cloudFrontURL, cfg, logger)
+		err := DownloadShieldMatrixFile(ctx, subpath, cloudFrontURL, cfg, logger)
 		if err != nil {
 			// Если получили ошибку (скорее всего 404), прекращаем загрузку IPv4
 			logger.Debugf("Shield Matrix: stopped IPv4 preload at file %d (error: %v)", i, err)
 			break
 		}
+		downloaded = append(downloaded, subpath)
 		ipv4Files++
 		totalFiles++
 	}
 
 	// Загрузка IPv6 файлов
 	logger.Debug("Shield Matrix: preloading IPv6 threat data files...")
-	for i := 1; i <= 5; i++ { // Shield Matrix использует файлы threat_data_1.dat до threat_data_5.dat
+	for i := 1; i <= 5 && ctx.Err() == nil; i++ { // Shield Matrix использует файлы threat_data_1.dat до threat_data_5.dat
 		subpath := fmt.Sprintf("ipv6/threat_data_%d.dat", i)
-		err := DownloadShieldMatrixFile(subpath, cloudFrontURL, cfg, logger)
+		err := DownloadShieldMatrixFile(ctx, subpath, cloudFrontURL, cfg, logger)
 		if err != nil {
 			// Если получили ошибку (скорее всего 404), прекращаем загрузку IPv6
 			logger.Debugf("Shield Matrix: stopped IPv6 preload at file %d (error: %v)", i, err)
 			break
 		}
+		downloaded = append(downloaded, subpath)
 		ipv6Files++
 		totalFiles++
 	}
 
 	logger.Infof("Shield Matrix: preload completed - %d files total (IPv4: %d, IPv6: %d)", totalFiles, ipv4Files, ipv6Files)
+	return downloaded
+}
+
+// checkShieldMatrixRemote asks check_update whether an update is available and reads its version
+// from the CloudFront /version endpoint. An empty CloudFront URL means no update is available.
+// This is the version-discovery half of UpdateShieldMatrix and does not touch the disk or the DB.
+func checkShieldMatrixRemote(ctx context.Context, cfg *config.Config, logger *logrus.Logger) (string, string, error) {
+	// Шаг 1: Проверяем наличие обновлений через check_update endpoint
+	// Формируем URL: https://shieldmatrix-updates.gfikeriocontrol.com/check_update/?client-id=control&version=9.5.0&last-update=0
+	checkUpdateURL := fmt.Sprintf("%s?client-id=%s&version=%s&last-update=0",
+		strings.TrimSuffix(cfg.ShieldMatrixBaseURL, "/"),
+		cfg.ShieldMatrixClientID,
+		cfg.ShieldMatrixVersion)
+	logger.Debugf("Shield Matrix: requesting check_update from: %s", checkUpdateURL)
+
+	// Запрашиваем информацию об обновлениях
+	resp, err := utils.HTTPGetWithRetry(ctx, checkUpdateURL, cfg.RetryCount, time.Duration(cfg.RetryDelaySeconds)*time.Second, cfg.ProxyURL)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to check updates: %w", err)
+	}
+	defer resp.Body.Close()
+
+	logger.Debugf("Shield Matrix: check_update response status: %d", resp.StatusCode)
+
+	if resp.StatusCode != 200 {
+		return "", "", fmt.Errorf("bad status code from check_update: %d", resp.StatusCode)
+	}
+
+	// Читаем JSON ответ
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read check_update response: %w", err)
+	}
+
+	logger.Debugf("Shield Matrix: check_update response: %s", string(body))
+
+	// Парсим JSON ответ
+	var checkUpdateResp ShieldMatrixCheckUpdateResponse
+	if err := json.Unmarshal(body, &checkUpdateResp); err != nil {
+		return "", "", fmt.Errorf("failed to parse check_update response: %w", err)
+	}
+
+	// Проверяем доступность обновлений
+	if !checkUpdateResp.Available {
+		return "", "", nil
+	}
+
+	if checkUpdateResp.URL == "" {
+		return "", "", errors.New("update is available but URL is empty")
+	}
+
+	logger.Infof("Shield Matrix: update available, CloudFront URL: %s", checkUpdateResp.URL)
+
+	// Шаг 2: Получаем версию из CloudFront URL
+	// Формируем URL для проверки версии: {CloudFront URL}/version
+	cloudFrontBaseURL := strings.TrimSuffix(checkUpdateResp.URL, "/")
+	versionURL := fmt.Sprintf("%s/version", cloudFrontBaseURL)
+	logger.Debugf("Shield Matrix: requesting version from: %s", versionURL)
+
+	// Запрашиваем версию с CloudFront
+	versionResp, err := utils.HTTPGetWithRetry(ctx, versionURL, cfg.RetryCount, time.Duration(cfg.RetryDelaySeconds)*time.Second, cfg.ProxyURL)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get version from CloudFront: %w", err)
+	}
+	defer versionResp.Body.Close()
+
+	logger.Debugf("Shield Matrix: version response status: %d", versionResp.StatusCode)
+
+	if versionResp.StatusCode != 200 {
+		return "", "", fmt.Errorf("bad status code from version endpoint: %d", versionResp.StatusCode)
+	}
+
+	// Читаем версию
+	versionBody, err := io.ReadAll(versionResp.Body)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read version response: %w", err)
+	}
+
+	return cloudFrontBaseURL, strings.TrimSpace(string(versionBody)), nil
+}
+
+// shieldMatrixUpdater tracks the Shield Matrix version and optionally preloads its files
+type shieldMatrixUpdater struct{}
+
+func (shieldMatrixUpdater) Name() string  { return ComponentShieldMatrix }
+func (shieldMatrixUpdater) Title() string { return "Shield Matrix" }
+
+func (shieldMatrixUpdater) Schedule(cfg *config.Config) string { return cfg.ShieldMatrixSchedule }
+
+func (shieldMatrixUpdater) Enabled(cfg *config.Config) bool { return cfg.EnableShieldMatrix }
+
+func (shieldMatrixUpdater) Check(ctx context.Context, conn *sql.DB, cfg *config.Config, logger *logrus.Logger) []VersionCheck {
+	if cfg.ShieldMatrixBaseURL == "" {
+		return nil
+	}
+	return []VersionCheck{checkShieldMatrix(ctx, conn, cfg, logger)}
+}
+
+func (shieldMatrixUpdater) Apply(ctx context.Context, conn *sql.DB, cfg *config.Config, logger *logrus.Logger) *ComponentResult {
+	return UpdateShieldMatrix(ctx, conn, cfg, logger)
+}
+
+// Status takes LastSuccess only from a successful check: a failed one also stores its time
+func (shieldMatrixUpdater) Status(conn *sql.DB, cfg *config.Config) ComponentStatus {
+	st := ComponentStatus{Version: db.GetShieldMatrixVersion(conn)}
+	success, at, err := db.GetShieldMatrixUpdateStatus(conn)
+	if err == nil && success {
+		st.LastSuccess, _ = db.ParseTime(at)
+	}
+	st.Databases = []DatabaseStatus{{Name: "Shield Matrix", Version: st.Version, Success: success}}
+	return st
 }
@@ -1,7 +1,10 @@
 package mirror
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
+	"io"
 	"os"
 	"path/filepath"
 	"time"
@@ -14,16 +17,17 @@ import (
 )
 
 // downloadSnortTemplate downloads Snort template files for IPS updates (Kerio 9.5)
-// This is called internally as part of IDS5 update process
-func downloadSnortTemplate(conn *sql.DB, cfg *config.Config, logger *logrus.Logger) bool {
+// This is called internally as part of IDS5 update process.
+// snort.tpl is requested conditionally, changed is false if upstream answered 304.
+func downloadSnortTemplate(ctx context.Context, conn *sql.DB, cfg *config.Config, logger *logrus.Logger) (changed, ok bool) {
 	if !cfg.EnableSnortTemplate {
 		logger.Info("IDSv5/Snort: template update is disabled by config")
-		return true // Not an error, just disabled
+		return false, true // Not an error, just disabled
 	}
 
 	if cfg.SnortTemplateURL == "" {
 		logger.Warn("IDSv5/Snort: template URL is not configured")
-		return false
+		return false, false
 	}
 
 	logger.Info("IDSv5/Snort: downloading template files")
@@ -33,34 +37,65 @@ func downloadSnortTemplate(conn *sql.DB, cfg *config.Config, logger *logrus.Logg
 	if err := os.MkdirAll(destDir, 0755); err != nil {
 		logger.Errorf("IDSv5/Snort: failed to create directory %s: %v", destDir, err)
 		db.UpdateSnortTemplateStatus(conn, false, time.Now())
-		return false
+		return false, false
 	}
 
-	// Скачиваем snort.tpl
+	// Сначала snort.tpl.md5: по нему проверяется snort.tpl, а сам он публикуется только после шаблона,
+	// чтобы клиенты не получили новый MD5 к старому файлу
 	snortTplPath := filepath.Join(destDir, "snort.tpl")
-	if !utils.DownloadFileWithProxy(cfg.SnortTemplateURL, snortTplPath, cfg.ProxyURL, cfg.RetryCount, time.Duration(cfg.RetryDelaySeconds)*time.Second, logger) {
-		logger.Error("IDSv5/Snort: failed to download snort.tpl")
-		db.UpdateSnortTemplateStatus(conn, false, time.Now())
-		return false
-	}
-	logger.Info("IDSv5/Snort: snort.tpl downloaded successfully")
-
-	// Скачиваем snort.tpl.md5
 	md5URL := cfg.SnortTemplateURL + ".md5"
 	md5Path := snortTplPath + ".md5"
-	if !utils.DownloadFileWithProxy(md5URL, md5Path, cfg.ProxyURL, cfg.RetryCount, time.Duration(cfg.RetryDelaySeconds)*time.Second, logger) {
-		logger.Warn("IDSv5/Snort: failed to download snort.tpl.md5 (non-critical)")
+	var want utils.Expect
+	md5Data, err := fetchSnortMD5(ctx, cfg, md5URL)
+	if err != nil {
+		logger.Warnf("IDSv5/Snort: failed to download snort.tpl.md5 (non-critical): %v", err)
 		// Не фейлим обновление, если MD5 не загрузился
+	} else if sum, err := utils.ParseMD5File(md5Data); err != nil {
+		logger.Warnf("IDSv5/Snort: snort.tpl.md5 is not usable, template is not verified: %v", err)
 	} else {
-		logger.Info("IDSv5/Snort: snort.tpl.md5 downloaded successfully")
+		want.MD5 = sum
+	}
+
+	// Скачиваем snort.tpl, если он изменился
+	_, statErr := os.Stat(snortTplPath)
+	validators, changed, err := fetchIfChanged(ctx, conn, cfg, logger, cfg.SnortTemplateURL, snortTplPath, statErr == nil, want)
+	if err != nil {
+		logger.Errorf("IDSv5/Snort: failed to download snort.tpl: %v", err)
+		db.UpdateSnortTemplateStatus(conn, false, time.Now())
+		return false, false
+	}
+	if changed {
+		logger.Info("IDSv5/Snort: snort.tpl downloaded successfully")
+		saveValidators(conn, logger, cfg.SnortTemplateURL, validators)
+	} else {
+		logger.Info("IDSv5/Snort: snort.tpl is not modified upstream")
+	}
+
+	_, md5StatErr := os.Stat(md5Path)
+	if md5Data != nil && (changed || md5StatErr != nil) {
+		if err := utils.SaveResponseToFile(io.NopCloser(bytes.NewReader(md5Data)), md5Path); err != nil {
+			logger.Warnf("IDSv5/Snort: failed to save snort.tpl.md5 (non-critical): %v", err)
+		} else {
+			logger.Info("IDSv5/Snort: snort.tpl.md5 downloaded successfully")
+		}
 	}
 
 	// Обновляем статус в БД
 	if err := db.UpdateSnortTemplateStatus(conn, true, time.Now()); err != nil {
 		logger.Errorf("IDSv5/Snort: failed to update status in DB: %v", err)
-		return false
+		return changed, false
 	}
 
 	logger.Info("IDSv5/Snort: template update completed successfully")
-	return true
+	return changed, true
+}
+
+// fetchSnortMD5 downloads snort.tpl.md5 into memory
+func fetchSnortMD5(ctx context.Context, cfg *config.Config, md5URL string) ([]byte, error) {
+	resp, err := utils.HTTPGetWithRetry(ctx, md5URL, cfg.RetryCount, time.Duration(cfg.RetryDelaySeconds)*time.Second, cfg.ProxyURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(io.LimitReader(resp.Body, 4096))
 }
@@ -0,0 +1,266 @@
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"kerio-mirror-go/config"
+	"kerio-mirror-go/utils"
+)
+
+// ErrNoSpace is returned when a download does not fit on the volume of mirror/ or into the component quota
+var ErrNoSpace = errors.New("not enough storage")
+
+// storageDir is where a component keeps its files
+type storageDir struct {
+	path      string
+	recursive bool
+}
+
+// storageDirs lists the directories of the components that store files, Web Filter keeps its key in the DB
+var storageDirs = map[string]storageDir{
+	ComponentIDS:          {"mirror", false}, // файлы IDS лежат прямо в mirror/
+	ComponentGeoIP:        {"mirror/geo", true},
+	ComponentBitdefender:  {"mirror/bitdefender", true},
+	ComponentShieldMatrix: {"mirror/matrix", true},
+	ComponentCustom:       {"mirror/custom", true},
+}
+
+// usageTTL is how long a measured directory size is reused, walking mirror/bitdefender takes a while
+const usageTTL = time.Minute
+
+type dirUsage struct {
+	bytes, files int64
+	at           time.Time
+}
+
+// usageWalk is a measurement in progress, requests that need the same directory wait for it
+type usageWalk struct {
+	done         chan struct{}
+	bytes, files int64
+	err          error
+}
+
+var (
+	usageMu    sync.Mutex
+	usageCache = map[string]dirUsage{}
+	usageWalks = map[string]*usageWalk{}
+	measureDir = utils.DirUsage // подменяется в тестах
+)
+
+// ParseStorageQuotas parses STORAGE_QUOTAS: "bitdefender=20480, geoip=200",
+// component names as in ComponentNames, values in MB (0 - no quota). The result is in bytes.
+func ParseStorageQuotas(spec string) (map[string]int64, error) {
+	quotas := map[string]int64{}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid quota %q, expected component=MB", item)
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := LookupUpdater(name); !ok {
+			return nil, fmt.Errorf("unknown component %q", name)
+		}
+		if _, ok := storageDirs[name]; !ok {
+			return nil, fmt.Errorf("component %q stores no files", name)
+		}
+		mb, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil || mb < 0 {
+			return nil, fmt.Errorf("invalid quota %q for %s", value, name)
+		}
+		quotas[name] = mb << 20
+	}
+	return quotas, nil
+}
+
+// componentUsage returns the size and file count of the component directory, measured at most once per usageTTL.
+// Concurrent callers (parallel requests of the Bitdefender proxy) share one walk of the directory.
+func componentUsage(name string) (int64, int64, error) {
+	dir, ok := storageDirs[name]
+	if !ok {
+		return 0, 0, nil
+	}
+	usageMu.Lock()
+	if u, ok := usageCache[name]; ok && time.Since(u.at) < usageTTL {
+		usageMu.Unlock()
+		return u.bytes, u.files, nil
+	}
+	if w, ok := usageWalks[name]; ok {
+		usageMu.Unlock()
+		<-w.done
+		return w.result()
+	}
+	w := &usageWalk{done: make(chan struct{})}
+	usageWalks[name] = w
+	usageMu.Unlock()
+
+	w.bytes, w.files, w.err = measureDir(dir.path, dir.recursive)
+
+	usageMu.Lock()
+	// После resetUsage обход мог посчитать уже изменённый каталог, такой результат не запоминаем
+	if usageWalks[name] == w {
+		delete(usageWalks, name)
+		if w.err == nil {
+			usageCache[name] = dirUsage{bytes: w.bytes, files: w.files, at: time.Now()}
+		}
+	}
+	usageMu.Unlock()
+	close(w.done)
+	return w.result()
+}
+
+func (w *usageWalk) result() (int64, int64, error) {
+	if w.err != nil {
+		return 0, 0, w.err
+	}
+	return w.bytes, w.files, nil
+}
+
+// addUsage counts a file written to the component directory without measuring it again
+func addUsage(name string, bytes int64) {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	if u, ok := usageCache[name]; ok {
+		u.bytes += bytes
+		u.files++
+		usageCache[name] = u
+	}
+}
+
+// resetUsage drops the measured size of the component, its update may have changed it
+func resetUsage(name string) {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	delete(usageCache, name)
+	delete(usageWalks, name)
+}
+
+// checkSpace checks that need more bytes can be written for the component: the volume of its
+// directory keeps DISK_RESERVE_MB free, and final, the size of the directory after the download
+// is published, fits into the component quota
+func checkSpace(cfg *config.Config, component string, need, final int64) error {
+	dir, ok := storageDirs[component]
+	if !ok {
+		return nil
+	}
+	quotas, _ := ParseStorageQuotas(cfg.StorageQuotas)
+	if quota := quotas[component]; quota > 0 && final > quota {
+		return fmt.Errorf("%w: %s would take %s, quota is %s", ErrNoSpace, ComponentTitle(component),
+			utils.FormatBytes(final), utils.FormatBytes(quota))
+	}
+	free, _, err := utils.DiskSpace(dir.path)
+	if err != nil {
+		// Свободное место узнать не удалось, загрузку из-за этого не останавливаем
+		return nil
+	}
+	reserve := int64(cfg.DiskReserveMB) << 20
+	if need+reserve > 0 && uint64(need+reserve) > free {
+		return fmt.Errorf("%w: %s needed and %s to keep free, %s free on the volume of %s", ErrNoSpace,
+			utils.FormatBytes(need), utils.FormatBytes(reserve), utils.FormatBytes(int64(free)), dir.path)
+	}
+	return nil
+}
+
+// StorageUsage is the disk usage of one component directory
+type StorageUsage struct {
+	Component string `json:"component"`
+	Title     string `json:"title"`
+	Dir       string `json:"dir"`
+	Bytes     int64  `json:"bytes"`
+	Files     int64  `json:"files"`
+	Quota     int64  `json:"quota,omitempty"`   // байты, 0 - без квоты
+	Percent   int    `json:"percent,omitempty"` // занято от квоты
+	Warning   string `json:"warning,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// StorageReport is the disk usage of mirror/ by component and the free space of its volume
+type StorageReport struct {
+	Free       int64          `json:"free"`
+	Total      int64          `json:"total"`
+	Reserve    int64          `json:"reserve"`
+	Components []StorageUsage `json:"components"`
+	Warnings   []string       `json:"warnings,omitempty"`
+	Error      string         `json:"error,omitempty"`
+
+	warningKeys []string // что именно не так, без цифр: "bitdefender:quota", "disk"
+}
+
+// Storage measures the component directories against their quotas and the free space against DISK_RESERVE_MB.
+// A directory that takes STORAGE_WARN_PERCENT of its quota or more gets a warning.
+func Storage(cfg *config.Config) StorageReport {
+	report := StorageReport{Reserve: int64(cfg.DiskReserveMB) << 20, Components: []StorageUsage{}}
+	quotas, _ := ParseStorageQuotas(cfg.StorageQuotas)
+	warnPercent := cfg.StorageWarnPercent
+	if warnPercent <= 0 || warnPercent > 100 {
+		warnPercent = 90
+	}
+	for _, name := range ComponentNames() {
+		dir, ok := storageDirs[name]
+		if !ok {
+			continue
+		}
+		u := StorageUsage{Component: name, Title: ComponentTitle(name), Dir: dir.path, Quota: quotas[name]}
+		bytes, files, err := componentUsage(name)
+		if err != nil {
+			u.Error = err.Error()
+			report.Components = append(report.Components, u)
+			continue
+		}
+		u.Bytes, u.Files = bytes, files
+		if u.Quota > 0 {
+			u.Percent = int(u.Bytes * 100 / u.Quota)
+			switch {
+			case u.Bytes > u.Quota:
+				u.Warning = fmt.Sprintf("%s is over its quota: %s of %s", u.Title, utils.FormatBytes(u.Bytes), utils.FormatBytes(u.Quota))
+				report.warningKeys = append(report.warningKeys, name+":over")
+			case u.Percent >= warnPercent:
+				u.Warning = fmt.Sprintf("%s uses %d%% of its quota: %s of %s", u.Title, u.Percent, utils.FormatBytes(u.Bytes), utils.FormatBytes(u.Quota))
+				report.warningKeys = append(report.warningKeys, name+":quota")
+			}
+			if u.Warning != "" {
+				report.Warnings = append(report.Warnings, u.Warning)
+			}
+		}
+		report.Components = append(report.Components, u)
+	}
+	free, total, err := utils.DiskSpace("mirror")
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	report.Free, report.Total = int64(free), int64(total)
+	if report.Reserve > 0 && report.Free < report.Reserve {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("Low disk space: %s free on the mirror volume, %s should stay free",
+			utils.FormatBytes(report.Free), utils.FormatBytes(report.Reserve)))
+		report.warningKeys = append(report.warningKeys, "disk")
+	}
+	return report
+}
+
+var (
+	warningsMu       sync.Mutex
+	notifiedWarnings string // warningKeys последнего отчёта о месте, уже отправленного в Telegram
+)
+
+// newWarnings reports whether the storage warnings of the report differ from the ones already sent
+// and remembers them. The sizes in the messages change on every run, so the warnings are compared by
+// what is wrong (a quota, the free space), not by their text.
+func newWarnings(report StorageReport) bool {
+	keys := strings.Join(report.warningKeys, ",")
+	warningsMu.Lock()
+	defer warningsMu.Unlock()
+	if keys == notifiedWarnings {
+		return false
+	}
+	notifiedWarnings = keys
+	return keys != ""
+}
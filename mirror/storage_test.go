@@ -0,0 +1,217 @@
+package mirror
+
+import (
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"kerio-mirror-go/config"
+	"kerio-mirror-go/utils"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestParseStorageQuotas(t *testing.T) {
+	tests := []struct {
+		spec     string
+		expected map[string]int64
+		ok       bool
+	}{
+		{"", map[string]int64{}, true},
+		{"bitdefender=20480, geoip=200", map[string]int64{"bitdefender": 20480 << 20, "geoip": 200 << 20}, true},
+		{"IDS = 0", map[string]int64{"ids": 0}, true},
+		{"bitdefender", nil, false},
+		{"unknown=100", nil, false},
+		{"webfilter=100", nil, false}, // ключ Web Filter хранится в БД
+		{"geoip=-1", nil, false},
+		{"geoip=1GB", nil, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseStorageQuotas(tt.spec)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParseStorageQuotas(%q): expected ok=%v, got error %v", tt.spec, tt.ok, err)
+			continue
+		}
+		if !tt.ok {
+			continue
+		}
+		if len(got) != len(tt.expected) {
+			t.Errorf("ParseStorageQuotas(%q): expected %v, got %v", tt.spec, tt.expected, got)
+		}
+		for k, v := range tt.expected {
+			if got[k] != v {
+				t.Errorf("ParseStorageQuotas(%q): expected %s=%d, got %d", tt.spec, k, v, got[k])
+			}
+		}
+	}
+}
+
+// writeStorageFile creates a file of size bytes in the working directory of the test
+func writeStorageFile(t *testing.T, path string, size int) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStorage_Quotas(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Cleanup(func() { resetUsage(ComponentGeoIP); resetUsage(ComponentIDS); resetUsage(ComponentCustom) })
+	writeStorageFile(t, "mirror/geo/GeoLite2-Country-Blocks-IPv4.csv", 950<<10)
+	writeStorageFile(t, "mirror/ids.gz", 100<<10)
+	writeStorageFile(t, "mirror/custom/snort.tpl", 10<<10)
+	resetUsage(ComponentGeoIP)
+	resetUsage(ComponentIDS)
+	resetUsage(ComponentCustom)
+
+	cfg := &config.Config{StorageQuotas: "geoip=1,custom=1", StorageWarnPercent: 90}
+	report := Storage(cfg)
+	byName := map[string]StorageUsage{}
+	for _, u := range report.Components {
+		byName[u.Component] = u
+	}
+	// Файлы IDS лежат прямо в mirror/, подкаталоги других компонентов не считаются
+	if ids := byName[ComponentIDS]; ids.Bytes != 100<<10 || ids.Files != 1 {
+		t.Errorf("Expected IDS to count only mirror/ids.gz, got %+v", ids)
+	}
+	if geo := byName[ComponentGeoIP]; geo.Percent != 92 || geo.Warning == "" {
+		t.Errorf("Expected GeoIP warning at 92%% of its quota, got %+v", geo)
+	}
+	if custom := byName[ComponentCustom]; custom.Warning != "" {
+		t.Errorf("Expected no warning for custom files, got %q", custom.Warning)
+	}
+	if len(report.Warnings) != 1 || !strings.Contains(report.Warnings[0], "GeoIP") {
+		t.Errorf("Expected a single GeoIP warning, got %v", report.Warnings)
+	}
+	if _, ok := byName[ComponentWebFilter]; ok {
+		t.Errorf("Expected Web Filter to have no storage")
+	}
+}
+
+func TestNewWarnings(t *testing.T) {
+	t.Cleanup(func() { notifiedWarnings = "" })
+	notifiedWarnings = ""
+	quota := StorageReport{Warnings: []string{"GeoIP uses 92% of its quota"}, warningKeys: []string{"geoip:quota"}}
+	grown := StorageReport{Warnings: []string{"GeoIP uses 95% of its quota"}, warningKeys: []string{"geoip:quota"}}
+	over := StorageReport{Warnings: []string{"GeoIP is over its quota"}, warningKeys: []string{"geoip:over"}}
+
+	tests := []struct {
+		name     string
+		report   StorageReport
+		expected bool
+	}{
+		{"first warning", quota, true},
+		{"same warning with other sizes", grown, false},
+		{"quota exceeded", over, true},
+		{"warnings gone", StorageReport{}, false},
+		{"warning back", quota, true},
+	}
+	for _, tt := range tests {
+		if got := newWarnings(tt.report); got != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestComponentUsage_SharedWalk(t *testing.T) {
+	var walks atomic.Int32
+	release := make(chan struct{})
+	measureDir = func(dir string, recursive bool) (int64, int64, error) {
+		walks.Add(1)
+		<-release
+		return 100, 1, nil
+	}
+	t.Cleanup(func() {
+		measureDir = utils.DirUsage
+		resetUsage(ComponentBitdefender)
+	})
+	resetUsage(ComponentBitdefender)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if bytes, files, err := componentUsage(ComponentBitdefender); bytes != 100 || files != 1 || err != nil {
+				t.Errorf("Expected 100 bytes in 1 file, got %d in %d (%v)", bytes, files, err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := walks.Load(); n != 1 {
+		t.Errorf("Expected concurrent requests to share one walk, got %d", n)
+	}
+}
+
+func TestCheckSpace(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cfg := &config.Config{StorageQuotas: "bitdefender=10"}
+	if err := checkSpace(cfg, ComponentBitdefender, 5<<20, 5<<20); err != nil {
+		t.Errorf("Expected 5 MB to fit into a 10 MB quota, got %v", err)
+	}
+	if err := checkSpace(cfg, ComponentBitdefender, 1<<20, 11<<20); !errors.Is(err, ErrNoSpace) {
+		t.Errorf("Expected ErrNoSpace over the quota, got %v", err)
+	}
+	// Запас больше любого тома
+	cfg = &config.Config{DiskReserveMB: math.MaxInt32}
+	if err := checkSpace(cfg, ComponentBitdefender, 1, 1); !errors.Is(err, ErrNoSpace) {
+		t.Errorf("Expected ErrNoSpace without the disk reserve, got %v", err)
+	}
+	if err := checkSpace(cfg, ComponentWebFilter, 1, 1); err != nil {
+		t.Errorf("Expected no check for a component without storage, got %v", err)
+	}
+}
+
+func TestCheckBitdefenderSpace(t *testing.T) {
+	t.Chdir(t.TempDir())
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	// Опубликованная версия: 2 файла по 2 МБ, в новой 6 файлов - около 12 МБ
+	writeStorageFile(t, "mirror/bitdefender/av64bit_1/avx/a.gzip", 2<<20)
+	writeStorageFile(t, "mirror/bitdefender/av64bit_1/avx/b.gzip", 2<<20)
+	var dat strings.Builder
+	for i := 0; i < 6; i++ {
+		dat.WriteString("0 sha file\n")
+	}
+	dat.WriteString("header\n")
+	if err := os.MkdirAll("mirror/bitdefender_tmp/av64bit_2", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("mirror/bitdefender_tmp/av64bit_2/versions.dat", []byte(dat.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{StorageQuotas: "bitdefender=16"}
+	if err := checkBitdefenderSpace("mirror/bitdefender_tmp", "mirror/bitdefender", 2, cfg, logger); err != nil {
+		t.Errorf("Expected 4 + 12 MB to fit into a 16 MB quota, got %v", err)
+	}
+	cfg.StorageQuotas = "bitdefender=10"
+	if err := checkBitdefenderSpace("mirror/bitdefender_tmp", "mirror/bitdefender", 2, cfg, logger); !errors.Is(err, ErrNoSpace) {
+		t.Errorf("Expected ErrNoSpace for 4 + 12 MB with a 10 MB quota, got %v", err)
+	}
+
+	// Сохранённая старая версия тоже занимает квоту: 8 МБ уже в каталоге и 12 МБ новой версии
+	writeStorageFile(t, "mirror/bitdefender/av64bit_0/avx/a.gzip", 2<<20)
+	writeStorageFile(t, "mirror/bitdefender/av64bit_0/avx/b.gzip", 2<<20)
+	cfg.StorageQuotas = "bitdefender=16"
+	if err := checkBitdefenderSpace("mirror/bitdefender_tmp", "mirror/bitdefender", 2, cfg, logger); !errors.Is(err, ErrNoSpace) {
+		t.Errorf("Expected ErrNoSpace for 20 MB with a 16 MB quota, got %v", err)
+	}
+	cfg.StorageQuotas = "bitdefender=20"
+	if err := checkBitdefenderSpace("mirror/bitdefender_tmp", "mirror/bitdefender", 2, cfg, logger); err != nil {
+		t.Errorf("Expected 20 MB to fit into a 20 MB quota, got %v", err)
+	}
+}
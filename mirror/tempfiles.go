@@ -0,0 +1,49 @@
+package mirror
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// CleanupTemp removes what interrupted downloads leave under root: "*.tmp" files
+// (utils.AtomicFile, the Bitdefender proxy cache), "geo/*.download" files (raw GeoIP CSVs
+// not yet processed) and "*_tmp" directories (bitdefender_tmp).
+// It must not run while an update or a proxy download is in progress. Returns the number of removed entries.
+func CleanupTemp(root string, logger *logrus.Logger) (int, error) {
+	var targets []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == root {
+				return filepath.SkipAll
+			}
+			return err
+		}
+		switch {
+		case d.IsDir() && path != root && strings.HasSuffix(d.Name(), "_tmp"):
+			targets = append(targets, path)
+			return filepath.SkipDir
+		case !d.IsDir() && strings.HasSuffix(d.Name(), ".tmp"):
+			targets = append(targets, path)
+		case !d.IsDir() && strings.HasSuffix(d.Name(), ".download") && filepath.Base(filepath.Dir(path)) == "geo":
+			targets = append(targets, path)
+		}
+		return nil
+	})
+	removed := 0
+	for _, path := range targets {
+		if rmErr := os.RemoveAll(path); rmErr != nil {
+			logger.Warnf("Failed to remove temp %s: %v", path, rmErr)
+			if err == nil {
+				err = rmErr
+			}
+			continue
+		}
+		logger.Infof("Removed temp %s", path)
+		removed++
+	}
+	return removed, err
+}
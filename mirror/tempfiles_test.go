@@ -0,0 +1,56 @@
+package mirror
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestCleanupTemp(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]bool{
+		"ids/full-1-123.gz":                   false,
+		"ids/full-1-123.gz.tmp":               true,
+		"bitdefender/v1/bitdefender_42.tmp":   true,
+		"bitdefender/v1/versions.id":          false,
+		"bitdefender_tmp/v1/versions.dat.zip": true,
+		"geo/locations.csv":                   false,
+		"geo/v4.csv.download":                 true,
+		"custom/setup.download":               false, // имя файла с upstream
+	}
+	for name := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("MkdirAll failed: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	removed, err := CleanupTemp(root, logger)
+	if err != nil {
+		t.Fatalf("CleanupTemp failed: %v", err)
+	}
+	if removed != 4 {
+		t.Errorf("Expected 4 removed entries, got %d", removed)
+	}
+	for name, temp := range files {
+		_, err := os.Stat(filepath.Join(root, name))
+		if exists := err == nil; exists == temp {
+			t.Errorf("%s: expected exists=%v", name, !temp)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "bitdefender_tmp")); !os.IsNotExist(err) {
+		t.Error("Expected bitdefender_tmp to be removed")
+	}
+
+	if _, err := CleanupTemp(filepath.Join(root, "missing"), logger); err != nil {
+		t.Errorf("Expected no error for a missing root, got %v", err)
+	}
+}
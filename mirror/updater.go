@@ -0,0 +1,130 @@
+package mirror
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"kerio-mirror-go/config"
+	"kerio-mirror-go/db"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Updater is a single mirrored source (IDS, GeoIP, Bitdefender...).
+// The scheduler, the runner, check-only mode, the dashboard and the API work only through this interface,
+// so a new source is added by implementing it and listing it in the registry (or calling Register).
+type Updater interface {
+	// Name is the component name used in the DB, schedules and the API
+	Name() string
+	// Title is the human readable name for the UI and notifications
+	Title() string
+	// Schedule returns the own schedule spec of the component, "" means cfg.ScheduleTime
+	Schedule(cfg *config.Config) string
+	// Enabled reports whether the component has anything to do with the current config
+	Enabled(cfg *config.Config) bool
+	// Check compares local and upstream versions without downloading or writing anything.
+	// It returns nil if there is nothing to check.
+	Check(ctx context.Context, conn *sql.DB, cfg *config.Config, logger *logrus.Logger) []VersionCheck
+	// Apply downloads and publishes new data and returns the result of the run
+	Apply(ctx context.Context, conn *sql.DB, cfg *config.Config, logger *logrus.Logger) *ComponentResult
+	// Status returns the published state of the component as stored in the DB.
+	// Component, Title and Enabled are filled in by ComponentStatuses.
+	Status(conn *sql.DB, cfg *config.Config) ComponentStatus
+}
+
+// ComponentStatus is the published state of a component
+type ComponentStatus struct {
+	Component   string           `json:"component"`
+	Title       string           `json:"title"`
+	Enabled     bool             `json:"enabled"`
+	Version     string           `json:"version"`
+	LastSuccess time.Time        `json:"last_success"`        // когда данные последний раз были актуальны, zero - никогда
+	OnDemand    bool             `json:"on_demand,omitempty"` // данные берутся у upstream по запросу клиента и не устаревают
+	Databases   []DatabaseStatus `json:"databases,omitempty"` // базы, которые учитываются в здоровье системы
+}
+
+// DatabaseStatus is the state of a single database published by a component
+type DatabaseStatus struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Success bool   `json:"success"`
+}
+
+var (
+	registryMu sync.RWMutex
+	// registry lists updaters in the order Update runs them
+	registry = []Updater{
+		idsUpdater{},
+		geoIPUpdater{},
+		webFilterUpdater{},
+		bitdefenderUpdater{},
+		shieldMatrixUpdater{},
+		customUpdater{},
+	}
+)
+
+// Register adds an updater to the end of the registry.
+// It panics if an updater with the same name is already registered.
+func Register(u Updater) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	for _, r := range registry {
+		if r.Name() == u.Name() {
+			panic(fmt.Sprintf("mirror: updater %q is already registered", u.Name()))
+		}
+	}
+	registry = append(registry, u)
+}
+
+// Updaters returns all registered updaters in run order
+func Updaters() []Updater {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return append([]Updater(nil), registry...)
+}
+
+// LookupUpdater returns the updater of the component
+func LookupUpdater(name string) (Updater, bool) {
+	for _, u := range Updaters() {
+		if u.Name() == name {
+			return u, true
+		}
+	}
+	return nil, false
+}
+
+// ComponentStatuses returns the published state of every registered component
+func ComponentStatuses(conn *sql.DB, cfg *config.Config) []ComponentStatus {
+	var statuses []ComponentStatus
+	for _, u := range Updaters() {
+		statuses = append(statuses, componentStatus(conn, cfg, u))
+	}
+	return statuses
+}
+
+// componentStatus calls u.Status and fills in the common fields
+func componentStatus(conn *sql.DB, cfg *config.Config, u Updater) ComponentStatus {
+	st := u.Status(conn, cfg)
+	st.Component = u.Name()
+	st.Title = u.Title()
+	st.Enabled = u.Enabled(cfg)
+	return st
+}
+
+// lastSuccessStatus is the status of components that keep no version and no own timestamp:
+// their freshness is the last successful run of the component in the run history,
+// or the time of the last full update run if the history has none.
+func lastSuccessStatus(conn *sql.DB, component string) ComponentStatus {
+	var st ComponentStatus
+	if at, err := db.GetComponentLastSuccess(conn, component); err == nil && at != "" {
+		st.LastSuccess, _ = db.ParseTime(at)
+		return st
+	}
+	if at, err := db.GetLastUpdate(conn); err == nil {
+		st.LastSuccess, _ = db.ParseTime(at)
+	}
+	return st
+}
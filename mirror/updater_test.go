@@ -0,0 +1,151 @@
+package mirror
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"kerio-mirror-go/config"
+	"kerio-mirror-go/db"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fakeUpdater is a minimal third-party source used to check that the registry is the only place to plug it in
+type fakeUpdater struct {
+	applied *int
+}
+
+func (fakeUpdater) Name() string                       { return "fake" }
+func (fakeUpdater) Title() string                      { return "Fake source" }
+func (fakeUpdater) Schedule(cfg *config.Config) string { return "@hourly" }
+func (fakeUpdater) Enabled(cfg *config.Config) bool    { return true }
+
+func (fakeUpdater) Check(ctx context.Context, conn *sql.DB, cfg *config.Config, logger *logrus.Logger) []VersionCheck {
+	return []VersionCheck{{Component: "fake", Name: "Fake", Local: "1", Remote: "2", UpdateAvailable: true}}
+}
+
+func (f fakeUpdater) Apply(ctx context.Context, conn *sql.DB, cfg *config.Config, logger *logrus.Logger) *ComponentResult {
+	*f.applied++
+	res := newResult("fake")
+	res.OldVersion, res.NewVersion = "1", "2"
+	return res
+}
+
+func (fakeUpdater) Status(conn *sql.DB, cfg *config.Config) ComponentStatus {
+	return ComponentStatus{Version: "2", Databases: []DatabaseStatus{{Name: "Fake", Version: "2", Success: true}}}
+}
+
+func TestRegisterUpdater(t *testing.T) {
+	saved := Updaters()
+	t.Cleanup(func() {
+		registryMu.Lock()
+		registry = saved
+		registryMu.Unlock()
+	})
+
+	applied := 0
+	Register(fakeUpdater{applied: &applied})
+
+	names := ComponentNames()
+	if names[len(names)-1] != "fake" {
+		t.Errorf("Expected fake to be the last component, got %v", names)
+	}
+	cfg := &config.Config{ScheduleTime: "03:00"}
+	if got := ComponentTitle("fake"); got != "Fake source" {
+		t.Errorf("Expected title 'Fake source', got '%s'", got)
+	}
+	if got := ComponentSchedule(cfg, "fake"); got != "@hourly" {
+		t.Errorf("Expected schedule '@hourly', got '%s'", got)
+	}
+	if !ComponentEnabled(cfg, "fake") {
+		t.Error("Expected fake to be enabled")
+	}
+	if ComponentEnabled(cfg, "unknown") {
+		t.Error("Expected unknown component to be disabled")
+	}
+
+	dbPath := filepath.Join(t.TempDir(), "mirror.db")
+	if err := db.Init(dbPath); err != nil {
+		t.Fatalf("DB init failed: %v", err)
+	}
+	conn, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("DB open failed: %v", err)
+	}
+	defer conn.Close()
+	cfg.DatabasePath = dbPath
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	res := runComponent(context.Background(), conn, cfg, logger, "fake")
+	if applied != 1 || res.Status != StatusSuccess || res.Title != "Fake source" {
+		t.Errorf("Expected one successful Apply, got %d applies and status %s", applied, res.Status)
+	}
+
+	statuses := ComponentStatuses(conn, cfg)
+	last := statuses[len(statuses)-1]
+	if last.Component != "fake" || last.Title != "Fake source" || !last.Enabled || last.Version != "2" {
+		t.Errorf("Expected filled status of fake, got %+v", last)
+	}
+
+	report := Check(context.Background(), cfg, logger)
+	if report.Pending() != 1 || report.Items[len(report.Items)-1].Component != "fake" {
+		t.Errorf("Expected fake in the check report, got %+v", report.Items)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected panic on duplicate registration")
+		}
+	}()
+	Register(fakeUpdater{applied: &applied})
+}
+
+func TestIDSDatabases(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "mirror.db")
+	if err := db.Init(dbPath); err != nil {
+		t.Fatalf("DB init failed: %v", err)
+	}
+	conn, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("DB open failed: %v", err)
+	}
+	defer conn.Close()
+
+	cfg := &config.Config{EnableIDS2: true, EnableIDS4: true}
+	got := IDSDatabases(conn, cfg)
+	var versions []string
+	for _, d := range got {
+		versions = append(versions, d.Version)
+	}
+	if fmt.Sprint(versions) != "[1 2 3 4 5]" {
+		t.Fatalf("Expected IDS versions [1 2 3 4 5], got %v", versions)
+	}
+	for _, d := range got {
+		enabled := d.Version == "2" || d.Version == "4"
+		if d.Enabled != enabled {
+			t.Errorf("IDSv%s: expected enabled %v, got %v", d.Version, enabled, d.Enabled)
+		}
+	}
+	if got[3].Component != ComponentGeoIP || !got[4].SnortTemplate {
+		t.Errorf("Expected IDSv4 from GeoIP and the Snort template with IDSv5, got %+v", got)
+	}
+
+	// Версии незарегистрированного компонента не показываются
+	saved := Updaters()
+	t.Cleanup(func() {
+		registryMu.Lock()
+		registry = saved
+		registryMu.Unlock()
+	})
+	registryMu.Lock()
+	registry = []Updater{idsUpdater{}}
+	registryMu.Unlock()
+	if got := IDSDatabases(conn, cfg); len(got) != 4 {
+		t.Errorf("Expected 4 IDS versions without GeoIP, got %+v", got)
+	}
+}
@@ -1,7 +1,9 @@
 package mirror
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io"
 	"time"
@@ -14,75 +16,87 @@ import (
 )
 
 // UpdateWebFilterKey implements the python logic for fetching and storing the Web Filter key
-func UpdateWebFilterKey(conn *sql.DB, cfg *config.Config, logger *logrus.Logger) {
+func UpdateWebFilterKey(ctx context.Context, conn *sql.DB, cfg *config.Config, logger *logrus.Logger) *ComponentResult {
+	res := newResult(ComponentWebFilter)
 	if cfg.LicenseNumber == "" {
 		logger.Infof("Web Filter: passing because license key is not configured")
-		return
+		return res.skip("license key is not configured")
 	}
 
 	key, err := db.GetWebfilterKey(conn, cfg.LicenseNumber)
 	if err != nil {
-		logger.Errorf("Web Filter: DB error: %v", err)
-		return
+		res.failf(logger, "web filter: DB error: %w", err)
+		return res.finish(ctx)
 	}
 	if key != "" {
 		logger.Infof("Web Filter: database already contains an actual Web Filter key")
-		return
+		res.Message = "key is already stored"
+		return res.finish(ctx)
 	}
 
 	logger.Info("Fetching new Web Filter key from wf-activation.kerio.com server")
 	url := "https://wf-activation.kerio.com/getkey.php?id=" + cfg.LicenseNumber + "&tag="
 
-	// Try direct, then proxy if set
-	attempts := []struct {
-		desc  string
-		proxy string
-	}{
-		{"without proxy", ""},
+	// Напрямую или через прокси - решает политика прокси (по умолчанию "webfilter: fallback")
+	resp, err := utils.HTTPGetWithRetry(ctx, url, cfg.RetryCount, time.Duration(cfg.RetryDelaySeconds)*time.Second, cfg.ProxyURL)
+	if err != nil {
+		res.failf(logger, "web filter: error fetching Web Filter key: %w", err)
+		return res.finish(ctx)
 	}
-	if cfg.ProxyURL != "" {
-		attempts = append(attempts, struct{ desc, proxy string }{"with proxy", cfg.ProxyURL})
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		res.failf(logger, "web filter: read body error: %w", err)
+		return res.finish(ctx)
 	}
-
-	for _, att := range attempts {
-		resp, err := utils.HTTPGetWithRetry(url, cfg.RetryCount, time.Duration(cfg.RetryDelaySeconds)*time.Second, att.proxy)
-		if err != nil {
-			logger.Warnf("Error fetching Web Filter key %s: %v", att.desc, err)
-			continue
-		}
-		defer resp.Body.Close()
-		data, err := io.ReadAll(resp.Body)
+	text := string(data)
+	if contains(text, "Invalid product license") {
+		msg := fmt.Sprintf("Web Filter: invalid license key. %s", cfg.LicenseNumber)
+		logger.Warn(msg)
+		cfg.LicenseNumber = ""
+		return res.failed(errors.New("web filter: invalid license key"))
+	}
+	if contains(text, "Product Software Maintenance expired") {
+		msg := fmt.Sprintf("Web Filter: license key expired. %s", cfg.LicenseNumber)
+		logger.Warn(msg)
+		cfg.LicenseNumber = ""
+		return res.failed(errors.New("web filter: license key expired"))
+	}
+	if text != "" {
+		err = db.AddWebfilterKey(conn, cfg.LicenseNumber, text)
 		if err != nil {
-			logger.Errorf("Web Filter: read body error: %v", err)
-			continue
-		}
-		text := string(data)
-		if resp.StatusCode != 200 {
-			logger.Warnf("Web Filter: bad status: %d", resp.StatusCode)
-			continue
-		}
-		if contains(text, "Invalid product license") {
-			msg := fmt.Sprintf("Web Filter: invalid license key. %s", cfg.LicenseNumber)
-			logger.Warn(msg)
-			cfg.LicenseNumber = ""
-			return
-		}
-		if contains(text, "Product Software Maintenance expired") {
-			msg := fmt.Sprintf("Web Filter: license key expired. %s", cfg.LicenseNumber)
-			logger.Warn(msg)
-			cfg.LicenseNumber = ""
-			return
-		}
-		if text != "" {
-			err = db.AddWebfilterKey(conn, cfg.LicenseNumber, text)
-			if err != nil {
-				logger.Errorf("Web Filter: failed to save key: %v", err)
-				return
-			}
-			msg := fmt.Sprintf("Web Filter: received new key - %s", text)
-			logger.Info(msg)
-			return
+			res.failf(logger, "web filter: failed to save key: %w", err)
+			return res.finish(ctx)
 		}
+		msg := fmt.Sprintf("Web Filter: received new key - %s", text)
+		logger.Info(msg)
+		res.Message = "received new key"
+		res.Status = StatusSuccess
+		return res
 	}
-	logger.Error("Web Filter: error fetching Web Filter key")
+	res.failf(logger, "web filter: empty Web Filter key response")
+	return res.finish(ctx)
+}
+
+// webFilterUpdater fetches the Web Filter key once per license number
+type webFilterUpdater struct{}
+
+func (webFilterUpdater) Name() string  { return ComponentWebFilter }
+func (webFilterUpdater) Title() string { return "Web Filter" }
+
+func (webFilterUpdater) Schedule(cfg *config.Config) string { return cfg.WebFilterSchedule }
+
+func (webFilterUpdater) Enabled(cfg *config.Config) bool { return cfg.LicenseNumber != "" }
+
+// Check returns nil: the key has no version to compare
+func (webFilterUpdater) Check(ctx context.Context, conn *sql.DB, cfg *config.Config, logger *logrus.Logger) []VersionCheck {
+	return nil
+}
+
+func (webFilterUpdater) Apply(ctx context.Context, conn *sql.DB, cfg *config.Config, logger *logrus.Logger) *ComponentResult {
+	return UpdateWebFilterKey(ctx, conn, cfg, logger)
+}
+
+func (webFilterUpdater) Status(conn *sql.DB, cfg *config.Config) ComponentStatus {
+	return lastSuccessStatus(conn, ComponentWebFilter)
 }
@@ -16,8 +16,12 @@ import (
 const (
 	maxRetries    = 3
 	retryDelay    = 3 * time.Second
+	sendTimeout   = 15 * time.Second
 )
 
+// apiBase is the Bot API address, подменяется в тестах
+var apiBase = "https://api.telegram.org"
+
 // Notifier sends notifications to a Telegram chat via Bot API.
 type Notifier struct {
 	cfg *config.Config
@@ -52,16 +56,17 @@ func (n *Notifier) Send(text string) error {
 		return fmt.Errorf("telegram: marshal error: %w", err)
 	}
 
-	apiURL := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", n.cfg.TelegramBotToken)
+	apiURL := fmt.Sprintf("%s/bot%s/sendMessage", apiBase, n.cfg.TelegramBotToken)
+
+	// Shared pool: proxy, TLS and timeouts from the settings. A stale keep-alive connection
+	// shows up as a transient error and is retried.
+	client, err := utils.SharedClient().HTTPClient(n.cfg.ProxyURL, sendTimeout)
+	if err != nil {
+		return fmt.Errorf("telegram: %w", err)
+	}
 
 	var lastErr error
 	for attempt := 1; attempt <= maxRetries; attempt++ {
-		// Create a fresh client per attempt to avoid reusing stale connections.
-		client, err := utils.CreateHTTPClient(n.cfg.ProxyURL, 15*time.Second)
-		if err != nil {
-			client = &http.Client{Timeout: 15 * time.Second}
-		}
-
 		resp, err := client.Post(apiURL, "application/json", bytes.NewReader(body))
 		if err != nil {
 			lastErr = fmt.Errorf("telegram: send error (attempt %d/%d): %w", attempt, maxRetries, err)
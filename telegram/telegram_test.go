@@ -0,0 +1,46 @@
+package telegram
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"kerio-mirror-go/config"
+	"kerio-mirror-go/utils"
+)
+
+func TestSend_UsesTLSSettings(t *testing.T) {
+	// Bot API за TLS-инспектирующим прокси: сертификат подписан CA, которого нет в системе
+	var path string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+	}))
+	defer srv.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(caFile, data, 0644); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+
+	prevBase := apiBase
+	apiBase = srv.URL
+	defer func() { apiBase = prevBase }()
+	prev := utils.SetSharedClient(utils.NewClient(utils.ClientConfig{}))
+	defer utils.SetSharedClient(prev)
+
+	n := New(&config.Config{TelegramBotToken: "token", TelegramChatID: "1"})
+	if err := n.Send("test"); err == nil {
+		t.Errorf("Expected an unknown authority error without TLS_CA_FILES")
+	}
+
+	utils.SetSharedClient(utils.NewClient(utils.ClientConfig{TLS: utils.TLSConfig{CAFiles: []string{caFile}}}))
+	if err := n.Send("test"); err != nil {
+		t.Fatalf("Expected success with TLS_CA_FILES, got %v", err)
+	}
+	if path != "/bottoken/sendMessage" {
+		t.Errorf("Expected request to /bottoken/sendMessage, got %s", path)
+	}
+}
@@ -0,0 +1,205 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrVerifyFailed is returned by AtomicFile.Commit when the written data does not match the expectation
+var ErrVerifyFailed = errors.New("downloaded file verification failed")
+
+// Expect describes what a file must match before it is published. Zero fields are not checked.
+type Expect struct {
+	Size   int64  // полный размер файла, 0 - не проверяется
+	MD5    []byte // ожидаемый MD5
+	SHA256 []byte // ожидаемый SHA-256
+}
+
+// ExpectFromResponse collects the size and hashes announced by the server:
+// Content-Length (the total from Content-Range for 206), Content-MD5,
+// Digest (RFC 3230) and Repr-Digest (RFC 9530).
+func ExpectFromResponse(resp *http.Response) Expect {
+	var exp Expect
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if resp.ContentLength > 0 && resp.Header.Get("Content-Encoding") == "" {
+			exp.Size = resp.ContentLength
+		}
+		// Content-MD5 описывает тело ответа, у 206 это только часть файла
+		if sum, err := base64.StdEncoding.DecodeString(resp.Header.Get("Content-MD5")); err == nil && len(sum) == md5.Size {
+			exp.MD5 = sum
+		}
+	case http.StatusPartialContent:
+		if _, total, ok := strings.Cut(resp.Header.Get("Content-Range"), "/"); ok && total != "*" {
+			exp.Size = int64(AtoiSafe(total))
+		}
+	}
+	for _, h := range []string{"Digest", "Repr-Digest"} {
+		for _, item := range strings.Split(resp.Header.Get(h), ",") {
+			alg, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+			if !ok {
+				continue
+			}
+			sum, err := base64.StdEncoding.DecodeString(strings.Trim(value, ":"))
+			if err != nil {
+				continue
+			}
+			switch strings.ToLower(alg) {
+			case "md5":
+				if len(sum) == md5.Size {
+					exp.MD5 = sum
+				}
+			case "sha-256":
+				if len(sum) == sha256.Size {
+					exp.SHA256 = sum
+				}
+			}
+		}
+	}
+	return exp
+}
+
+// ParseMD5File parses the contents of an ".md5" file: a hex digest, optionally followed by a file name
+func ParseMD5File(data []byte) ([]byte, error) {
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return nil, errors.New("empty md5 file")
+	}
+	sum, err := hex.DecodeString(fields[0])
+	if err != nil || len(sum) != md5.Size {
+		return nil, fmt.Errorf("invalid md5 %q", fields[0])
+	}
+	return sum, nil
+}
+
+// AtomicFile writes a file next to its destination and publishes it only after Commit:
+// the data is verified, fsynced and renamed into place, so readers never see a partial file.
+// Concurrent writers of the same destination use different temp files and the last Commit wins.
+type AtomicFile struct {
+	dest string
+	f    *os.File
+	md5  hash.Hash
+	sha  hash.Hash
+	size int64
+	done bool // Commit или Abort уже вызваны
+}
+
+// CreateAtomic creates a temp file "<name>.*.tmp" in the directory of destPath
+func CreateAtomic(destPath string) (*AtomicFile, error) {
+	dir := filepath.Dir(destPath)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(dir, filepath.Base(destPath)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &AtomicFile{dest: destPath, f: f, md5: md5.New(), sha: sha256.New()}, nil
+}
+
+// Write appends p to the temp file
+func (a *AtomicFile) Write(p []byte) (int, error) {
+	n, err := a.f.Write(p)
+	a.md5.Write(p[:n])
+	a.sha.Write(p[:n])
+	a.size += int64(n)
+	return n, err
+}
+
+// Size returns the number of bytes written so far
+func (a *AtomicFile) Size() int64 { return a.size }
+
+// Reset discards everything written so far
+func (a *AtomicFile) Reset() error {
+	if err := a.f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := a.f.Seek(0, 0); err != nil {
+		return err
+	}
+	a.md5.Reset()
+	a.sha.Reset()
+	a.size = 0
+	return nil
+}
+
+// Verify checks the data written so far against exp
+func (a *AtomicFile) Verify(exp Expect) error {
+	if exp.Size > 0 && a.size != exp.Size {
+		return fmt.Errorf("%w: %s: got %d bytes, expected %d", ErrVerifyFailed, filepath.Base(a.dest), a.size, exp.Size)
+	}
+	if exp.MD5 != nil && !bytes.Equal(a.md5.Sum(nil), exp.MD5) {
+		return fmt.Errorf("%w: %s: MD5 mismatch", ErrVerifyFailed, filepath.Base(a.dest))
+	}
+	if exp.SHA256 != nil && !bytes.Equal(a.sha.Sum(nil), exp.SHA256) {
+		return fmt.Errorf("%w: %s: SHA-256 mismatch", ErrVerifyFailed, filepath.Base(a.dest))
+	}
+	return nil
+}
+
+// Commit verifies the data, flushes it to disk and renames the temp file to the destination.
+// On any error the temp file is removed and the destination is left untouched.
+func (a *AtomicFile) Commit(exp Expect) error {
+	if a.done {
+		return os.ErrClosed
+	}
+	a.done = true
+	err := a.Verify(exp)
+	if err == nil {
+		err = a.f.Chmod(publishMode(a.dest))
+	}
+	if err == nil {
+		err = a.f.Sync()
+	}
+	if closeErr := a.f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(a.f.Name(), a.dest)
+	}
+	if err != nil {
+		os.Remove(a.f.Name())
+		return err
+	}
+	syncDir(filepath.Dir(a.dest))
+	return nil
+}
+
+// publishMode returns the permissions of a published file: those of the file it replaces, or 0644.
+// os.CreateTemp creates the temp file as 0600, readable only by the service account.
+func publishMode(dest string) os.FileMode {
+	if info, err := os.Stat(dest); err == nil && info.Mode().IsRegular() {
+		return info.Mode().Perm()
+	}
+	return 0644
+}
+
+// Abort removes the temp file. It does nothing after a successful Commit.
+func (a *AtomicFile) Abort() {
+	if a.done {
+		return
+	}
+	a.done = true
+	a.f.Close()
+	os.Remove(a.f.Name())
+}
+
+// syncDir makes the rename durable. Not supported on every platform (Windows), so errors are ignored.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
@@ -0,0 +1,167 @@
+package utils
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestAtomicFile_Commit(t *testing.T) {
+	data := []byte("new content")
+	sum := md5.Sum(data)
+	wrong := md5.Sum([]byte("other"))
+
+	tests := []struct {
+		name   string
+		expect Expect
+		ok     bool
+	}{
+		{"no expectation", Expect{}, true},
+		{"size matches", Expect{Size: int64(len(data))}, true},
+		{"size mismatch", Expect{Size: 100}, false},
+		{"md5 matches", Expect{MD5: sum[:]}, true},
+		{"md5 mismatch", Expect{MD5: wrong[:]}, false},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		dest := filepath.Join(dir, "file.dat")
+		os.WriteFile(dest, []byte("old content"), 0644)
+
+		f, err := CreateAtomic(dest)
+		if err != nil {
+			t.Fatalf("%s: CreateAtomic failed: %v", tt.name, err)
+		}
+		f.Write(data)
+		// Пока Commit не вызван, опубликован старый файл
+		if got, _ := os.ReadFile(dest); string(got) != "old content" {
+			t.Errorf("%s: Expected old content before commit, got %q", tt.name, got)
+		}
+		err = f.Commit(tt.expect)
+		f.Abort()
+
+		want := "old content"
+		if tt.ok {
+			want = string(data)
+			if err != nil {
+				t.Errorf("%s: Expected commit to succeed, got %v", tt.name, err)
+			}
+		} else if !errors.Is(err, ErrVerifyFailed) {
+			t.Errorf("%s: Expected ErrVerifyFailed, got %v", tt.name, err)
+		}
+		if got, _ := os.ReadFile(dest); string(got) != want {
+			t.Errorf("%s: Expected %q, got %q", tt.name, want, got)
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+			t.Errorf("%s: Expected temp file to be removed, got %d entries", tt.name, len(entries))
+		}
+	}
+}
+
+func TestAtomicFile_Mode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Unix permissions")
+	}
+	dir := t.TempDir()
+	tests := []struct {
+		name     string
+		existing os.FileMode // 0 - файла ещё нет
+		expected os.FileMode
+	}{
+		{"new file", 0, 0644},
+		{"keeps mode of replaced file", 0640, 0640},
+	}
+	for _, tt := range tests {
+		dest := filepath.Join(dir, tt.name)
+		if tt.existing != 0 {
+			os.WriteFile(dest, []byte("old"), tt.existing)
+			os.Chmod(dest, tt.existing)
+		}
+		f, err := CreateAtomic(dest)
+		if err != nil {
+			t.Fatalf("%s: CreateAtomic failed: %v", tt.name, err)
+		}
+		f.Write([]byte("new"))
+		if err := f.Commit(Expect{}); err != nil {
+			t.Fatalf("%s: Commit failed: %v", tt.name, err)
+		}
+		info, err := os.Stat(dest)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if info.Mode().Perm() != tt.expected {
+			t.Errorf("%s: Expected mode %v, got %v", tt.name, tt.expected, info.Mode().Perm())
+		}
+	}
+}
+
+func TestExpectFromResponse(t *testing.T) {
+	data := []byte("payload")
+	md5sum := md5.Sum(data)
+	shasum := sha256.Sum256(data)
+
+	resp := &http.Response{StatusCode: http.StatusOK, ContentLength: 7, Header: http.Header{}}
+	resp.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(md5sum[:]))
+	resp.Header.Set("Repr-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(shasum[:])+":")
+	exp := ExpectFromResponse(resp)
+	if exp.Size != 7 || exp.MD5 == nil || exp.SHA256 == nil {
+		t.Errorf("Expected size, MD5 and SHA-256, got %+v", exp)
+	}
+
+	// У 206 размер берётся из Content-Range, Content-MD5 относится к части и не используется
+	resp = &http.Response{StatusCode: http.StatusPartialContent, ContentLength: 3, Header: http.Header{}}
+	resp.Header.Set("Content-Range", "bytes 4-6/7")
+	resp.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(md5sum[:]))
+	exp = ExpectFromResponse(resp)
+	if exp.Size != 7 || exp.MD5 != nil {
+		t.Errorf("Expected size 7 without MD5, got %+v", exp)
+	}
+}
+
+func TestParseMD5File(t *testing.T) {
+	tests := []struct {
+		data string
+		ok   bool
+	}{
+		{"d41d8cd98f00b204e9800998ecf8427e", true},
+		{"d41d8cd98f00b204e9800998ecf8427e  snort.tpl\n", true},
+		{"", false},
+		{"not-a-hash", false},
+	}
+	for _, tt := range tests {
+		_, err := ParseMD5File([]byte(tt.data))
+		if (err == nil) != tt.ok {
+			t.Errorf("ParseMD5File(%q): expected ok=%v, got %v", tt.data, tt.ok, err)
+		}
+	}
+}
+
+func TestDownloadFileExpect_Mismatch(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests++
+		w.Write([]byte("corrupted"))
+	}))
+	defer server.Close()
+
+	dest := filepath.Join(t.TempDir(), "snort.tpl")
+	os.WriteFile(dest, []byte("published"), 0644)
+	sum := md5.Sum([]byte("expected"))
+	err := DownloadFileExpect(context.Background(), server.URL, dest, "", 1, 0, Expect{MD5: sum[:]})
+	if !errors.Is(err, ErrVerifyFailed) {
+		t.Fatalf("Expected ErrVerifyFailed, got %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("Expected the file to be downloaded again once, got %d requests", requests)
+	}
+	if got, _ := os.ReadFile(dest); string(got) != "published" {
+		t.Errorf("Expected published file to stay, got %q", got)
+	}
+}
@@ -0,0 +1,301 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultRequestTimeout is used when neither the caller nor the config set a timeout
+const defaultRequestTimeout = 60 * time.Second
+
+// ClientConfig configures the shared client used for all outgoing requests
+type ClientConfig struct {
+	Timeout         time.Duration         // таймаут запроса вместе с телом, если вызывающий не задал свой; при лимите скорости - таймаут простоя
+	MaxConnsPerHost int                   // одновременных запросов к одному хосту, 0 - без ограничения
+	UserAgent       string                // пусто - User-Agent Go по умолчанию
+	Headers         map[string]string     // заголовки для всех запросов
+	Hosts           map[string]HostConfig // настройки отдельных хостов: "host" или "*.domain"
+	Proxy           ProxyConfig           // выбор прокси по компоненту и хосту
+	TLS             TLSConfig             // TLS для всех хостов
+	Resolver        ResolverConfig        // разрешение имён upstream
+	// Transport заменяет сетевой транспорт (в тестах), прокси тогда не используется
+	Transport http.RoundTripper
+}
+
+// HostConfig overrides ClientConfig for one upstream host. Zero fields inherit the global values.
+type HostConfig struct {
+	Timeout   time.Duration // перекрывает и таймаут вызывающего
+	MaxConns  int
+	UserAgent string
+	Headers   map[string]string // добавляются к общим и перекрывают их
+	TLS       TLSConfig         // дополняет общий TLS, у хоста тогда свои соединения
+}
+
+// Client is a pool of keep-alive connections shared by all components.
+// There is one transport per proxy URL, plus one per proxy URL and host with its own TLS settings.
+// Clients returned by HTTPClient are cheap wrappers around them.
+type Client struct {
+	cfg ClientConfig
+
+	mu         sync.Mutex
+	transports map[string]http.RoundTripper // по URL прокси ("" - напрямую) и хосту со своим TLS
+	hostSlots  map[string]chan struct{}     // ограничение одновременных запросов по хостам
+	doh        *http.Client                 // клиент DoH, создаётся при первом запросе
+}
+
+// NewClient creates a client with its own connection pool
+func NewClient(cfg ClientConfig) *Client {
+	return &Client{cfg: cfg, transports: map[string]http.RoundTripper{}, hostSlots: map[string]chan struct{}{}}
+}
+
+var (
+	sharedMu     sync.RWMutex
+	sharedClient = NewClient(ClientConfig{})
+)
+
+// SharedClient returns the client used for all outgoing requests
+func SharedClient() *Client {
+	sharedMu.RLock()
+	defer sharedMu.RUnlock()
+	return sharedClient
+}
+
+// SetSharedClient replaces the shared client (after the settings change or in tests)
+// and returns the previous one. Idle connections of the previous client are closed,
+// requests in progress finish on their own connections.
+func SetSharedClient(c *Client) *Client {
+	sharedMu.Lock()
+	prev := sharedClient
+	sharedClient = c
+	sharedMu.Unlock()
+	prev.CloseIdleConnections()
+	return prev
+}
+
+// HTTPClient returns an http.Client that sends requests through proxyURL ("" - direct)
+// using the shared connections. The proxy settings of the config may replace or bypass proxyURL
+// for the component of the request and its host (see ProxyConfig).
+// timeout limits a whole request including reading the body,
+// 0 means the configured default; a host timeout from the config takes precedence.
+func (c *Client) HTTPClient(proxyURL string, timeout time.Duration) (*http.Client, error) {
+	if _, err := c.transport(proxyURL, ""); err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: &clientTransport{client: c, proxyURL: proxyURL, timeout: timeout}}, nil
+}
+
+// CloseIdleConnections closes the idle keep-alive connections of every transport
+func (c *Client) CloseIdleConnections() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, t := range c.transports {
+		if ci, ok := t.(interface{ CloseIdleConnections() }); ok {
+			ci.CloseIdleConnections()
+		}
+	}
+	if c.doh != nil {
+		c.doh.CloseIdleConnections()
+	}
+}
+
+// transport returns the transport of proxyURL for hosts matching hostKey, a key of ClientConfig.Hosts
+// with its own TLS settings, or for all other hosts if hostKey is ""
+func (c *Client) transport(proxyURL, hostKey string) (http.RoundTripper, error) {
+	if c.cfg.Transport != nil {
+		return c.cfg.Transport, nil
+	}
+	key := proxyURL
+	tlsCfg := c.cfg.TLS
+	if hostKey != "" {
+		key += "\x00" + hostKey
+		tlsCfg = tlsCfg.merge(c.cfg.Hosts[hostKey].TLS)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t, ok := c.transports[key]; ok {
+		return t, nil
+	}
+	tc, err := tlsCfg.Build()
+	if err != nil {
+		return nil, err
+	}
+	t, err := createTransport(proxyURL, tc, c.dialContext)
+	if err != nil {
+		return nil, err
+	}
+	t.ForceAttemptHTTP2 = true
+	t.MaxIdleConns = 100
+	t.MaxIdleConnsPerHost = max(c.cfg.MaxConnsPerHost, 16)
+	t.IdleConnTimeout = 90 * time.Second
+	t.TLSHandshakeTimeout = 15 * time.Second
+	c.transports[key] = t
+	return t, nil
+}
+
+// host returns the settings of hostname and their key: an exact match first, then "*.domain"
+func (c *Client) host(hostname string) (string, HostConfig) {
+	hostname = strings.ToLower(hostname)
+	if h, ok := c.cfg.Hosts[hostname]; ok {
+		return hostname, h
+	}
+	for rest := hostname; ; {
+		i := strings.IndexByte(rest, '.')
+		if i < 0 {
+			break
+		}
+		rest = rest[i+1:]
+		if h, ok := c.cfg.Hosts["*."+rest]; ok {
+			return "*." + rest, h
+		}
+	}
+	return "", HostConfig{}
+}
+
+// slots returns the semaphore of a host, nil if its requests are not limited
+func (c *Client) slots(hostname string, limit int) chan struct{} {
+	if limit <= 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.hostSlots[hostname]
+	if !ok {
+		s = make(chan struct{}, limit)
+		c.hostSlots[hostname] = s
+	}
+	return s
+}
+
+// clientTransport applies headers, timeouts and connection limits of the config to each request
+type clientTransport struct {
+	client   *Client
+	proxyURL string
+	timeout  time.Duration
+}
+
+// roundTrip sends the request to its host once
+func (t *clientTransport) roundTrip(req *http.Request) (*http.Response, error) {
+	c := t.client
+	hostname := strings.ToLower(req.URL.Hostname())
+	hostKey, h := c.host(hostname)
+	if h.TLS.empty() {
+		hostKey = ""
+	}
+
+	// RoundTripper не должен менять запрос вызывающего
+	req = req.Clone(req.Context())
+	for k, v := range c.cfg.Headers {
+		if req.Header.Get(k) == "" {
+			req.Header.Set(k, v)
+		}
+	}
+	for k, v := range h.Headers {
+		req.Header.Set(k, v)
+	}
+	if ua := firstNonEmpty(h.UserAgent, c.cfg.UserAgent); ua != "" && req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", ua)
+	}
+
+	timeout := t.timeout
+	if h.Timeout > 0 {
+		timeout = h.Timeout
+	}
+	if timeout <= 0 {
+		timeout = c.cfg.Timeout
+	}
+	if timeout <= 0 {
+		timeout = defaultRequestTimeout
+	}
+	// Ограниченная по скорости загрузка большого файла идёт дольше любого таймаута запроса,
+	// поэтому для неё таймаут считается от последних полученных данных
+	var ctx context.Context
+	var cancel context.CancelFunc
+	var idle *time.Timer
+	if RateLimited(req.Context()) {
+		ctx, cancel = context.WithCancel(req.Context())
+		idle = time.AfterFunc(timeout, cancel)
+	} else {
+		ctx, cancel = context.WithTimeout(req.Context(), timeout)
+	}
+
+	limit := c.cfg.MaxConnsPerHost
+	if h.MaxConns > 0 {
+		limit = h.MaxConns
+	}
+	release := cancel
+	if slots := c.slots(hostname, limit); slots != nil {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			cancel()
+			return nil, ctx.Err()
+		}
+		release = func() {
+			<-slots
+			cancel()
+		}
+	}
+
+	routes := c.proxyRoutes(upstreamComponent(req.Context()), hostname, t.proxyURL)
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		routes = routes[:1]
+	}
+	var resp *http.Response
+	var err error
+	for _, p := range routes {
+		rt, rerr := c.transport(p, hostKey)
+		if rerr == nil {
+			resp, rerr = rt.RoundTrip(req.WithContext(ctx))
+		}
+		if err != nil && rerr != nil {
+			// Напрямую не вышло и через прокси тоже
+			rerr = fmt.Errorf("direct: %v; via proxy: %w", err, rerr)
+		}
+		if err = rerr; err == nil || ctx.Err() != nil {
+			break
+		}
+	}
+	if err != nil {
+		release()
+		return nil, err
+	}
+	// Слот и таймаут держатся, пока вызывающий не закроет тело
+	resp.Body = &releaseBody{ReadCloser: resp.Body, release: release, idle: idle, timeout: timeout}
+	return resp, nil
+}
+
+type releaseBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+	idle    *time.Timer // таймаут простоя ограниченной загрузки, nil - таймаут всего запроса
+	timeout time.Duration
+}
+
+func (b *releaseBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 && b.idle != nil {
+		b.idle.Reset(b.timeout)
+	}
+	return n, err
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
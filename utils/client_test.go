@@ -0,0 +1,169 @@
+package utils
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// roundTripFunc позволяет подменить сеть в тестах
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestClient_Headers(t *testing.T) {
+	var got http.Header
+	c := NewClient(ClientConfig{
+		UserAgent: "mirror/1.0",
+		Headers:   map[string]string{"X-Global": "g", "X-Override": "global"},
+		Hosts: map[string]HostConfig{
+			"*.example.com": {UserAgent: "special", Headers: map[string]string{"X-Override": "host"}},
+		},
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			got = r.Header
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("")), Request: r}, nil
+		}),
+	})
+	client, err := c.HTTPClient("", 0)
+	if err != nil {
+		t.Fatalf("HTTPClient failed: %v", err)
+	}
+
+	tests := []struct {
+		url       string
+		userAgent string
+		override  string
+	}{
+		{"http://upstream.test/file", "mirror/1.0", "global"},
+		{"http://cdn.example.com/file", "special", "host"},
+		{"http://a.b.example.com/file", "special", "host"},
+		{"http://example.com/file", "mirror/1.0", "global"},
+	}
+	for _, tt := range tests {
+		resp, err := client.Get(tt.url)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", tt.url, err)
+		}
+		resp.Body.Close()
+		if got.Get("User-Agent") != tt.userAgent || got.Get("X-Override") != tt.override || got.Get("X-Global") != "g" {
+			t.Errorf("%s: Expected User-Agent %q and X-Override %q, got %v", tt.url, tt.userAgent, tt.override, got)
+		}
+	}
+}
+
+func TestClient_HostTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+
+	// Таймаут хоста перекрывает таймаут вызывающего
+	c := NewClient(ClientConfig{Hosts: map[string]HostConfig{"127.0.0.1": {Timeout: 50 * time.Millisecond}}})
+	defer c.CloseIdleConnections()
+	client, _ := c.HTTPClient("", time.Minute)
+	start := time.Now()
+	if _, err := client.Get(server.URL); err == nil {
+		t.Fatal("Expected timeout error")
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("Expected host timeout of 50ms, request took %v", d)
+	}
+}
+
+func TestClient_IdleTimeoutWhenLimited(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Тело идёт дольше таймаута, но без пауз длиннее него
+		for i := 0; i < 5; i++ {
+			w.Write([]byte("chunk"))
+			w.(http.Flusher).Flush()
+			time.Sleep(60 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+
+	c := NewClient(ClientConfig{})
+	defer c.CloseIdleConnections()
+	client, _ := c.HTTPClient("", 150*time.Millisecond)
+	get := func(ctx context.Context) error {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, err = io.ReadAll(resp.Body)
+		return err
+	}
+
+	if err := get(context.Background()); err == nil {
+		t.Error("Expected the request timeout to cut the unlimited download")
+	}
+	limited := WithRateLimit(context.Background(), NewRateLimiter(1<<30))
+	if err := get(limited); err != nil {
+		t.Errorf("Expected a limited download to use the idle timeout, got %v", err)
+	}
+}
+
+func TestClient_MaxConnsPerHost(t *testing.T) {
+	var active, peak int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&active, 1)
+		defer atomic.AddInt32(&active, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	c := NewClient(ClientConfig{MaxConnsPerHost: 2})
+	defer c.CloseIdleConnections()
+	client, _ := c.HTTPClient("", 0)
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if resp, err := client.Get(server.URL); err == nil {
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	if peak > 2 {
+		t.Errorf("Expected at most 2 parallel requests, got %d", peak)
+	}
+}
+
+func TestSetSharedClient(t *testing.T) {
+	var requests int32
+	replaced := NewClient(ClientConfig{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&requests, 1)
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("fake")), Request: r}, nil
+	})})
+	prev := SetSharedClient(replaced)
+	defer SetSharedClient(prev)
+
+	resp, err := HTTPGetWithRetry(context.Background(), "http://upstream.invalid/file", 0, 0, "")
+	if err != nil {
+		t.Fatalf("HTTPGetWithRetry failed: %v", err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(data) != "fake" || requests != 1 {
+		t.Errorf("Expected request to go through the replaced client, got %q after %d requests", data, requests)
+	}
+}
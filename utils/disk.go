@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// DiskSpace returns the free (available to this process) and total bytes of the volume holding path.
+// path may not exist yet, then its nearest existing parent is used.
+func DiskSpace(path string) (free, total uint64, err error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	for {
+		if _, err := os.Stat(abs); err == nil {
+			break
+		}
+		parent := filepath.Dir(abs)
+		if parent == abs {
+			break
+		}
+		abs = parent
+	}
+	return diskSpace(abs)
+}
+
+// DirUsage returns the size and number of regular files in dir, a missing dir is empty.
+// With recursive false only the files directly in dir are counted.
+func DirUsage(dir string, recursive bool) (bytes, files int64, err error) {
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir && errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipAll
+			}
+			// Файл мог исчезнуть во время обхода (очистка, публикация версии)
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			if path != dir && !recursive {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		bytes += info.Size()
+		files++
+		return nil
+	})
+	return bytes, files, err
+}
@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirUsage(t *testing.T) {
+	dir := t.TempDir()
+	for path, size := range map[string]int{"a.bin": 100, "b.bin": 50, "sub/c.bin": 25} {
+		full := filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		dir       string
+		recursive bool
+		bytes     int64
+		files     int64
+	}{
+		{dir, true, 175, 3},
+		{dir, false, 150, 2},
+		{filepath.Join(dir, "missing"), true, 0, 0}, // ещё не созданный каталог пуст
+	}
+	for _, tt := range tests {
+		bytes, files, err := DirUsage(tt.dir, tt.recursive)
+		if err != nil {
+			t.Errorf("DirUsage(%s, %v) failed: %v", tt.dir, tt.recursive, err)
+			continue
+		}
+		if bytes != tt.bytes || files != tt.files {
+			t.Errorf("DirUsage(%s, %v): expected %d bytes in %d files, got %d in %d", tt.dir, tt.recursive, tt.bytes, tt.files, bytes, files)
+		}
+	}
+}
+
+func TestDiskSpace(t *testing.T) {
+	// Для несуществующего пути берётся ближайший существующий каталог
+	free, total, err := DiskSpace(filepath.Join(t.TempDir(), "mirror", "bitdefender"))
+	if err != nil {
+		t.Fatalf("DiskSpace failed: %v", err)
+	}
+	if total == 0 || free > total {
+		t.Errorf("Expected free <= total and total > 0, got %d/%d", free, total)
+	}
+}
@@ -0,0 +1,222 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DownloadFile downloads urlStr to destPath through an optional proxy.
+// The body goes to a temp file next to destPath which is verified against the size and hashes
+// announced by the server, fsynced and renamed into place (see AtomicFile). When the connection drops,
+// the next attempt asks only for the missing part with Range and If-Range, so the server either
+// continues the same file (206) or sends it again from the start (200) if it has changed.
+// Resuming needs a strong ETag or a Last-Modified header from the first response.
+// An attempt that moved the download further than before does not count against retries.
+func DownloadFile(ctx context.Context, urlStr, destPath, proxyURL string, retries int, delay time.Duration) error {
+	return DownloadFileExpect(ctx, urlStr, destPath, proxyURL, retries, delay, Expect{})
+}
+
+// DownloadFileExpect is DownloadFile with a known size or hash, e.g. from an ".md5" file.
+// Non-zero fields of want take precedence over the values from the response headers.
+// A file that fails verification is downloaded again from the start.
+func DownloadFileExpect(ctx context.Context, urlStr, destPath, proxyURL string, retries int, delay time.Duration, want Expect) error {
+	_, err := DownloadFileConditional(ctx, urlStr, destPath, proxyURL, retries, delay, want, Validators{})
+	return err
+}
+
+// ErrNotModified is returned by DownloadFileConditional when the server answered 304
+var ErrNotModified = errors.New("not modified")
+
+// Validators identify a version of a remote file for conditional requests
+type Validators struct {
+	ETag         string
+	LastModified string
+}
+
+// Empty reports whether there is nothing to send in a conditional request
+func (v Validators) Empty() bool {
+	return v.ETag == "" && v.LastModified == ""
+}
+
+// DownloadFileConditional is DownloadFileExpect with a conditional GET: the first request carries
+// If-None-Match / If-Modified-Since from prev. On 304 destPath is left untouched and ErrNotModified
+// is returned. On success it returns the validators of the downloaded file to store for the next run.
+func DownloadFileConditional(ctx context.Context, urlStr, destPath, proxyURL string, retries int, delay time.Duration, want Expect, prev Validators) (Validators, error) {
+	client := upstreamClient(proxyURL, 0)
+
+	out, err := CreateAtomic(destPath)
+	if err != nil {
+		return Validators{}, err
+	}
+	defer out.Abort()
+	d := &resumableDownload{client: client, url: urlStr, out: out, cond: prev}
+
+	herr := &HTTPError{URL: urlStr}
+	var reached int64 // дальше этой позиции попытки ещё не доходили
+	for failures := 0; ; {
+		herr.Attempts++
+		err := d.attempt(ctx)
+		if err == nil {
+			if err = out.Verify(d.expect.merge(want)); err == nil {
+				break
+			}
+			d.reset("")
+		}
+		if errors.Is(err, ErrNotModified) {
+			return prev, err
+		}
+		if code := StatusCode(err); code != 0 {
+			herr.StatusCode = code
+		}
+		herr.Err = err
+		if ctx.Err() != nil {
+			return Validators{}, fmt.Errorf("GET %s cancelled: %w", urlStr, ctx.Err())
+		}
+		if out.Size() > reached {
+			reached = out.Size()
+		} else {
+			failures++
+		}
+		wait, retry := retryWait(err, max(failures, 1), delay)
+		if !retry || failures > retries {
+			return Validators{}, herr
+		}
+		if err := SleepContext(ctx, wait); err != nil {
+			return Validators{}, fmt.Errorf("GET %s cancelled: %w", urlStr, err)
+		}
+	}
+
+	if err := out.Commit(d.expect.merge(want)); err != nil {
+		return Validators{}, err
+	}
+	if stats := DownloadStatsFrom(ctx); stats != nil {
+		stats.files.Add(1)
+	}
+	return d.got, nil
+}
+
+// merge returns e with the non-zero fields of want
+func (e Expect) merge(want Expect) Expect {
+	if want.Size > 0 {
+		e.Size = want.Size
+	}
+	if want.MD5 != nil {
+		e.MD5 = want.MD5
+	}
+	if want.SHA256 != nil {
+		e.SHA256 = want.SHA256
+	}
+	return e
+}
+
+// resumableDownload keeps the partial file between attempts
+type resumableDownload struct {
+	client    *http.Client
+	url       string
+	out       *AtomicFile
+	validator string     // ETag или Last-Modified ответа, к которому относится частичный файл
+	expect    Expect     // размер и хеши из заголовков ответа
+	cond      Validators // валидаторы опубликованной версии для условного запроса
+	got       Validators // валидаторы загружаемой версии
+}
+
+// attempt performs one request and appends the body to the partial file
+func (d *resumableDownload) attempt(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.url, nil)
+	if err != nil {
+		return err
+	}
+	offset := d.out.Size()
+	resume := offset > 0 && d.validator != ""
+	if resume {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", d.validator)
+	} else {
+		if d.cond.ETag != "" {
+			req.Header.Set("If-None-Match", d.cond.ETag)
+		}
+		if d.cond.LastModified != "" {
+			req.Header.Set("If-Modified-Since", d.cond.LastModified)
+		}
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && resume:
+		if start, ok := contentRangeStart(resp.Header.Get("Content-Range")); !ok || start != offset {
+			d.reset("")
+			return fmt.Errorf("unexpected Content-Range %q for offset %d", resp.Header.Get("Content-Range"), offset)
+		}
+		if exp := ExpectFromResponse(resp); exp.Size > 0 {
+			d.expect.Size = exp.Size
+		}
+	case resp.StatusCode == http.StatusOK:
+		// Сервер не поддерживает Range или файл изменился - начинаем заново
+		if err := d.reset(resumeValidator(resp.Header)); err != nil {
+			return err
+		}
+		d.expect = ExpectFromResponse(resp)
+		d.got = Validators{ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+	case resp.StatusCode == http.StatusNotModified && !resume:
+		return ErrNotModified
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// Частичный файл не подходит, следующая попытка начнёт с нуля
+		d.reset("")
+		return fmt.Errorf("bad status: %d", resp.StatusCode)
+	default:
+		return newStatusError(resp)
+	}
+
+	var body io.ReadCloser = resp.Body
+	if stats := DownloadStatsFrom(ctx); stats != nil {
+		body = &countingBody{ReadCloser: body, stats: stats}
+	}
+	body = LimitBody(ctx, body)
+	if _, err := io.Copy(d.out, body); err != nil {
+		if d.validator == "" {
+			// Без валидатора продолжить нельзя, следующая попытка начнёт с нуля
+			d.reset("")
+		}
+		return err
+	}
+	return nil
+}
+
+// reset discards the partial file and remembers the validator of the new response
+func (d *resumableDownload) reset(validator string) error {
+	d.validator, d.expect = validator, Expect{}
+	return d.out.Reset()
+}
+
+// resumeValidator returns the value for If-Range: a strong ETag or Last-Modified.
+// Weak ETags are not allowed in If-Range.
+func resumeValidator(h http.Header) string {
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return h.Get("Last-Modified")
+}
+
+// contentRangeStart parses the first byte position of "bytes 100-199/200"
+func contentRangeStart(v string) (int64, bool) {
+	v, ok := strings.CutPrefix(v, "bytes ")
+	if !ok {
+		return 0, false
+	}
+	start, _, ok := strings.Cut(v, "-")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(start, 10, 64)
+	return n, err == nil
+}
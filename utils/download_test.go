@@ -0,0 +1,169 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dropAfter отдаёт первые n байт полного ответа и рвёт соединение
+func dropAfter(w http.ResponseWriter, data []byte, n int, etag string) {
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data[:n])
+	w.(http.Flusher).Flush()
+	panic(http.ErrAbortHandler)
+}
+
+func TestDownloadFile_Resume(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		if len(ranges) == 1 {
+			dropAfter(w, data, 4000, `"v1"`)
+		}
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	ctx, stats := WithDownloadStats(context.Background())
+	dest := filepath.Join(t.TempDir(), "file.bin")
+	if err := DownloadFile(ctx, server.URL, dest, "", 0, 0); err != nil {
+		t.Fatalf("DownloadFile failed: %v", err)
+	}
+	got, _ := os.ReadFile(dest)
+	if !bytes.Equal(got, data) {
+		t.Errorf("Expected %d bytes of original data, got %d bytes", len(data), len(got))
+	}
+	if len(ranges) != 2 || ranges[1] != "bytes=4000-" {
+		t.Errorf("Expected second request to resume from 4000, got %q", ranges)
+	}
+	if stats.Bytes() != int64(len(data)) || stats.Files() != 1 {
+		t.Errorf("Expected %d bytes in 1 file, got %d bytes in %d files", len(data), stats.Bytes(), stats.Files())
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(dest)); len(entries) != 1 {
+		t.Errorf("Expected only the downloaded file, got %d entries", len(entries))
+	}
+}
+
+func TestDownloadFile_ChangedUpstream(t *testing.T) {
+	oldData := []byte(strings.Repeat("a", 1000))
+	newData := []byte(strings.Repeat("b", 1500))
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			dropAfter(w, oldData, 500, `"v1"`)
+		}
+		// Файл изменился: If-Range не совпадает, сервер отдаёт его целиком
+		w.Header().Set("ETag", `"v2"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(newData))
+	}))
+	defer server.Close()
+
+	dest := filepath.Join(t.TempDir(), "file.bin")
+	if err := DownloadFile(context.Background(), server.URL, dest, "", 0, 0); err != nil {
+		t.Fatalf("DownloadFile failed: %v", err)
+	}
+	got, _ := os.ReadFile(dest)
+	if !bytes.Equal(got, newData) {
+		t.Errorf("Expected the new file only, got %d bytes", len(got))
+	}
+}
+
+func TestDownloadFile_NoValidator(t *testing.T) {
+	data := []byte(strings.Repeat("x", 1000))
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("Range") != "" {
+			t.Errorf("Expected no Range without a validator, got %q", r.Header.Get("Range"))
+		}
+		if requests == 1 {
+			dropAfter(w, data, 300, "")
+		}
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	dest := filepath.Join(t.TempDir(), "file.bin")
+	if err := DownloadFile(context.Background(), server.URL, dest, "", 1, 0); err != nil {
+		t.Fatalf("DownloadFile failed: %v", err)
+	}
+	got, _ := os.ReadFile(dest)
+	if !bytes.Equal(got, data) {
+		t.Errorf("Expected %d bytes, got %d", len(data), len(got))
+	}
+}
+
+func TestDownloadFile_AllFailed(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "file.bin")
+	if err := DownloadFile(context.Background(), server.URL, dest, "", 2, 0); err == nil {
+		t.Fatal("Expected error after all retries failed")
+	}
+	if requests != 3 {
+		t.Errorf("Expected 3 requests, got %d", requests)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Expected no files left, got %d", len(entries))
+	}
+}
+
+func TestDownloadFileConditional(t *testing.T) {
+	data := []byte("geo data")
+	var inm []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inm = append(inm, r.Header.Get("If-None-Match"))
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	dest := filepath.Join(t.TempDir(), "file.csv")
+	got, err := DownloadFileConditional(context.Background(), server.URL, dest, "", 0, 0, Expect{}, Validators{})
+	if err != nil {
+		t.Fatalf("First download failed: %v", err)
+	}
+	if got.ETag != `"v1"` {
+		t.Errorf("Expected ETag \"v1\", got %q", got.ETag)
+	}
+
+	// Опубликованный файл не должен переписываться при 304
+	os.WriteFile(dest, []byte("published"), 0644)
+	again, err := DownloadFileConditional(context.Background(), server.URL, dest, "", 0, 0, Expect{}, got)
+	if err != ErrNotModified {
+		t.Fatalf("Expected ErrNotModified, got %v", err)
+	}
+	if again != got {
+		t.Errorf("Expected previous validators on 304, got %+v", again)
+	}
+	if content, _ := os.ReadFile(dest); string(content) != "published" {
+		t.Errorf("Expected file to be untouched on 304, got %q", content)
+	}
+	if len(inm) != 2 || inm[0] != "" || inm[1] != `"v1"` {
+		t.Errorf("Expected If-None-Match only on second request, got %q", inm)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(dest)); len(entries) != 1 {
+		t.Errorf("Expected no temp files left, got %d entries", len(entries))
+	}
+}
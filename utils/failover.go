@@ -0,0 +1,196 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// failoverCooldown is how long an upstream that failed is tried only after the healthy ones
+const failoverCooldown = 5 * time.Minute
+
+// UpstreamHealth is the state of one upstream base URL of a component
+type UpstreamHealth struct {
+	Base        string    `json:"base"`
+	Healthy     bool      `json:"healthy"`
+	Failures    int       `json:"failures"` // неудачных запросов подряд
+	LastError   string    `json:"last_error,omitempty"`
+	LastFailure time.Time `json:"last_failure,omitempty"`
+	LastSuccess time.Time `json:"last_success,omitempty"`
+}
+
+// healthy reports whether the upstream may be tried in its place of the list
+func (h *UpstreamHealth) healthy(now time.Time) bool {
+	return h.Failures == 0 || now.Sub(h.LastFailure) >= failoverCooldown
+}
+
+var failover = struct {
+	mu     sync.Mutex
+	lists  map[string][]string        // компонент -> базовые URL по порядку
+	health map[string]*UpstreamHealth // компонент + "\x00" + база
+	active map[string]string          // компонент -> база последнего успешного запроса
+}{lists: map[string][]string{}, health: map[string]*UpstreamHealth{}, active: map[string]string{}}
+
+// SetFailover replaces the failover lists: component name -> upstream base URLs in order of preference.
+// The health of upstreams that stay in the lists is kept.
+func SetFailover(lists map[string][]string) {
+	failover.mu.Lock()
+	defer failover.mu.Unlock()
+	failover.lists = map[string][]string{}
+	for component, bases := range lists {
+		for _, b := range bases {
+			failover.lists[component] = append(failover.lists[component], strings.TrimSuffix(b, "/"))
+		}
+	}
+}
+
+type upstreamComponentKey struct{}
+
+// WithUpstreamComponent marks requests made with ctx as requests of component:
+// they fail over along its list and are shown as its active upstream
+func WithUpstreamComponent(ctx context.Context, component string) context.Context {
+	return context.WithValue(ctx, upstreamComponentKey{}, component)
+}
+
+func upstreamComponent(ctx context.Context) string {
+	component, _ := ctx.Value(upstreamComponentKey{}).(string)
+	return component
+}
+
+// UpstreamStatus returns the upstream of the last successful request of component
+// (its base URL from the failover list, or scheme and host) and the health of its failover list
+func UpstreamStatus(component string) (string, []UpstreamHealth) {
+	failover.mu.Lock()
+	defer failover.mu.Unlock()
+	now := time.Now()
+	var list []UpstreamHealth
+	for _, base := range failover.lists[component] {
+		h := UpstreamHealth{Base: base}
+		if st, ok := failover.health[component+"\x00"+base]; ok {
+			h = *st
+		}
+		h.Healthy = h.healthy(now)
+		list = append(list, h)
+	}
+	return failover.active[component], list
+}
+
+// upstreamCandidate is one URL to try for a request
+type upstreamCandidate struct {
+	base string
+	url  *url.URL
+}
+
+// failoverCandidates returns the URLs to try for u: the same path under every base of the
+// component's list, healthy upstreams first. A URL outside the list has only itself.
+func failoverCandidates(component string, u *url.URL) []upstreamCandidate {
+	raw := u.String()
+	failover.mu.Lock()
+	defer failover.mu.Unlock()
+	bases := failover.lists[component]
+	matched := ""
+	for _, b := range bases {
+		if len(b) > len(matched) && underBase(raw, b) {
+			matched = b
+		}
+	}
+	if matched == "" {
+		return []upstreamCandidate{{base: u.Scheme + "://" + u.Host, url: u}}
+	}
+	rest := raw[len(matched):]
+
+	now := time.Now()
+	var healthy, failed []upstreamCandidate
+	for _, b := range bases {
+		cu, err := url.Parse(b + rest)
+		if err != nil {
+			continue
+		}
+		c := upstreamCandidate{base: b, url: cu}
+		if st, ok := failover.health[component+"\x00"+b]; ok && !st.healthy(now) {
+			failed = append(failed, c)
+		} else {
+			healthy = append(healthy, c)
+		}
+	}
+	// Упавшие upstream пробуем последними, а не пропускаем: вдруг они уже поднялись
+	return append(healthy, failed...)
+}
+
+// underBase reports whether raw is base itself or a path below it
+func underBase(raw, base string) bool {
+	if !strings.HasPrefix(raw, base) {
+		return false
+	}
+	rest := raw[len(base):]
+	return rest == "" || rest[0] == '/' || rest[0] == '?'
+}
+
+// recordUpstream updates the health of base after a request, err == nil is a success
+func recordUpstream(component, base string, err error) {
+	if component == "" {
+		return
+	}
+	failover.mu.Lock()
+	defer failover.mu.Unlock()
+	key := component + "\x00" + base
+	st, ok := failover.health[key]
+	if !ok {
+		st = &UpstreamHealth{Base: base}
+		failover.health[key] = st
+	}
+	now := time.Now()
+	if err != nil {
+		st.Failures++
+		st.LastError = err.Error()
+		st.LastFailure = now
+		return
+	}
+	st.Failures = 0
+	st.LastSuccess = now
+	failover.active[component] = base
+}
+
+// RoundTrip sends the request to the upstreams of the component in ctx in turn: a network error
+// or a retryable status (see RetryableStatus) moves on to the next one. Any other answer,
+// including 404, is returned as is. Requests outside a failover list are sent once.
+func (t *clientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	component := upstreamComponent(req.Context())
+	candidates := failoverCandidates(component, req.URL)
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		// Тело запроса нельзя отправить повторно
+		candidates = candidates[:1]
+	}
+	for i, c := range candidates {
+		r := req
+		if c.url != req.URL {
+			r = req.Clone(req.Context())
+			r.URL, r.Host = c.url, c.url.Host
+		}
+		resp, err := t.roundTrip(r)
+		var failure error
+		if err != nil {
+			failure = err
+		} else if RetryableStatus(resp.StatusCode) {
+			failure = fmt.Errorf("bad status: %d", resp.StatusCode)
+		}
+		if req.Context().Err() != nil {
+			// Отмена вызывающим ничего не говорит о здоровье upstream
+			return resp, err
+		}
+		recordUpstream(component, c.base, failure)
+		if failure == nil || i == len(candidates)-1 {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+			resp.Body.Close()
+		}
+	}
+	return nil, fmt.Errorf("no upstream for %s", req.URL)
+}
@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// resetFailover очищает списки и здоровье upstream между тестами
+func resetFailover(t *testing.T, lists map[string][]string) {
+	t.Helper()
+	reset := func() {
+		SetFailover(nil)
+		failover.mu.Lock()
+		failover.health = map[string]*UpstreamHealth{}
+		failover.active = map[string]string{}
+		failover.mu.Unlock()
+	}
+	reset()
+	SetFailover(lists)
+	t.Cleanup(reset)
+}
+
+// failoverClient отвечает статусом из statuses по хосту запроса и запоминает запрошенные URL
+func failoverClient(t *testing.T, statuses map[string]int, requested *[]string) *http.Client {
+	t.Helper()
+	c := NewClient(ClientConfig{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		*requested = append(*requested, r.URL.String())
+		code, ok := statuses[r.URL.Host]
+		if !ok {
+			return nil, &url.Error{Op: "Get", URL: r.URL.String(), Err: io.ErrUnexpectedEOF}
+		}
+		return &http.Response{StatusCode: code, Body: io.NopCloser(strings.NewReader(r.URL.Host)), Request: r}, nil
+	})})
+	client, err := c.HTTPClient("", 0)
+	if err != nil {
+		t.Fatalf("HTTPClient failed: %v", err)
+	}
+	return client
+}
+
+func TestFailover_NextUpstream(t *testing.T) {
+	resetFailover(t, map[string][]string{"bitdefender": {"http://primary.test", "http://down.test/", "http://mirror.test/bd"}})
+	var requested []string
+	client := failoverClient(t, map[string]int{"primary.test": 503, "mirror.test": 200}, &requested)
+
+	ctx := WithUpstreamComponent(context.Background(), "bitdefender")
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://primary.test/av64bit/versions.id?x=1", nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 200 || string(body) != "mirror.test" {
+		t.Errorf("Expected 200 from mirror.test, got %d from %s", resp.StatusCode, body)
+	}
+	want := []string{
+		"http://primary.test/av64bit/versions.id?x=1",
+		"http://down.test/av64bit/versions.id?x=1",
+		"http://mirror.test/bd/av64bit/versions.id?x=1",
+	}
+	if strings.Join(requested, " ") != strings.Join(want, " ") {
+		t.Errorf("Expected requests %v, got %v", want, requested)
+	}
+
+	active, list := UpstreamStatus("bitdefender")
+	if active != "http://mirror.test/bd" {
+		t.Errorf("Expected active upstream http://mirror.test/bd, got %q", active)
+	}
+	if len(list) != 3 || list[0].Healthy || list[1].Healthy || !list[2].Healthy || list[0].Failures != 1 {
+		t.Errorf("Expected primary and down.test unhealthy, got %+v", list)
+	}
+
+	// Упавшие upstream теперь пробуются последними
+	requested = nil
+	req, _ = http.NewRequestWithContext(ctx, http.MethodGet, "http://primary.test/file", nil)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if len(requested) != 1 || requested[0] != "http://mirror.test/bd/file" {
+		t.Errorf("Expected the healthy mirror to be tried first, got %v", requested)
+	}
+}
+
+func TestFailover_Cooldown(t *testing.T) {
+	resetFailover(t, map[string][]string{"ids": {"http://primary.test", "http://mirror.test"}})
+	recordUpstream("ids", "http://primary.test", io.ErrUnexpectedEOF)
+
+	u, _ := url.Parse("http://mirror.test/file")
+	if got := failoverCandidates("ids", u); got[0].base != "http://mirror.test" || got[1].url.String() != "http://primary.test/file" {
+		t.Errorf("Expected failed primary last, got %+v", got)
+	}
+
+	// После паузы упавший upstream возвращается на своё место
+	failover.mu.Lock()
+	failover.health["ids\x00http://primary.test"].LastFailure = time.Now().Add(-failoverCooldown)
+	failover.mu.Unlock()
+	if got := failoverCandidates("ids", u); got[0].base != "http://primary.test" {
+		t.Errorf("Expected primary first after cooldown, got %+v", got)
+	}
+}
+
+func TestFailover_ClientErrorNotRetried(t *testing.T) {
+	resetFailover(t, map[string][]string{"ids": {"http://primary.test", "http://mirror.test"}})
+	var requested []string
+	client := failoverClient(t, map[string]int{"primary.test": 404, "mirror.test": 200}, &requested)
+
+	req, _ := http.NewRequestWithContext(WithUpstreamComponent(context.Background(), "ids"), http.MethodGet, "http://primary.test/file", nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 404 || len(requested) != 1 {
+		t.Errorf("Expected 404 without failover, got %d after %v", resp.StatusCode, requested)
+	}
+	if active, _ := UpstreamStatus("ids"); active != "http://primary.test" {
+		t.Errorf("Expected active upstream http://primary.test, got %q", active)
+	}
+}
+
+func TestFailover_OutsideList(t *testing.T) {
+	resetFailover(t, map[string][]string{"ids": {"http://primary.test", "http://mirror.test"}})
+	var requested []string
+	client := failoverClient(t, map[string]int{"other.test": 503}, &requested)
+
+	// Базу "http://primary.test" не должен принять за префикс "http://primary.testing"
+	for _, u := range []string{"http://other.test/file", "http://primary.testing/file"} {
+		requested = nil
+		req, _ := http.NewRequestWithContext(WithUpstreamComponent(context.Background(), "ids"), http.MethodGet, u, nil)
+		resp, err := client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if len(requested) != 1 {
+			t.Errorf("%s: Expected a single request, got %v", u, requested)
+		}
+	}
+}
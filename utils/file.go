@@ -4,9 +4,11 @@ import (
 	"bufio"
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"encoding/xml"
 	"io"
+	"net/http"
 	"os"
 	"path/filepath"
 	"sort"
@@ -17,19 +19,30 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-// SaveResponseToFile saves HTTP response body to the given path
+// SaveResponseToFile saves HTTP response body to the given path.
+// The body is written to a temporary file next to destPath and renamed on success,
+// so an interrupted download never replaces the existing file.
 func SaveResponseToFile(body io.ReadCloser, destPath string) error {
+	return saveAtomic(body, destPath, Expect{})
+}
+
+// SaveResponse saves resp.Body like SaveResponseToFile and checks it against
+// the Content-Length and hashes announced in the response before publishing.
+func SaveResponse(resp *http.Response, destPath string) error {
+	return saveAtomic(resp.Body, destPath, ExpectFromResponse(resp))
+}
+
+func saveAtomic(body io.ReadCloser, destPath string, exp Expect) error {
 	defer body.Close()
-	if err := os.MkdirAll(filepath.Dir(destPath), 0750); err != nil {
+	out, err := CreateAtomic(destPath)
+	if err != nil {
 		return err
 	}
-	out, err := os.Create(destPath)
-	if err != nil {
+	defer out.Abort()
+	if _, err := io.Copy(out, body); err != nil {
 		return err
 	}
-	defer out.Close()
-	_, err = io.Copy(out, body)
-	return err
+	return out.Commit(exp)
 }
 
 // CleanupOldFiles removes files older than maxAgeDays or exceeding maxFiles per subdir
@@ -101,19 +114,13 @@ func AtoiSafe(s string) int {
 	return n
 }
 
-// DownloadFileWithProxy downloads a file with optional proxy and retry
-func DownloadFileWithProxy(url, destPath, proxyURL string, retries int, delay time.Duration, logger *logrus.Logger) bool {
-	resp, err := HTTPGetWithRetry(url, retries, delay, proxyURL)
-	if err != nil {
+// DownloadFileWithProxy downloads a file with optional proxy and retry.
+// Retries after a dropped connection resume the partial file, see DownloadFile.
+func DownloadFileWithProxy(ctx context.Context, url, destPath, proxyURL string, retries int, delay time.Duration, logger *logrus.Logger) bool {
+	if err := DownloadFile(ctx, url, destPath, proxyURL, retries, delay); err != nil {
 		logger.Errorf("Download error: %v", err)
 		return false
 	}
-	defer resp.Body.Close()
-	err = SaveResponseToFile(resp.Body, destPath)
-	if err != nil {
-		logger.Errorf("Save file error: %v", err)
-		return false
-	}
 	return true
 }
 
@@ -146,3 +153,17 @@ func ExtractFirstFileFromGzip(path string) ([]byte, error) {
 	}
 	return buf.Bytes(), nil
 }
+
+// FormatBytes formats a byte count for humans: 512 B, 1.5 KB, 20.0 MB
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return strconv.FormatInt(n, 10) + " B"
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return strconv.FormatFloat(float64(n)/float64(div), 'f', 1, 64) + " " + string("KMGTPE"[exp]) + "B"
+}
@@ -2,7 +2,8 @@ package utils
 
 import (
 	"context"
-	"errors"
+	"crypto/tls"
+	"fmt"
 	"net"
 	"net/http"
 	"net/url"
@@ -13,8 +14,10 @@ import (
 )
 
 // createTransport builds an http.Transport with HTTP/HTTPS or SOCKS5 proxy support.
-func createTransport(proxyURL string) (*http.Transport, error) {
-	transport := &http.Transport{}
+// tc sets TLS of the upstream connections, nil keeps the defaults. dial opens the connections
+// to upstream or to the proxy, nil is the default dialer.
+func createTransport(proxyURL string, tc *tls.Config, dial func(ctx context.Context, network, addr string) (net.Conn, error)) (*http.Transport, error) {
+	transport := &http.Transport{TLSClientConfig: tc, DialContext: dial}
 	if proxyURL == "" {
 		return transport, nil
 	}
@@ -33,7 +36,11 @@ func createTransport(proxyURL string) (*http.Transport, error) {
 				auth.Password = pass
 			}
 		}
-		dialer, err := proxy.SOCKS5("tcp", parsed.Host, auth, proxy.Direct)
+		var forward proxy.Dialer = proxy.Direct
+		if dial != nil {
+			forward = contextDialer(dial)
+		}
+		dialer, err := proxy.SOCKS5("tcp", parsed.Host, auth, forward)
 		if err != nil {
 			return nil, err
 		}
@@ -51,38 +58,105 @@ func createTransport(proxyURL string) (*http.Transport, error) {
 	return transport, nil
 }
 
-// CreateHTTPClient creates an HTTP client with proxy support (HTTP/HTTPS or SOCKS5) and configurable timeout.
-func CreateHTTPClient(proxyURL string, timeout time.Duration) (*http.Client, error) {
-	transport, err := createTransport(proxyURL)
+// HTTPGetWithRetry performs GET with retries, supports HTTP/HTTPS and SOCKS5 proxy.
+// The request and the pauses between attempts stop as soon as ctx is cancelled.
+// Reading the body is throttled by the upstream limits (see LimitBody).
+func HTTPGetWithRetry(ctx context.Context, urlStr string, retries int, delay time.Duration, proxyURL string) (*http.Response, error) {
+	resp, err := GetWithRetry(ctx, upstreamClient(proxyURL, 0), urlStr, retries, delay)
 	if err != nil {
 		return nil, err
 	}
-	if timeout == 0 {
-		timeout = 300 * time.Second
-	}
-	return &http.Client{
-		Timeout:   timeout,
-		Transport: transport,
-	}, nil
+	TrackResponse(ctx, resp)
+	resp.Body = LimitBody(ctx, resp.Body)
+	return resp, nil
 }
 
-// HTTPGetWithRetry performs GET with retries, supports HTTP/HTTPS and SOCKS5 proxy.
-func HTTPGetWithRetry(urlStr string, retries int, delay time.Duration, proxyURL string) (*http.Response, error) {
-	transport, err := createTransport(proxyURL)
-	if err != nil {
-		transport = &http.Transport{}
-	}
-	client := &http.Client{Timeout: 60 * time.Second, Transport: transport}
-	var resp *http.Response
-	for i := 0; i <= retries; i++ {
-		resp, err = client.Get(urlStr)
+// GetWithRetry performs GET with client until it gets 200 OK. Failed attempts are repeated up to
+// retries times with exponential backoff from delay (see Backoff), a Retry-After of the response
+// is honored. Statuses that will not change on retry (see RetryableStatus) fail at once.
+// The error is an *HTTPError with the last status and cause, or the cancellation of ctx.
+func GetWithRetry(ctx context.Context, client *http.Client, urlStr string, retries int, delay time.Duration) (*http.Response, error) {
+	herr := &HTTPError{URL: urlStr}
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, urlStr, nil)
+		if err != nil {
+			return nil, err
+		}
+		herr.Attempts++
+		resp, err := client.Do(req)
 		if err == nil && resp.StatusCode == http.StatusOK {
 			return resp, nil
 		}
-		if resp != nil {
+		if err == nil {
+			herr.StatusCode = resp.StatusCode
+			err = newStatusError(resp)
 			resp.Body.Close()
 		}
-		time.Sleep(delay)
+		herr.Err = err
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("GET %s cancelled: %w", urlStr, ctx.Err())
+		}
+		wait, retry := retryWait(err, herr.Attempts, delay)
+		if !retry || herr.Attempts > retries {
+			return nil, herr
+		}
+		if err := SleepContext(ctx, wait); err != nil {
+			return nil, fmt.Errorf("GET %s cancelled: %w", urlStr, err)
+		}
 	}
-	return nil, errors.New("failed to GET " + urlStr)
+}
+
+// HTTPHead performs a single HEAD request and returns the response with its body closed.
+// A non-2xx status is returned as an error.
+func HTTPHead(ctx context.Context, urlStr string, proxyURL string) (*http.Response, error) {
+	client, err := SharedClient().HTTPClient(proxyURL, 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, urlStr, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("HEAD %s: %w", urlStr, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp, fmt.Errorf("HEAD %s: bad status: %d", urlStr, resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// upstreamClient returns a client of the shared pool. A proxy URL that cannot be parsed
+// is ignored and the request goes direct, as the downloads always did.
+func upstreamClient(proxyURL string, timeout time.Duration) *http.Client {
+	client, err := SharedClient().HTTPClient(proxyURL, timeout)
+	if err != nil {
+		client, _ = SharedClient().HTTPClient("", timeout)
+	}
+	return client
+}
+
+// SleepContext pauses for d or until ctx is cancelled, whichever comes first.
+func SleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// contextDialer adapts a dial function to proxy.Dialer
+type contextDialer func(ctx context.Context, network, addr string) (net.Conn, error)
+
+func (d contextDialer) Dial(network, addr string) (net.Conn, error) {
+	return d(context.Background(), network, addr)
+}
+
+func (d contextDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d(ctx, network, addr)
 }
@@ -1,56 +1,15 @@
 package utils
 
 import (
+	"context"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
 )
 
-func TestCreateHTTPClient_WithoutProxy(t *testing.T) {
-	client, err := CreateHTTPClient("", 60*time.Second)
-	if err != nil {
-		t.Fatalf("CreateHTTPClient failed: %v", err)
-	}
-
-	if client == nil {
-		t.Fatal("Expected non-nil client")
-	}
-
-	if client.Timeout != 60*time.Second {
-		t.Errorf("Expected timeout 60s, got %v", client.Timeout)
-	}
-}
-
-func TestCreateHTTPClient_WithProxy(t *testing.T) {
-	proxyURL := "http://proxy.example.com:8080"
-	client, err := CreateHTTPClient(proxyURL, 60*time.Second)
-	if err != nil {
-		t.Fatalf("CreateHTTPClient with proxy failed: %v", err)
-	}
-
-	if client == nil {
-		t.Fatal("Expected non-nil client")
-	}
-
-	transport, ok := client.Transport.(*http.Transport)
-	if !ok {
-		t.Fatal("Expected *http.Transport")
-	}
-
-	if transport.Proxy == nil {
-		t.Error("Expected proxy to be configured")
-	}
-}
-
-func TestCreateHTTPClient_InvalidProxy(t *testing.T) {
-	proxyURL := "://invalid-url"
-	_, err := CreateHTTPClient(proxyURL, 60*time.Second)
-	if err == nil {
-		t.Error("Expected error for invalid proxy URL")
-	}
-}
-
 func TestHTTPGetWithRetry_Success(t *testing.T) {
 	// Create test server
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
@@ -60,7 +19,7 @@ func TestHTTPGetWithRetry_Success(t *testing.T) {
 	defer server.Close()
 
 	// Test successful request
-	resp, err := HTTPGetWithRetry(server.URL, 3, 100*time.Millisecond, "")
+	resp, err := HTTPGetWithRetry(context.Background(), server.URL, 3, 100*time.Millisecond, "")
 	if err != nil {
 		t.Fatalf("HTTPGetWithRetry failed: %v", err)
 	}
@@ -84,7 +43,7 @@ func TestHTTPGetWithRetry_FailureThenSuccess(t *testing.T) {
 	defer server.Close()
 
 	// Should succeed on second attempt
-	resp, err := HTTPGetWithRetry(server.URL, 3, 10*time.Millisecond, "")
+	resp, err := HTTPGetWithRetry(context.Background(), server.URL, 3, 10*time.Millisecond, "")
 	if err != nil {
 		t.Fatalf("HTTPGetWithRetry failed: %v", err)
 	}
@@ -106,22 +65,64 @@ func TestHTTPGetWithRetry_AllFailed(t *testing.T) {
 	defer server.Close()
 
 	// Should fail after all retries
-	_, err := HTTPGetWithRetry(server.URL, 2, 10*time.Millisecond, "")
+	_, err := HTTPGetWithRetry(context.Background(), server.URL, 2, 10*time.Millisecond, "")
 	if err == nil {
 		t.Error("Expected error after all retries failed")
 	}
 }
 
 func TestHTTPGetWithRetry_InvalidURL(t *testing.T) {
-	_, err := HTTPGetWithRetry("http://invalid-domain-that-does-not-exist-12345.com", 1, 10*time.Millisecond, "")
+	_, err := HTTPGetWithRetry(context.Background(), "http://invalid-domain-that-does-not-exist-12345.com", 1, 10*time.Millisecond, "")
 	if err == nil {
 		t.Error("Expected error for invalid URL")
 	}
 }
 
-func BenchmarkCreateHTTPClient(b *testing.B) {
-	for i := 0; i < b.N; i++ {
-		CreateHTTPClient("", 60*time.Second)
+func TestHTTPGetWithRetry_Cancelled(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := HTTPGetWithRetry(ctx, server.URL, 5, time.Second, "")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected retries to stop right after cancel, took %v", elapsed)
+	}
+	if attempts != 1 {
+		t.Errorf("Expected 1 attempt, got %d", attempts)
+	}
+}
+
+func TestHTTPGetWithRetry_DownloadStats(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("0123456789"))
+	}))
+	defer server.Close()
+
+	ctx, stats := WithDownloadStats(context.Background())
+	for i := 0; i < 2; i++ {
+		resp, err := HTTPGetWithRetry(ctx, server.URL, 0, 0, "")
+		if err != nil {
+			t.Fatalf("HTTPGetWithRetry failed: %v", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	if stats.Files() != 2 {
+		t.Errorf("Expected 2 files, got %d", stats.Files())
+	}
+	if stats.Bytes() != 20 {
+		t.Errorf("Expected 20 bytes, got %d", stats.Bytes())
 	}
 }
 
@@ -133,7 +134,7 @@ func BenchmarkHTTPGetWithRetry(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		resp, _ := HTTPGetWithRetry(server.URL, 1, 10*time.Millisecond, "")
+		resp, _ := HTTPGetWithRetry(context.Background(), server.URL, 1, 10*time.Millisecond, "")
 		if resp != nil {
 			resp.Body.Close()
 		}
@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"net"
+	"net/url"
+	"strings"
+)
+
+// Политики выбора прокси
+const (
+	ProxyPolicyProxy    = "proxy"    // через прокси, если он задан
+	ProxyPolicyDirect   = "direct"   // всегда напрямую
+	ProxyPolicyFallback = "fallback" // сначала напрямую, при сетевой ошибке через прокси
+)
+
+// ValidProxyPolicy reports whether p is one of the proxy policies
+func ValidProxyPolicy(p string) bool {
+	return p == ProxyPolicyProxy || p == ProxyPolicyDirect || p == ProxyPolicyFallback
+}
+
+// ProxyRoute is the proxy setting of one component. Empty fields inherit the global ones.
+type ProxyRoute struct {
+	URL    string // свой прокси компонента
+	Policy string
+}
+
+// ProxyConfig selects the proxy of each request by its component and host
+type ProxyConfig struct {
+	Policy     string                // политика по умолчанию, пусто - proxy
+	Components map[string]ProxyRoute // переопределения по компонентам
+	NoProxy    []string              // хосты, домены, IP и подсети, к которым всегда идём напрямую
+}
+
+// proxyRoutes returns the proxies to try for a request of component to host in turn, "" is direct.
+// proxyURL is the proxy of the caller, a component proxy replaces it.
+func (c *Client) proxyRoutes(component, host, proxyURL string) []string {
+	pc := c.cfg.Proxy
+	r := pc.Components[component]
+	proxyURL = firstNonEmpty(r.URL, proxyURL)
+	policy := firstNonEmpty(r.Policy, pc.Policy, ProxyPolicyProxy)
+	if proxyURL == "" || policy == ProxyPolicyDirect || matchNoProxy(host, pc.NoProxy) {
+		return []string{""}
+	}
+	if policy == ProxyPolicyFallback {
+		return []string{"", proxyURL}
+	}
+	return []string{proxyURL}
+}
+
+// ProxyRoute describes how a request of component to host is sent, for logs:
+// "direct", the proxy URL without password, or "direct, then <proxy>"
+func (c *Client) ProxyRoute(component, host, proxyURL string) string {
+	var parts []string
+	for _, p := range c.proxyRoutes(component, strings.ToLower(host), proxyURL) {
+		if p == "" {
+			parts = append(parts, "direct")
+		} else if u, err := url.Parse(p); err == nil {
+			parts = append(parts, u.Redacted())
+		} else {
+			parts = append(parts, "invalid proxy URL")
+		}
+	}
+	return strings.Join(parts, ", then ")
+}
+
+// matchNoProxy reports whether host is in the NO_PROXY list. An entry is "*", an IP, a subnet in CIDR
+// notation or a domain, which also covers its subdomains ("example.com", ".example.com" and
+// "*.example.com" are the same).
+func matchNoProxy(host string, list []string) bool {
+	ip := net.ParseIP(host)
+	for _, e := range list {
+		e = strings.ToLower(strings.TrimSpace(e))
+		switch {
+		case e == "":
+		case e == "*":
+			return true
+		case strings.Contains(e, "/"):
+			if _, n, err := net.ParseCIDR(e); err == nil && ip != nil && n.Contains(ip) {
+				return true
+			}
+		case ip != nil:
+			if eip := net.ParseIP(e); eip != nil && eip.Equal(ip) {
+				return true
+			}
+		default:
+			e = strings.TrimPrefix(strings.TrimPrefix(e, "*"), ".")
+			if host == e || strings.HasSuffix(host, "."+e) {
+				return true
+			}
+		}
+	}
+	return false
+}
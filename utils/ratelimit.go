@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// limitChunk is the largest piece read or written before waiting for the limiters,
+// so throttled transfers stay smooth instead of sleeping for one big buffer
+const limitChunk = 16 * 1024
+
+// RateLimiter limits throughput in bytes per second. Zero rate means no limit.
+// One limiter may be shared by any number of transfers, they split the rate between them.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   int64
+	tokens float64 // доступные байты, отрицательное значение - долг, который надо переждать
+	last   time.Time
+}
+
+// NewRateLimiter creates a limiter for bytesPerSec (0 - unlimited)
+func NewRateLimiter(bytesPerSec int64) *RateLimiter {
+	return &RateLimiter{rate: bytesPerSec, last: time.Now()}
+}
+
+// SetRate changes the limit, transfers in progress pick it up with their next chunk
+func (l *RateLimiter) SetRate(bytesPerSec int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate != bytesPerSec {
+		l.rate, l.tokens, l.last = bytesPerSec, 0, time.Now()
+	}
+}
+
+// Rate returns the current limit in bytes per second
+func (l *RateLimiter) Rate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// WaitN accounts n transferred bytes and sleeps as long as needed to keep the rate.
+// The unused allowance is capped at one second, so an idle limiter allows only a short burst.
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	rate := float64(l.rate)
+	l.tokens += now.Sub(l.last).Seconds() * rate
+	if l.tokens > rate {
+		l.tokens = rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	return SleepContext(ctx, wait)
+}
+
+// upstreamLimiter is the global limit for all upstream downloads
+var upstreamLimiter = NewRateLimiter(0)
+
+// SetUpstreamLimit sets the global upstream limit in bytes per second (0 - unlimited)
+func SetUpstreamLimit(bytesPerSec int64) {
+	upstreamLimiter.SetRate(bytesPerSec)
+}
+
+type rateLimitKey struct{}
+
+// WithRateLimit returns a context whose upstream downloads are also limited by l,
+// in addition to the global limit and the limiters already attached to ctx
+func WithRateLimit(ctx context.Context, l *RateLimiter) context.Context {
+	parent, _ := ctx.Value(rateLimitKey{}).([]*RateLimiter)
+	for _, p := range parent {
+		if p == l {
+			return ctx
+		}
+	}
+	limiters := append(append([]*RateLimiter(nil), parent...), l)
+	return context.WithValue(ctx, rateLimitKey{}, limiters)
+}
+
+// RateLimited reports whether upstream downloads made with ctx are throttled by any limit
+func RateLimited(ctx context.Context) bool {
+	if upstreamLimiter.Rate() > 0 {
+		return true
+	}
+	limiters, _ := ctx.Value(rateLimitKey{}).([]*RateLimiter)
+	for _, l := range limiters {
+		if l.Rate() > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// LimitBody wraps an upstream response body with the global limit and the limiters of ctx
+func LimitBody(ctx context.Context, body io.ReadCloser) io.ReadCloser {
+	limiters, _ := ctx.Value(rateLimitKey{}).([]*RateLimiter)
+	return &limitedBody{ReadCloser: body, ctx: ctx, limiters: append([]*RateLimiter{upstreamLimiter}, limiters...)}
+}
+
+type limitedBody struct {
+	io.ReadCloser
+	ctx      context.Context
+	limiters []*RateLimiter
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if len(p) > limitChunk {
+		p = p[:limitChunk]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if werr := WaitAll(b.ctx, n, b.limiters...); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+// LimitWriter returns a writer that keeps writes to w within all limiters
+func LimitWriter(ctx context.Context, w io.Writer, limiters ...*RateLimiter) io.Writer {
+	return &limitedWriter{w: w, ctx: ctx, limiters: limiters}
+}
+
+type limitedWriter struct {
+	w        io.Writer
+	ctx      context.Context
+	limiters []*RateLimiter
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > limitChunk {
+			chunk = chunk[:limitChunk]
+		}
+		if err := WaitAll(lw.ctx, len(chunk), lw.limiters...); err != nil {
+			return written, err
+		}
+		n, err := lw.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// WaitAll waits on every limiter for n bytes
+func WaitAll(ctx context.Context, n int, limiters ...*RateLimiter) error {
+	for _, l := range limiters {
+		if err := l.WaitN(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
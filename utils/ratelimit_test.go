@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_LimitBody(t *testing.T) {
+	data := make([]byte, 64*1024)
+	l := NewRateLimiter(128 * 1024)
+	ctx := WithRateLimit(context.Background(), l)
+	// Повторное подключение того же лимитера не должно замедлять загрузку вдвое
+	ctx = WithRateLimit(ctx, l)
+
+	start := time.Now()
+	n, err := io.Copy(io.Discard, LimitBody(ctx, io.NopCloser(bytes.NewReader(data))))
+	elapsed := time.Since(start)
+	if err != nil || n != int64(len(data)) {
+		t.Fatalf("Expected %d bytes, got %d (%v)", len(data), n, err)
+	}
+	// 64 КБ при 128 КБ/с - около полсекунды
+	if elapsed < 400*time.Millisecond || elapsed > 900*time.Millisecond {
+		t.Errorf("Expected about 500ms, took %v", elapsed)
+	}
+}
+
+func TestRateLimiter_Unlimited(t *testing.T) {
+	var buf bytes.Buffer
+	w := LimitWriter(context.Background(), &buf, NewRateLimiter(0), nil)
+	start := time.Now()
+	if _, err := w.Write(make([]byte, 1024*1024)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Expected no throttling, took %v", elapsed)
+	}
+	if buf.Len() != 1024*1024 {
+		t.Errorf("Expected 1 MB written, got %d", buf.Len())
+	}
+}
+
+func TestRateLimiter_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	w := LimitWriter(ctx, io.Discard, NewRateLimiter(1024))
+	start := time.Now()
+	if _, err := w.Write(make([]byte, 64*1024)); err == nil {
+		t.Error("Expected error after cancel")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected write to stop right after cancel, took %v", elapsed)
+	}
+}
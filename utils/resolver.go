@@ -0,0 +1,241 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ErrSelfLoop is returned when an upstream host resolves to an address the mirror itself listens on:
+// the DNS that points Kerio update names at the mirror is also used by the mirror
+var ErrSelfLoop = errors.New("resolves to this mirror")
+
+// ResolverConfig overrides name resolution of upstream hosts
+type ResolverConfig struct {
+	Hosts map[string][]string // хост -> IP, как /etc/hosts
+	// DNS - свой DNS-сервер ("10.0.0.53:53") или DoH ("https://1.1.1.1/dns-query", http:// - в локальной сети),
+	// пусто - системный
+	DNS string
+}
+
+var listenAddrs = struct {
+	mu    sync.RWMutex
+	addrs []string
+}{}
+
+// SetListenAddrs sets the addresses the mirror listens on (":80", "10.0.0.5:443").
+// Connections to them are refused, an empty host covers all local addresses.
+func SetListenAddrs(addrs ...string) {
+	listenAddrs.mu.Lock()
+	defer listenAddrs.mu.Unlock()
+	listenAddrs.addrs = addrs
+}
+
+// isSelfAddr reports whether ip:port is one of the listen addresses of the mirror
+func isSelfAddr(ip net.IP, port string) bool {
+	listenAddrs.mu.RLock()
+	addrs := listenAddrs.addrs
+	listenAddrs.mu.RUnlock()
+	for _, a := range addrs {
+		host, p, err := net.SplitHostPort(a)
+		if err != nil || p != port {
+			continue
+		}
+		lip := net.ParseIP(host)
+		switch {
+		case host == "" || (lip != nil && lip.IsUnspecified()):
+			if isLocalIP(ip) {
+				return true
+			}
+		case lip != nil && lip.Equal(ip):
+			return true
+		}
+	}
+	return false
+}
+
+// isLocalIP reports whether ip belongs to this machine
+func isLocalIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() {
+		return true
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup returns the addresses of host: a static entry, the configured DNS server or DoH,
+// or the system resolver
+func (c *Client) lookup(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	rc := c.cfg.Resolver
+	if ips, ok := rc.Hosts[strings.ToLower(host)]; ok {
+		var res []net.IP
+		for _, s := range ips {
+			if ip := net.ParseIP(s); ip != nil {
+				res = append(res, ip)
+			}
+		}
+		return res, nil
+	}
+	switch {
+	case rc.DNS == "":
+		return lookupWith(ctx, net.DefaultResolver, host)
+	case strings.HasPrefix(rc.DNS, "https://"), strings.HasPrefix(rc.DNS, "http://"):
+		return c.lookupDoH(ctx, host)
+	default:
+		server := rc.DNS
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		r := &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		}}
+		return lookupWith(ctx, r, host)
+	}
+}
+
+func lookupWith(ctx context.Context, r *net.Resolver, host string) ([]net.IP, error) {
+	addrs, err := r.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, 0, len(addrs))
+	for _, a := range addrs {
+		ips = append(ips, a.IP)
+	}
+	return ips, nil
+}
+
+// lookupDoH resolves host with DNS over HTTPS (RFC 8484). The DoH server itself is reached
+// with the system resolver, so it is best given as an IP.
+func (c *Client) lookupDoH(ctx context.Context, host string) ([]net.IP, error) {
+	c.mu.Lock()
+	if c.doh == nil {
+		tc, err := c.cfg.TLS.Build()
+		if err != nil {
+			c.mu.Unlock()
+			return nil, err
+		}
+		c.doh = &http.Client{Timeout: 10 * time.Second, Transport: &http.Transport{TLSClientConfig: tc, ForceAttemptHTTP2: true}}
+	}
+	client := c.doh
+	c.mu.Unlock()
+
+	var ips []net.IP
+	var lastErr error
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		found, err := dohQuery(ctx, client, c.cfg.Resolver.DNS, host, qtype)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		ips = append(ips, found...)
+	}
+	if len(ips) == 0 {
+		if lastErr == nil {
+			lastErr = fmt.Errorf("no addresses")
+		}
+		return nil, fmt.Errorf("DoH lookup %s: %w", host, lastErr)
+	}
+	return ips, nil
+}
+
+func dohQuery(ctx context.Context, client *http.Client, endpoint, host string, qtype dnsmessage.Type) ([]net.IP, error) {
+	name, err := dnsmessage.NewName(strings.TrimSuffix(host, ".") + ".")
+	if err != nil {
+		return nil, err
+	}
+	q := dnsmessage.Message{
+		Header:    dnsmessage.Header{RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := q.Pack()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(packed))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bad status: %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, err
+	}
+	var m dnsmessage.Message
+	if err := m.Unpack(body); err != nil {
+		return nil, err
+	}
+	if m.RCode != dnsmessage.RCodeSuccess {
+		return nil, fmt.Errorf("DNS error: %v", m.RCode)
+	}
+	var ips []net.IP
+	for _, a := range m.Answers {
+		switch r := a.Body.(type) {
+		case *dnsmessage.AResource:
+			ips = append(ips, net.IP(r.A[:]))
+		case *dnsmessage.AAAAResource:
+			ips = append(ips, net.IP(r.AAAA[:]))
+		}
+	}
+	return ips, nil
+}
+
+// dialContext connects to addr using the resolver override and refuses addresses of the mirror itself
+func (c *Client) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := c.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	d := net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	lastErr := fmt.Errorf("lookup %s: no addresses", host)
+	for _, ip := range ips {
+		if isSelfAddr(ip, port) {
+			// Адрес самого mirror пропускаем, вдруг у имени есть и настоящие адреса
+			lastErr = fmt.Errorf("%s (%s) %w, set the upstream address with UPSTREAM_HOSTS or UPSTREAM_DNS", host, ip, ErrSelfLoop)
+			continue
+		}
+		conn, err := d.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, lastErr
+}
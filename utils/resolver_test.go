@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// upstreamServer отвечает "ok" и возвращает свой порт
+func upstreamServer(t *testing.T) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok from " + r.Host))
+	}))
+	t.Cleanup(srv.Close)
+	u, _ := url.Parse(srv.URL)
+	return u.Port()
+}
+
+func resolverGet(t *testing.T, rc ResolverConfig, rawURL string) (string, error) {
+	t.Helper()
+	client, err := NewClient(ClientConfig{Resolver: rc}).HTTPClient("", 0)
+	if err != nil {
+		t.Fatalf("HTTPClient failed: %v", err)
+	}
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body), nil
+}
+
+func TestResolver_StaticHosts(t *testing.T) {
+	port := upstreamServer(t)
+	rc := ResolverConfig{Hosts: map[string][]string{"ids-update.kerio.test": {"127.0.0.1"}}}
+	body, err := resolverGet(t, rc, "http://IDS-Update.kerio.test:"+port+"/file")
+	if err != nil {
+		t.Fatalf("Expected static entry to be used, got %v", err)
+	}
+	// Host запроса остаётся прежним, меняется только адрес соединения
+	if body != "ok from IDS-Update.kerio.test:"+port {
+		t.Errorf("Expected request with the original host, got %q", body)
+	}
+}
+
+func TestResolver_SelfLoop(t *testing.T) {
+	port := upstreamServer(t)
+	SetListenAddrs(":" + port)
+	t.Cleanup(func() { SetListenAddrs() })
+
+	rc := ResolverConfig{Hosts: map[string][]string{"bdupdate.kerio.test": {"127.0.0.1"}}}
+	if _, err := resolverGet(t, rc, "http://bdupdate.kerio.test:"+port+"/file"); !errors.Is(err, ErrSelfLoop) {
+		t.Errorf("Expected ErrSelfLoop, got %v", err)
+	}
+	if _, err := resolverGet(t, ResolverConfig{}, "http://127.0.0.1:"+port+"/file"); !errors.Is(err, ErrSelfLoop) {
+		t.Errorf("Expected ErrSelfLoop for an IP, got %v", err)
+	}
+
+	// Другой порт того же хоста - не сам mirror
+	SetListenAddrs("127.0.0.1:1")
+	if _, err := resolverGet(t, rc, "http://bdupdate.kerio.test:"+port+"/file"); err != nil {
+		t.Errorf("Expected success on another port, got %v", err)
+	}
+}
+
+func TestResolver_DoH(t *testing.T) {
+	port := upstreamServer(t)
+	var asked []string
+	doh := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var q dnsmessage.Message
+		if r.Header.Get("Content-Type") != "application/dns-message" || q.Unpack(body) != nil || len(q.Questions) != 1 {
+			http.Error(w, "bad query", http.StatusBadRequest)
+			return
+		}
+		question := q.Questions[0]
+		asked = append(asked, question.Name.String()+" "+question.Type.String())
+		m := dnsmessage.Message{Header: dnsmessage.Header{ID: q.ID, Response: true}, Questions: q.Questions}
+		if question.Type == dnsmessage.TypeA {
+			m.Answers = []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+				Body:   &dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}},
+			}}
+		}
+		packed, _ := m.Pack()
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(packed)
+	}))
+	defer doh.Close()
+
+	body, err := resolverGet(t, ResolverConfig{DNS: doh.URL + "/dns-query"}, "http://upgrade.bitdefender.test:"+port+"/")
+	if err != nil {
+		t.Fatalf("Expected DoH lookup to succeed, got %v", err)
+	}
+	if body != "ok from upgrade.bitdefender.test:"+port || len(asked) != 2 || asked[0] != "upgrade.bitdefender.test. TypeA" {
+		t.Errorf("Expected A and AAAA queries, got %v and %q", asked, body)
+	}
+}
+
+func TestIsLocalIP(t *testing.T) {
+	if !isLocalIP(net.ParseIP("127.0.0.1")) || !isLocalIP(net.ParseIP("::1")) {
+		t.Errorf("Expected loopback to be local")
+	}
+	if isLocalIP(net.ParseIP("192.0.2.1")) {
+		t.Errorf("Expected documentation address not to be local")
+	}
+}
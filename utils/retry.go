@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// maxBackoff caps the exponential pause between attempts
+	maxBackoff = 5 * time.Minute
+	// maxRetryAfter is the longest Retry-After worth waiting for, with a longer one the request fails at once
+	maxRetryAfter = 10 * time.Minute
+)
+
+// HTTPError is returned when a request failed after all attempts or with a status that is not retried
+type HTTPError struct {
+	URL        string
+	StatusCode int   // статус последнего полученного ответа, 0 - ответа не было
+	Attempts   int   // сколько запросов было сделано
+	Err        error // причина последней неудачи
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("failed to GET %s after %d attempt(s): %v", e.URL, e.Attempts, e.Err)
+}
+
+func (e *HTTPError) Unwrap() error { return e.Err }
+
+// StatusCode returns the HTTP status carried by err, 0 if no response was received
+func StatusCode(err error) int {
+	var he *HTTPError
+	if errors.As(err, &he) {
+		return he.StatusCode
+	}
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.code
+	}
+	return 0
+}
+
+// statusError is a response with an unexpected status
+type statusError struct {
+	code       int
+	retryAfter time.Duration // 0 - Retry-After не задан
+}
+
+func newStatusError(resp *http.Response) *statusError {
+	ra, _ := RetryAfter(resp.Header, time.Now())
+	return &statusError{code: resp.StatusCode, retryAfter: ra}
+}
+
+func (e *statusError) Error() string { return fmt.Sprintf("bad status: %d", e.code) }
+
+// RetryableStatus reports whether a request that got this status is worth repeating:
+// timeouts, rate limiting and server errors. Other client errors (404, 401, 403...) will not go away.
+func RetryableStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests:
+		return true
+	}
+	return code >= 500
+}
+
+// RetryAfter parses the Retry-After header: delay in seconds or an HTTP date
+func RetryAfter(h http.Header, now time.Time) (time.Duration, bool) {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(t.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// Backoff returns the pause before retry n (1-based): base doubled for every retry, capped at maxBackoff.
+// The pause is randomized between half and full length, so mirrors do not hit upstream in step.
+func Backoff(base time.Duration, n int) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+	d := maxBackoff
+	if n < 1 {
+		n = 1
+	}
+	// Сравниваем без сдвига base: при большой задержке он переполняется
+	if n <= 30 && base <= maxBackoff>>(n-1) {
+		d = base << (n - 1)
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// retryWait returns the pause before retry n after err, or false if retrying is pointless:
+// a status that is not retryable or a Retry-After longer than maxRetryAfter.
+// Retry-After longer than the backoff replaces it.
+func retryWait(err error, n int, base time.Duration) (time.Duration, bool) {
+	wait := Backoff(base, n)
+	var se *statusError
+	if errors.As(err, &se) {
+		if !RetryableStatus(se.code) || se.retryAfter > maxRetryAfter {
+			return 0, false
+		}
+		wait = max(wait, se.retryAfter)
+	}
+	return wait, true
+}
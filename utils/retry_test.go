@@ -0,0 +1,150 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		n        int
+		min, max time.Duration
+	}{
+		{1, 5 * time.Second, 10 * time.Second},
+		{2, 10 * time.Second, 20 * time.Second},
+		{4, 40 * time.Second, 80 * time.Second},
+		{10, maxBackoff / 2, maxBackoff},
+		{100, maxBackoff / 2, maxBackoff},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			if d := Backoff(10*time.Second, tt.n); d < tt.min || d > tt.max {
+				t.Errorf("Backoff(10s, %d): expected %v-%v, got %v", tt.n, tt.min, tt.max, d)
+			}
+		}
+	}
+	if d := Backoff(0, 3); d != 0 {
+		t.Errorf("Expected no pause for zero delay, got %v", d)
+	}
+}
+
+func TestBackoff_LargeBase(t *testing.T) {
+	// Большие задержки не должны переполняться при сдвиге, пауза всегда в пределах maxBackoff
+	for _, base := range []time.Duration{30 * time.Second, time.Minute, time.Hour, 24 * time.Hour} {
+		for n := 1; n <= 40; n++ {
+			d := Backoff(base, n)
+			if d <= 0 || d > maxBackoff {
+				t.Errorf("Backoff(%v, %d): expected 0-%v, got %v", base, n, maxBackoff, d)
+			}
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2025, 3, 14, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"120", 2 * time.Minute, true},
+		{"Fri, 14 Mar 2025 12:00:30 GMT", 30 * time.Second, true},
+		{"Fri, 14 Mar 2025 11:00:00 GMT", 0, true},
+		{"", 0, false},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		h := http.Header{}
+		if tt.value != "" {
+			h.Set("Retry-After", tt.value)
+		}
+		got, ok := RetryAfter(h, now)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("RetryAfter(%q): expected %v %v, got %v %v", tt.value, tt.want, tt.ok, got, ok)
+		}
+	}
+}
+
+func TestHTTPGetWithRetry_FailFast(t *testing.T) {
+	for _, code := range []int{http.StatusNotFound, http.StatusUnauthorized, http.StatusForbidden} {
+		attempts := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			attempts++
+			w.WriteHeader(code)
+		}))
+
+		_, err := HTTPGetWithRetry(context.Background(), server.URL, 3, time.Millisecond, "")
+		server.Close()
+		var herr *HTTPError
+		if !errors.As(err, &herr) {
+			t.Fatalf("Expected *HTTPError, got %v", err)
+		}
+		if herr.StatusCode != code || StatusCode(err) != code {
+			t.Errorf("Expected status %d in error, got %d", code, herr.StatusCode)
+		}
+		if attempts != 1 || herr.Attempts != 1 {
+			t.Errorf("Status %d: expected 1 attempt, got %d", code, attempts)
+		}
+	}
+}
+
+func TestHTTPGetWithRetry_RetryAfter(t *testing.T) {
+	var times []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		times = append(times, time.Now())
+		if len(times) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	resp, err := HTTPGetWithRetry(context.Background(), server.URL, 1, time.Millisecond, "")
+	if err != nil {
+		t.Fatalf("HTTPGetWithRetry failed: %v", err)
+	}
+	resp.Body.Close()
+	// Retry-After длиннее backoff заменяет его
+	if len(times) != 2 || times[1].Sub(times[0]) < time.Second {
+		t.Errorf("Expected retry after 1s, got %d requests", len(times))
+	}
+}
+
+func TestHTTPGetWithRetry_RetryAfterTooLong(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		attempts++
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	_, err := HTTPGetWithRetry(context.Background(), server.URL, 3, time.Millisecond, "")
+	if StatusCode(err) != http.StatusServiceUnavailable || attempts != 1 {
+		t.Errorf("Expected to give up after 1 attempt with 503, got %d attempts: %v", attempts, err)
+	}
+}
+
+func TestDownloadFile_FailFast(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	err := DownloadFile(context.Background(), server.URL, filepath.Join(t.TempDir(), "file.bin"), "", 3, time.Millisecond)
+	if StatusCode(err) != http.StatusNotFound {
+		t.Errorf("Expected 404 in error, got %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("Expected 1 request, got %d", requests)
+	}
+}
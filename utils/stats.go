@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// DownloadStats counts traffic of all downloads made with one context
+type DownloadStats struct {
+	bytes atomic.Int64
+	files atomic.Int64
+}
+
+// Bytes returns the number of body bytes read so far
+func (s *DownloadStats) Bytes() int64 { return s.bytes.Load() }
+
+// Files returns the number of successful responses
+func (s *DownloadStats) Files() int64 { return s.files.Load() }
+
+type downloadStatsKey struct{}
+
+// WithDownloadStats returns a context whose downloads are counted in the returned stats
+func WithDownloadStats(ctx context.Context) (context.Context, *DownloadStats) {
+	stats := &DownloadStats{}
+	return context.WithValue(ctx, downloadStatsKey{}, stats), stats
+}
+
+// DownloadStatsFrom returns the stats attached to ctx or nil
+func DownloadStatsFrom(ctx context.Context) *DownloadStats {
+	stats, _ := ctx.Value(downloadStatsKey{}).(*DownloadStats)
+	return stats
+}
+
+// TrackResponse counts resp as a downloaded file and its body bytes in the stats of ctx.
+// It does nothing if ctx has no stats.
+func TrackResponse(ctx context.Context, resp *http.Response) {
+	stats := DownloadStatsFrom(ctx)
+	if stats == nil || resp == nil || resp.Body == nil {
+		return
+	}
+	stats.files.Add(1)
+	resp.Body = &countingBody{ReadCloser: resp.Body, stats: stats}
+}
+
+type countingBody struct {
+	io.ReadCloser
+	stats *DownloadStats
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.stats.bytes.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,123 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TLSConfig configures TLS of upstream connections. Zero fields keep the Go defaults.
+type TLSConfig struct {
+	CAFiles    []string // PEM с корневыми сертификатами, добавляются к системным
+	CertFile   string   // клиентский сертификат PEM
+	KeyFile    string   // его ключ, пусто - ключ в CertFile
+	MinVersion uint16   // tls.VersionTLS12, tls.VersionTLS13...
+	Pins       []string // SHA-256 от SubjectPublicKeyInfo в base64, с сертификатом цепочки должен совпасть хотя бы один
+}
+
+func (t TLSConfig) empty() bool {
+	return len(t.CAFiles) == 0 && t.CertFile == "" && t.KeyFile == "" && t.MinVersion == 0 && len(t.Pins) == 0
+}
+
+// merge returns t with the host settings applied: CA files and pins are added,
+// the client certificate and minimum version are replaced
+func (t TLSConfig) merge(host TLSConfig) TLSConfig {
+	t.CAFiles = append(append([]string(nil), t.CAFiles...), host.CAFiles...)
+	t.Pins = append(append([]string(nil), t.Pins...), host.Pins...)
+	if host.CertFile != "" {
+		t.CertFile, t.KeyFile = host.CertFile, host.KeyFile
+	}
+	if host.MinVersion != 0 {
+		t.MinVersion = host.MinVersion
+	}
+	return t
+}
+
+// Build loads the files of t and returns the TLS settings of a transport, nil if t is empty
+func (t TLSConfig) Build() (*tls.Config, error) {
+	if t.empty() {
+		return nil, nil
+	}
+	tc := &tls.Config{MinVersion: t.MinVersion}
+	if len(t.CAFiles) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		for _, f := range t.CAFiles {
+			data, err := os.ReadFile(f)
+			if err != nil {
+				return nil, fmt.Errorf("CA file: %w", err)
+			}
+			if !pool.AppendCertsFromPEM(data) {
+				return nil, fmt.Errorf("CA file %s: no PEM certificates", f)
+			}
+		}
+		tc.RootCAs = pool
+	}
+	if t.CertFile != "" || t.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, firstNonEmpty(t.KeyFile, t.CertFile))
+		if err != nil {
+			return nil, fmt.Errorf("client certificate: %w", err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	if len(t.Pins) > 0 {
+		pins := map[string]bool{}
+		for _, p := range t.Pins {
+			pin, err := ParsePin(p)
+			if err != nil {
+				return nil, err
+			}
+			pins[pin] = true
+		}
+		// Вызывается после обычной проверки цепочки, пин её не заменяет
+		tc.VerifyConnection = func(cs tls.ConnectionState) error {
+			for _, chain := range cs.VerifiedChains {
+				for _, cert := range chain {
+					if pins[SPKIPin(cert)] {
+						return nil
+					}
+				}
+			}
+			return fmt.Errorf("certificate of %s does not match the pinned keys", cs.ServerName)
+		}
+	}
+	return tc, nil
+}
+
+// SPKIPin returns the pin of a certificate: base64 of SHA-256 of its SubjectPublicKeyInfo
+func SPKIPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// ParsePin accepts a pin as base64 of SHA-256 with an optional "sha256/" prefix
+func ParsePin(s string) (string, error) {
+	pin := strings.TrimPrefix(strings.TrimSpace(s), "sha256/")
+	if raw, err := base64.StdEncoding.DecodeString(pin); err != nil || len(raw) != sha256.Size {
+		return "", fmt.Errorf("invalid pin %q, expected sha256/<base64 of SHA-256>", s)
+	}
+	return pin, nil
+}
+
+// ParseTLSVersion parses a minimum TLS version: "1.0", "1.1", "1.2" or "1.3" (optionally "tls1.2"), "" is the Go default
+func ParseTLSVersion(s string) (uint16, error) {
+	switch strings.TrimPrefix(strings.ToLower(strings.TrimSpace(s)), "tls") {
+	case "":
+		return 0, nil
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unknown TLS version %q", s)
+}
@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// tlsServer запускает HTTPS-сервер и сохраняет его сертификат в PEM-файл
+func tlsServer(t *testing.T, maxVersion uint16) (*httptest.Server, string) {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	srv.TLS = &tls.Config{MaxVersion: maxVersion}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(caFile, data, 0644); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+	return srv, caFile
+}
+
+func tlsGet(t *testing.T, cfg ClientConfig, url string) error {
+	t.Helper()
+	client, err := NewClient(cfg).HTTPClient("", 0)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func TestTLS_CAFiles(t *testing.T) {
+	srv, caFile := tlsServer(t, 0)
+
+	if err := tlsGet(t, ClientConfig{}, srv.URL); err == nil {
+		t.Errorf("Expected an unknown authority error without the CA file")
+	}
+	if err := tlsGet(t, ClientConfig{TLS: TLSConfig{CAFiles: []string{caFile}}}, srv.URL); err != nil {
+		t.Errorf("Expected success with the CA file, got %v", err)
+	}
+	// CA только для этого хоста: у хоста свой транспорт
+	hosts := map[string]HostConfig{"127.0.0.1": {TLS: TLSConfig{CAFiles: []string{caFile}}}}
+	if err := tlsGet(t, ClientConfig{Hosts: hosts}, srv.URL); err != nil {
+		t.Errorf("Expected success with the host CA file, got %v", err)
+	}
+	if err := tlsGet(t, ClientConfig{TLS: TLSConfig{CAFiles: []string{filepath.Join(t.TempDir(), "missing.pem")}}}, srv.URL); err == nil {
+		t.Errorf("Expected an error for a missing CA file")
+	}
+}
+
+func TestTLS_Pins(t *testing.T) {
+	srv, caFile := tlsServer(t, 0)
+	pin := "sha256/" + SPKIPin(srv.Certificate())
+	other := "sha256/" + strings.Repeat("A", 43) + "="
+
+	tests := []struct {
+		pins []string
+		ok   bool
+	}{
+		{[]string{pin}, true},
+		{[]string{other, pin}, true},
+		{[]string{other}, false},
+	}
+	for _, tt := range tests {
+		cfg := ClientConfig{TLS: TLSConfig{CAFiles: []string{caFile}}, Hosts: map[string]HostConfig{"127.0.0.1": {TLS: TLSConfig{Pins: tt.pins}}}}
+		if err := tlsGet(t, cfg, srv.URL); (err == nil) != tt.ok {
+			t.Errorf("pins %v: expected ok=%v, got %v", tt.pins, tt.ok, err)
+		}
+	}
+	if _, err := ParsePin("sha256/short"); err == nil {
+		t.Errorf("Expected an error for an invalid pin")
+	}
+}
+
+func TestTLS_MinVersion(t *testing.T) {
+	srv, caFile := tlsServer(t, tls.VersionTLS12)
+	if err := tlsGet(t, ClientConfig{TLS: TLSConfig{CAFiles: []string{caFile}, MinVersion: tls.VersionTLS13}}, srv.URL); err == nil {
+		t.Errorf("Expected a TLS 1.2 server to be rejected with minimum TLS 1.3")
+	}
+	if err := tlsGet(t, ClientConfig{TLS: TLSConfig{CAFiles: []string{caFile}, MinVersion: tls.VersionTLS12}}, srv.URL); err != nil {
+		t.Errorf("Expected success with minimum TLS 1.2, got %v", err)
+	}
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected uint16
+		ok       bool
+	}{
+		{"", 0, true},
+		{"1.2", tls.VersionTLS12, true},
+		{"TLS1.3", tls.VersionTLS13, true},
+		{"1.4", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseTLSVersion(tt.s)
+		if (err == nil) != tt.ok || got != tt.expected {
+			t.Errorf("ParseTLSVersion(%q): expected %d ok=%v, got %d, %v", tt.s, tt.expected, tt.ok, got, err)
+		}
+	}
+}